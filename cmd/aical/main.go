@@ -16,6 +16,8 @@ import (
 	"github.com/go-kratos/kratos/v2/transport/http"
 
 	_ "go.uber.org/automaxprocs"
+	// embed the time zone database, the runtime image has none
+	_ "time/tzdata"
 )
 
 // go build -ldflags "-X 'main.version=0.0.1a' -X 'main.name=aical'"
@@ -50,29 +50,52 @@ func wireApp(confServer *conf.Server, confData *conf.Data, google *conf.Google,
 	userService := service.NewUserService(logger, userUseCase, googleUseCase)
 	calendarRepo := data.NewCalendarRepo(dataData, logger)
 	eventRepo := data.NewEventRepo(dataData, logger)
-	chatUseCase := biz.NewChatUseCase(openAI, logger, googleRepo, calendarRepo, eventRepo)
-	chatService := service.NewChatService(chatUseCase, googleUseCase, userUseCase, logger)
-	httpServer := server.NewHTTPServer(confServer, logger, authService, userService, chatService)
-	grpcServer := server.NewGRPCServer(confServer, logger)
+	provider, err := data.NewLLMProvider(openAI, logger)
+	if err != nil {
+		cleanup2()
+		cleanup()
+		return nil, nil, err
+	}
+	conversationRepo := data.NewConversationRepo(dataData, openAI, logger)
+	usageRepo := data.NewUsageRepo(dataData, logger)
+	usageUseCase := biz.NewUsageUseCase(openAI, usageRepo, logger)
+	pendingActionRepo := data.NewPendingActionRepo(dataData, logger)
+	toolset := biz.NewToolset(openAI, logger, googleRepo, calendarRepo, eventRepo, pendingActionRepo)
+	chatUseCase := biz.NewChatUseCase(openAI, logger, provider, toolset, userRepo, conversationRepo, usageUseCase)
+	eventHistoryRepo := data.NewEventHistoryRepo(dataData, logger)
+	undoUseCase := biz.NewUndoUseCase(logger, eventHistoryRepo, eventRepo, calendarRepo, googleRepo)
 	calendarUseCase := biz.NewCalendarUseCase(calendarRepo, logger)
 	eventUseCase := biz.NewEventUseCase(eventRepo, logger)
-	eventHistoryRepo := data.NewEventHistoryRepo(dataData, logger)
 	eventHistoryUseCase := biz.NewEventHistoryUseCase(eventHistoryRepo, logger)
-	openAIUseCase := biz.NewOpenAIUseCase(openAI, logger, googleRepo)
-	cronService := service.NewCronService(cron, logger, userUseCase, calendarUseCase, eventUseCase, eventHistoryUseCase, googleUseCase, openAIUseCase)
-	cronServer, err := server.NewCronServer(cron, logger, cronService)
+	openAIUseCase := biz.NewOpenAIUseCase(openAI, logger, provider, toolset, googleRepo, usageUseCase)
+	autopilotUseCase := biz.NewAutopilotUseCase(userRepo, calendarRepo, eventHistoryRepo, googleRepo, openAIUseCase, logger)
+	watchChannelRepo := data.NewWatchChannelRepo(dataData, logger)
+	watchUseCase := biz.NewWatchUseCase(watchChannelRepo, googleRepo, logger)
+	lockRepo := data.NewLockRepo(dataData, logger)
+	lockUseCase := biz.NewLockUseCase(lockRepo, logger)
+	botAPI, err := data.NewTGBot(confServer)
 	if err != nil {
 		cleanup2()
 		cleanup()
 		return nil, nil, err
 	}
-	tgService := service.NewTGService(logger)
-	tgServer, err := server.NewTGServer(confServer, logger, tgService, authService, chatService)
+	notifier := data.NewNotifier(botAPI, logger)
+	notificationUseCase := biz.NewNotificationUseCase(notifier, logger)
+	changeNotificationUseCase := biz.NewChangeNotificationUseCase(eventHistoryRepo, calendarRepo, userRepo, notificationUseCase, logger)
+	chatService := service.NewChatService(chatUseCase, undoUseCase, googleUseCase, userUseCase, changeNotificationUseCase, logger)
+	jobRegistry := service.NewJobRegistry(cron, lockUseCase, logger)
+	cronService := service.NewCronService(cron, google, logger, userUseCase, calendarUseCase, eventUseCase, eventHistoryUseCase, googleUseCase, autopilotUseCase, watchUseCase, lockUseCase, notificationUseCase, changeNotificationUseCase, jobRegistry)
+	adminService := service.NewAdminService(confServer, cronService, jobRegistry, logger)
+	httpServer := server.NewHTTPServer(confServer, logger, authService, userService, chatService, cronService, adminService)
+	grpcServer := server.NewGRPCServer(confServer, logger, chatService, adminService)
+	cronServer, err := server.NewCronServer(cron, logger, cronService, jobRegistry)
 	if err != nil {
 		cleanup2()
 		cleanup()
 		return nil, nil, err
 	}
+	tgService := service.NewTGService(logger)
+	tgServer := server.NewTGServer(logger, botAPI, tgService, authService, chatService)
 	app := newApp(logger, httpServer, grpcServer, cronServer, tgServer)
 	return app, func() {
 		cleanup2()
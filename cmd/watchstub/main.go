@@ -0,0 +1,62 @@
+// Command watchstub sends a Google Calendar push notification to the webhook,
+// so the push sync can be tried without a public address.
+//
+// The channel ID and the token are stored in the watch_channels table when the channel is registered:
+//
+//	go run ./cmd/watchstub -channel <channel ID> -token <channel token>
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+var (
+	webhook  string
+	channel  string
+	token    string
+	resource string
+	state    string
+	number   int
+)
+
+func init() {
+	flag.StringVar(&webhook, "webhook", "http://localhost:8000/webhook/google/calendar", "webhook URL")
+	flag.StringVar(&channel, "channel", "", "watch channel ID")
+	flag.StringVar(&token, "token", "", "watch channel token")
+	flag.StringVar(&resource, "resource", "stub-resource", "watched resource ID")
+	flag.StringVar(&state, "state", "exists", "resource state: sync, exists or not_exists")
+	flag.IntVar(&number, "number", 1, "message number")
+}
+
+func main() {
+	flag.Parse()
+	if channel == "" {
+		fmt.Fprintln(os.Stderr, "watchstub: -channel is required")
+		os.Exit(2)
+	}
+	req, err := http.NewRequest(http.MethodPost, webhook, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "watchstub: %v\n", err)
+		os.Exit(1)
+	}
+	// the headers Google sends with every notification
+	req.Header.Set("X-Goog-Channel-ID", channel)
+	req.Header.Set("X-Goog-Channel-Token", token)
+	req.Header.Set("X-Goog-Resource-ID", resource)
+	req.Header.Set("X-Goog-Resource-State", state)
+	req.Header.Set("X-Goog-Message-Number", strconv.Itoa(number))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "watchstub: %v\n", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+	fmt.Println(resp.Status)
+	if resp.StatusCode != http.StatusOK {
+		os.Exit(1)
+	}
+}
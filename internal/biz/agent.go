@@ -0,0 +1,51 @@
+package biz
+
+import (
+	"errors"
+	pb "github.com/kdimtricp/aical/api/chat/v1"
+	"github.com/kdimtricp/aical/internal/conf"
+	"github.com/kdimtricp/aical/pkg/openai"
+)
+
+// runnerOptions returns the agent loop limits from config
+func runnerOptions(cfg *conf.OpenAI) []openai.RunnerOption {
+	opts := []openai.RunnerOption{
+		openai.WithMaxSteps(int(cfg.GetAgent().GetMaxSteps())),
+		openai.WithMaxRepeatedCalls(int(cfg.GetAgent().GetMaxRepeatedCalls())),
+	}
+	if cfg.GetAgent().GetTimeout() != nil {
+		opts = append(opts, openai.WithTimeout(cfg.GetAgent().GetTimeout().AsDuration()))
+	}
+	return opts
+}
+
+// agentError converts agent loop errors to API errors with a message that can be shown to the user
+func agentError(err error) error {
+	switch {
+	case errors.Is(err, openai.ErrMaxSteps):
+		return pb.ErrorAgentMaxSteps("I could not finish the request in a reasonable number of steps, please try to simplify it.")
+	case errors.Is(err, openai.ErrLoopDetected):
+		return pb.ErrorAgentLoopDetected("I got stuck repeating the same calendar operation, please rephrase the request.")
+	case errors.Is(err, openai.ErrTimeout):
+		return pb.ErrorAgentTimeout("The request took too long, please try again.")
+	case errors.Is(err, openai.ErrLength):
+		return pb.ErrorAgentAnswerTruncated("The answer was too long, please ask for less at once.")
+	case errors.Is(err, openai.ErrContentFilter):
+		return pb.ErrorAgentContentFiltered("The request was blocked by the content filter.")
+	case errors.Is(err, openai.ErrUnexpectedFinish),
+		errors.Is(err, openai.ErrEmptyCompletion),
+		errors.Is(err, openai.ErrMissingToolCalls):
+		return pb.ErrorAgentUnexpectedFinish("The assistant returned an unexpected response, please try again.")
+	case errors.Is(err, openai.ErrRateLimited):
+		return pb.ErrorLlmRateLimited("The assistant is busy right now, please try again in a minute.")
+	case errors.Is(err, openai.ErrContextLengthExceeded):
+		return pb.ErrorLlmContextLengthExceeded("The conversation is too long, please start over with /reset.")
+	case errors.Is(err, openai.ErrAuthFailed),
+		errors.Is(err, openai.ErrQuotaExceeded),
+		errors.Is(err, openai.ErrServerError),
+		errors.Is(err, openai.ErrStreamInterrupted):
+		return pb.ErrorLlmUnavailable("The assistant is unavailable right now, please try again later.")
+	default:
+		return err
+	}
+}
@@ -0,0 +1,185 @@
+package biz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	pb "github.com/kdimtricp/aical/api/chat/v1"
+	"strings"
+)
+
+//goland:noinspection GoSnakeCaseUsage
+const (
+	RESPONSE_NEEDS_ACTION = "needsAction"
+	RESPONSE_ACCEPTED     = "accepted"
+	RESPONSE_DECLINED     = "declined"
+	RESPONSE_TENTATIVE    = "tentative"
+
+	SEND_UPDATES_ALL           = "all"
+	SEND_UPDATES_EXTERNAL_ONLY = "externalOnly"
+	SEND_UPDATES_NONE          = "none"
+	// DEFAULT_SEND_UPDATES notifies the guests of the changes made in chat
+	DEFAULT_SEND_UPDATES = SEND_UPDATES_ALL
+
+	MAX_CONTACTS = 10
+)
+
+// Attendee is a guest of the event
+type Attendee struct {
+	Email          string `json:"email"`
+	Name           string `json:"name,omitempty"`
+	ResponseStatus string `json:"response_status,omitempty"`
+	Comment        string `json:"comment,omitempty"`
+	Optional       bool   `json:"optional,omitempty"`
+	Organizer      bool   `json:"organizer,omitempty"`
+	// Self is the attendee of the calendar owner
+	Self bool `json:"self,omitempty"`
+}
+
+// String .
+func (a *Attendee) String() string {
+	if a.ResponseStatus == "" {
+		return a.Email
+	}
+	return fmt.Sprintf("%s (%s)", a.Email, a.ResponseStatus)
+}
+
+// Contact is a person the user can invite
+type Contact struct {
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email"`
+}
+
+type rsvpEventArgs struct {
+	GoogleCalendarID string `json:"google_calendar_id" description:"The ID of the Google calendar of the invitation."`
+	GoogleEventID    string `json:"google_event_id" description:"The Google ID of the event."`
+	Response         string `json:"response" enum:"accepted,declined,tentative" description:"The answer to the invitation."`
+	Comment          string `json:"comment,omitempty" description:"A note for the organizer."`
+}
+
+type findContactsArgs struct {
+	Query string `json:"query" description:"The name or the part of the email address to search for."`
+}
+
+// sendUpdates returns who is notified about a change, the guests by default
+func sendUpdates(value string) string {
+	if value == "" {
+		return DEFAULT_SEND_UPDATES
+	}
+	return value
+}
+
+// withAttendees returns the attendees with the emails added and removed, the emails are compared case-insensitively
+func withAttendees(attendees []*Attendee, add []string, remove []string) []*Attendee {
+	removed := make(map[string]bool, len(remove))
+	for _, email := range remove {
+		removed[strings.ToLower(email)] = true
+	}
+	result := make([]*Attendee, 0, len(attendees)+len(add))
+	present := make(map[string]bool, len(attendees))
+	for _, a := range attendees {
+		email := strings.ToLower(a.Email)
+		if removed[email] || present[email] {
+			continue
+		}
+		present[email] = true
+		result = append(result, a)
+	}
+	for _, email := range add {
+		if key := strings.ToLower(email); !removed[key] && !present[key] {
+			present[key] = true
+			result = append(result, &Attendee{Email: email, ResponseStatus: RESPONSE_NEEDS_ACTION})
+		}
+	}
+	return result
+}
+
+func attendeeEmails(attendees []*Attendee) string {
+	emails := make([]string, len(attendees))
+	for i, a := range attendees {
+		emails[i] = a.Email
+	}
+	return strings.Join(emails, ", ")
+}
+
+// rsvpEvent answers the invitation to the event on behalf of the user
+func (t *calendarTools) rsvpEvent(ctx context.Context, args rsvpEventArgs) (*eventResult, error) {
+	t.log.Debugf("rsvpEvent: %+v", args)
+	token := GetToken(ctx)
+	if token == nil {
+		return nil, errTokenNotFound
+	}
+	switch args.Response {
+	case RESPONSE_ACCEPTED, RESPONSE_DECLINED, RESPONSE_TENTATIVE:
+	default:
+		return nil, fmt.Errorf("unknown response %q", args.Response)
+	}
+	e, err := t.gr.RespondCalendarEvent(ctx, token, &Event{GoogleID: args.GoogleEventID}, googleCalendarID(args.GoogleCalendarID), args.Response, args.Comment)
+	if err != nil {
+		return nil, err
+	}
+	t.recordUpdated(ctx, args.GoogleCalendarID, e)
+	return newEventResult(e), nil
+}
+
+// findContacts resolves a name to the email addresses of the user contacts
+func (t *calendarTools) findContacts(ctx context.Context, args findContactsArgs) ([]*Contact, error) {
+	t.log.Debugf("findContacts: %+v", args)
+	token := GetToken(ctx)
+	if token == nil {
+		return nil, errTokenNotFound
+	}
+	if strings.TrimSpace(args.Query) == "" {
+		return nil, fmt.Errorf("query is empty")
+	}
+	contacts, err := t.gr.SearchContacts(ctx, token, args.Query, MAX_CONTACTS)
+	if errors.Is(err, ErrContactsScopeMissing) {
+		// the user logged in before the contacts were asked for, the guests of the stored events are searched instead
+		t.log.Debugf("findContacts: %v, searching the event guests", err)
+		if contacts := t.findGuests(ctx, args.Query); len(contacts) > 0 {
+			return contacts, nil
+		}
+		return nil, pb.ErrorContactsScopeMissing("The contacts are not allowed for the assistant yet, run /login again to allow them, or give the email address.")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("contacts are not available, ask the user for the email address: %w", err)
+	}
+	return contacts, nil
+}
+
+// findGuests returns the guests of the stored events of the user matching the name or email
+func (t *calendarTools) findGuests(ctx context.Context, query string) []*Contact {
+	user := GetUser(ctx)
+	if user == nil {
+		return nil
+	}
+	calendars, err := t.cr.List(ctx, user.ID)
+	if err != nil {
+		t.log.Errorf("findGuests: list calendars: %v", err)
+		return nil
+	}
+	query = strings.ToLower(strings.TrimSpace(query))
+	var contacts []*Contact
+	seen := make(map[string]bool)
+	for _, c := range calendars {
+		events, err := t.er.List(ctx, c.ID)
+		if err != nil {
+			t.log.Errorf("findGuests: list events of calendar %s: %v", c.ID, err)
+			continue
+		}
+		for _, e := range events {
+			for _, a := range e.Attendees {
+				if a.Self || a.Email == "" || seen[a.Email] ||
+					!strings.Contains(strings.ToLower(a.Email), query) && !strings.Contains(strings.ToLower(a.Name), query) {
+					continue
+				}
+				seen[a.Email] = true
+				contacts = append(contacts, &Contact{Name: a.Name, Email: a.Email})
+				if len(contacts) >= MAX_CONTACTS {
+					return contacts
+				}
+			}
+		}
+	}
+	return contacts
+}
@@ -0,0 +1,154 @@
+package biz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-kratos/kratos/v2/log"
+	"golang.org/x/oauth2"
+	"time"
+)
+
+//goland:noinspection GoSnakeCaseUsage
+const (
+	FUNCTION_PROPOSE_EVENT = "propose_event"
+
+	// AUTOPILOT_CALENDAR_NAME is the calendar the confirmed proposals are created in
+	AUTOPILOT_CALENDAR_NAME = "AI Planner"
+	// AUTOPILOT_LOOKBACK is how far back the first planning of the user looks for the changes
+	AUTOPILOT_LOOKBACK = 24 * time.Hour
+	// AUTOPILOT_MAX_CHANGES limits the changes given to the model, the most recent are kept
+	AUTOPILOT_MAX_CHANGES = 50
+)
+
+var errAutopilotCalendarNotFound = errors.New("the user has no autopilot calendar")
+
+type proposeEventArgs struct {
+	Title       string    `json:"title" description:"The summary or title of the event."`
+	Location    string    `json:"location,omitempty" description:"The location of the event."`
+	StartTime   time.Time `json:"start_time" description:"The start time of the event in RFC3339 format."`
+	EndTime     time.Time `json:"end_time" description:"The end time of the event in RFC3339 format."`
+	Description string    `json:"description,omitempty" description:"The description or agenda of the event."`
+	Reason      string    `json:"reason" description:"Why the event helps the plan of the user, it is shown with the proposal."`
+}
+
+// proposeEvent creates the proposed event in the autopilot calendar of the user
+func (t *calendarTools) proposeEvent(ctx context.Context, args proposeEventArgs) (*changedEventResult, error) {
+	user := GetUser(ctx)
+	if user == nil || user.AutopilotCalendarID == "" {
+		return nil, errAutopilotCalendarNotFound
+	}
+	// the proposal is checked against the primary calendar too, the user is busy with its events
+	return t.createEventChecked(ctx, createEventArgs{
+		GoogleCalendarID: user.AutopilotCalendarID,
+		Title:            args.Title,
+		Location:         args.Location,
+		StartTime:        args.StartTime,
+		EndTime:          args.EndTime,
+		Description:      args.Description,
+		SendUpdates:      SEND_UPDATES_NONE,
+	}, []string{user.AutopilotCalendarID, DEFAULT_GOOGLE_CALENDAR_ID})
+}
+
+// proposeEventPreview describes the proposed event and the reason of the proposal
+func (t *calendarTools) proposeEventPreview(ctx context.Context, args proposeEventArgs) string {
+	loc := userLocation(ctx)
+	preview := fmt.Sprintf("Add %q to %s: %s – %s", args.Title, AUTOPILOT_CALENDAR_NAME, previewTime(args.StartTime, loc), previewTime(args.EndTime, loc))
+	if args.Location != "" {
+		preview += fmt.Sprintf("\nlocation: %s", args.Location)
+	}
+	if args.Reason != "" {
+		preview += fmt.Sprintf("\nwhy: %s", args.Reason)
+	}
+	return preview
+}
+
+type AutopilotUseCase struct {
+	ur  UserRepo
+	cr  CalendarRepo
+	ehr EventHistoryRepo
+	gr  GoogleRepo
+	ai  *OpenAIUseCase
+	log *log.Helper
+}
+
+func NewAutopilotUseCase(ur UserRepo, cr CalendarRepo, ehr EventHistoryRepo, gr GoogleRepo, ai *OpenAIUseCase, logger log.Logger) *AutopilotUseCase {
+	return &AutopilotUseCase{
+		ur:  ur,
+		cr:  cr,
+		ehr: ehr,
+		gr:  gr,
+		ai:  ai,
+		log: log.NewHelper(log.With(logger, "caller", "biz.autopilot.usecase")),
+	}
+}
+
+// Plan asks the model to plan around the calendar changes the user made since the previous planning.
+// The proposed events are returned as pending actions, they land in the autopilot calendar only when confirmed.
+// The context holds the user and the google token.
+func (uc *AutopilotUseCase) Plan(ctx context.Context, user *User) ([]*PendingAction, error) {
+	uc.log.Debugf("autopilot use case: plan for user %s", user.ID)
+	token := GetToken(ctx)
+	if token == nil {
+		return nil, errTokenNotFound
+	}
+	if err := uc.ensureCalendar(ctx, token, user); err != nil {
+		return nil, fmt.Errorf("autopilot calendar: %w", err)
+	}
+	now := time.Now()
+	since := user.AutopilotPlannedAt
+	if since.IsZero() {
+		since = now.Add(-AUTOPILOT_LOOKBACK)
+	}
+	changes, err := uc.userChanges(ctx, user, since)
+	if err != nil {
+		return nil, err
+	}
+	var actions []*PendingAction
+	if len(changes) > 0 {
+		if actions, err = uc.ai.ProposeEvents(ctx, user, changes); err != nil {
+			return nil, err
+		}
+	}
+	user.AutopilotPlannedAt = now
+	if err := uc.ur.SetAutopilotState(ctx, user.ID, user.AutopilotCalendarID, user.AutopilotPlannedAt); err != nil {
+		return nil, err
+	}
+	return actions, nil
+}
+
+// ensureCalendar creates the autopilot calendar of the user on the first planning
+func (uc *AutopilotUseCase) ensureCalendar(ctx context.Context, token *oauth2.Token, user *User) error {
+	if user.AutopilotCalendarID != "" {
+		return nil
+	}
+	calendar, err := uc.gr.CreateNewCalendar(ctx, token, AUTOPILOT_CALENDAR_NAME)
+	if err != nil {
+		return err
+	}
+	uc.log.Infof("autopilot use case: created calendar %s for user %s", calendar.GoogleID, user.ID)
+	user.AutopilotCalendarID = calendar.GoogleID
+	return uc.ur.SetAutopilotState(ctx, user.ID, user.AutopilotCalendarID, user.AutopilotPlannedAt)
+}
+
+// userChanges returns the synced changes of the user since the time, the changes of the autopilot calendar are left out
+func (uc *AutopilotUseCase) userChanges(ctx context.Context, user *User, since time.Time) ([]*EventHistory, error) {
+	changes, err := uc.ehr.ListUserChanges(ctx, user.ID, SYNC, since)
+	if err != nil {
+		return nil, err
+	}
+	// the autopilot calendar is synced as any other calendar once it exists
+	if calendar, err := uc.cr.Get(ctx, &Calendar{UserID: user.ID, GoogleID: user.AutopilotCalendarID}); err == nil {
+		filtered := changes[:0]
+		for _, change := range changes {
+			if change.CalendarID != calendar.ID {
+				filtered = append(filtered, change)
+			}
+		}
+		changes = filtered
+	}
+	if len(changes) > AUTOPILOT_MAX_CHANGES {
+		changes = changes[len(changes)-AUTOPILOT_MAX_CHANGES:]
+	}
+	return changes, nil
+}
@@ -14,4 +14,12 @@ var ProviderSet = wire.NewSet(
 	NewGoogleUseCase,
 	NewOpenAIUseCase,
 	NewChatUseCase,
+	NewUsageUseCase,
+	NewToolset,
+	NewUndoUseCase,
+	NewWatchUseCase,
+	NewLockUseCase,
+	NewNotificationUseCase,
+	NewAutopilotUseCase,
+	NewChangeNotificationUseCase,
 )
@@ -12,6 +12,8 @@ type Calendar struct {
 	UserID   uuid.UUID
 	GoogleID string
 	Summary  string
+	// SyncToken is the Google token of the last events sync, the next sync lists only the changes made after it
+	SyncToken string
 }
 
 // String is the string representation of the Calendar struct.
@@ -25,6 +27,8 @@ type CalendarRepo interface {
 	Delete(ctx context.Context, calendar *Calendar) error
 	Get(ctx context.Context, calendar *Calendar) (*Calendar, error)
 	List(ctx context.Context, userID uuid.UUID) ([]*Calendar, error)
+	// SetSyncToken stores the sync token of the calendar, an empty token makes the next sync a full one
+	SetSyncToken(ctx context.Context, id uuid.UUID, syncToken string) error
 }
 
 type CalendarUseCase struct {
@@ -59,15 +63,32 @@ func (uc *CalendarUseCase) ListUserCalendars(ctx context.Context, userID uuid.UU
 	return uc.db.List(ctx, userID)
 }
 
+// SetSyncToken stores the token of the last events sync of the calendar.
+func (uc *CalendarUseCase) SetSyncToken(ctx context.Context, calendar *Calendar, syncToken string) error {
+	uc.log.Debugf("calendar use case: set sync token of calendar %s", calendar.ID)
+	if err := uc.db.SetSyncToken(ctx, calendar.ID, syncToken); err != nil {
+		return err
+	}
+	calendar.SyncToken = syncToken
+	return nil
+}
+
+// Get gets a calendar by ID from the database.
+func (uc *CalendarUseCase) Get(ctx context.Context, id uuid.UUID) (*Calendar, error) {
+	uc.log.Debugf("calendar use case: get calendar %s", id)
+	return uc.db.Get(ctx, &Calendar{ID: id})
+}
+
 // Sync syncs down calendars. It will take incoming calendars and compare them to the ones in the database.
 // If the calendar exists in the database, it will update it. If it doesn't exist, it will create it.
 // If the calendar exists in the database but not in the incoming calendars, it will delete it.
-func (uc *CalendarUseCase) Sync(ctx context.Context, userID uuid.UUID, calendars []*Calendar) error {
+// The deleted calendars are returned, so the resources of them can be released.
+func (uc *CalendarUseCase) Sync(ctx context.Context, userID uuid.UUID, calendars []*Calendar) ([]*Calendar, error) {
 	uc.log.Debugf("calendar use case: sync calendars for user %s", userID)
 	// Get calendars from database
 	dbCalendars, err := uc.db.List(ctx, userID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	// Create a map of calendars from the database
 	dbCalendarsMap := make(map[string]*Calendar)
@@ -80,17 +101,19 @@ func (uc *CalendarUseCase) Sync(ctx context.Context, userID uuid.UUID, calendars
 		incomingCalendarsMap[c.GoogleID] = c
 	}
 	// Compare the two maps
+	var deleted []*Calendar
 	for _, c := range dbCalendars {
 		// If the calendar exists in the database, update it
 		if _, ok := incomingCalendarsMap[c.GoogleID]; ok {
 			if err := uc.db.Update(ctx, c); err != nil {
-				return err
+				return nil, err
 			}
 		} else {
 			// If the calendar exists in the database but not in the incoming calendars, delete it
 			if err := uc.db.Delete(ctx, c); err != nil {
-				return err
+				return nil, err
 			}
+			deleted = append(deleted, c)
 		}
 	}
 	// If the calendar doesn't exist in the database, create it
@@ -101,11 +124,11 @@ func (uc *CalendarUseCase) Sync(ctx context.Context, userID uuid.UUID, calendars
 				GoogleID: c.GoogleID,
 				Summary:  c.Summary,
 			}); err != nil {
-				return err
+				return nil, err
 			}
 		}
 	}
-	return nil
+	return deleted, nil
 }
 
 // get gets a calendar from the database.
@@ -0,0 +1,183 @@
+package biz
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/google/uuid"
+	pb "github.com/kdimtricp/aical/api/chat/v1"
+	"strings"
+	"time"
+)
+
+//goland:noinspection GoSnakeCaseUsage
+const (
+	// CHANGE_NOTIFICATION_MAX_AGE is how old a change may be to be notified, older changes are never sent
+	CHANGE_NOTIFICATION_MAX_AGE = 24 * time.Hour
+	// CHANGE_NOTIFICATION_MAX_CHANGES limits the changes listed in one message, the rest are counted
+	CHANGE_NOTIFICATION_MAX_CHANGES = 20
+)
+
+// ChangeNotificationUseCase tells the users about the changes others make to their events.
+// The synced changes are batched into one message per user and marked delivered once handled.
+type ChangeNotificationUseCase struct {
+	ehr EventHistoryRepo
+	cr  CalendarRepo
+	ur  UserRepo
+	nuc *NotificationUseCase
+	log *log.Helper
+}
+
+func NewChangeNotificationUseCase(ehr EventHistoryRepo, cr CalendarRepo, ur UserRepo, nuc *NotificationUseCase, logger log.Logger) *ChangeNotificationUseCase {
+	return &ChangeNotificationUseCase{
+		ehr: ehr,
+		cr:  cr,
+		ur:  ur,
+		nuc: nuc,
+		log: log.NewHelper(log.With(logger, "caller", "biz.change_notification.usecase")),
+	}
+}
+
+// NotifyChanges sends the undelivered changes of the user in one message and marks them delivered.
+// In the quiet hours of the user the changes wait for the first sync after them.
+func (uc *ChangeNotificationUseCase) NotifyChanges(ctx context.Context, user *User) error {
+	now := time.Now()
+	if user.TGID == "" || user.InQuietHours(now) {
+		return nil
+	}
+	changes, err := uc.ehr.ListUndelivered(ctx, user.ID, now.Add(-CHANGE_NOTIFICATION_MAX_AGE))
+	if err != nil || len(changes) == 0 {
+		return err
+	}
+	calendars, err := uc.cr.List(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	calendarsByID := make(map[uuid.UUID]*Calendar, len(calendars))
+	for _, c := range calendars {
+		calendarsByID[c.ID] = c
+	}
+	ids := make([]uuid.UUID, len(changes))
+	var lines []string
+	for i, change := range changes {
+		ids[i] = change.ID
+		calendar, ok := calendarsByID[change.CalendarID]
+		if user.ChangesMuted || !ok || user.CalendarMuted(calendar.GoogleID) {
+			continue
+		}
+		title := change.notificationTitle(user.Email)
+		if title == "" {
+			continue
+		}
+		lines = append(lines, changeNotificationLine(title, calendar, change, user.Location()))
+	}
+	if len(lines) > 0 {
+		uc.log.Debugf("change notification use case: notify user %s of %d changes", user.ID, len(lines))
+		if err := uc.nuc.Notify(ctx, user, &Notification{Text: changesDigest(lines)}); err != nil {
+			return err
+		}
+	}
+	return uc.ehr.MarkDelivered(ctx, ids)
+}
+
+// changesDigest joins the changes into one message
+// changeNotificationLine describes the change to the user by the titles and times,
+// the IDs of the events and calendars mean nothing to the user
+func changeNotificationLine(title string, calendar *Calendar, change *EventHistory, loc *time.Location) string {
+	calendarName := calendar.Summary
+	if calendarName == "" {
+		calendarName = "your calendar"
+	}
+	event := change.NewEvent
+	if change.ChangeType == DELETED {
+		event = change.PrevEvent
+	}
+	summary := event.Summary
+	if summary == "" {
+		summary = "(no title)"
+	}
+	when := previewTime(event.StartTime, loc)
+	if change.ChangeType == UPDATED {
+		when = fmt.Sprintf("from %s to %s", previewTime(change.PrevEvent.StartTime, loc), when)
+	}
+	return fmt.Sprintf("%s in %s: %q %s", title, calendarName, summary, when)
+}
+
+func changesDigest(lines []string) string {
+	more := 0
+	if len(lines) > CHANGE_NOTIFICATION_MAX_CHANGES {
+		more = len(lines) - CHANGE_NOTIFICATION_MAX_CHANGES
+		lines = lines[:CHANGE_NOTIFICATION_MAX_CHANGES]
+	}
+	text := "Changes in your calendars:\n\n" + strings.Join(lines, "\n\n")
+	if more > 0 {
+		text += fmt.Sprintf("\n\n…and %d more changes.", more)
+	}
+	return text
+}
+
+// SkipCalendarChanges marks the changes of the calendar recorded by the full sync started at since delivered
+// without a message. A full sync records every event of the calendar as a change, the changes are not news
+// to the user. The earlier changes, e.g. held back by the quiet hours, are still delivered.
+func (uc *ChangeNotificationUseCase) SkipCalendarChanges(ctx context.Context, calendar *Calendar, since time.Time) error {
+	changes, err := uc.ehr.ListUndelivered(ctx, calendar.UserID, since)
+	if err != nil {
+		return err
+	}
+	var ids []uuid.UUID
+	for _, change := range changes {
+		if change.CalendarID == calendar.ID {
+			ids = append(ids, change.ID)
+		}
+	}
+	uc.log.Debugf("change notification use case: skip %d changes of calendar %s", len(ids), calendar.ID)
+	return uc.ehr.MarkDelivered(ctx, ids)
+}
+
+// MuteCalendar leaves the calendar with the name out of the change notifications of the user or brings it back
+func (uc *ChangeNotificationUseCase) MuteCalendar(ctx context.Context, user *User, name string, muted bool) (*Calendar, error) {
+	uc.log.Debugf("change notification use case: mute calendar %q for user %s: %t", name, user.ID, muted)
+	calendars, err := uc.cr.List(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	var calendar *Calendar
+	for _, c := range calendars {
+		if strings.EqualFold(c.Summary, name) {
+			calendar = c
+			break
+		}
+	}
+	if calendar == nil {
+		return nil, pb.ErrorCalendarNotFound("no calendar named %q", name)
+	}
+	ids := make([]string, 0, len(user.MutedCalendars)+1)
+	for _, id := range user.MutedCalendars {
+		if id != calendar.GoogleID {
+			ids = append(ids, id)
+		}
+	}
+	if muted {
+		ids = append(ids, calendar.GoogleID)
+	}
+	user.MutedCalendars = ids
+	if err := uc.ur.Update(ctx, user); err != nil {
+		return nil, err
+	}
+	return calendar, nil
+}
+
+// MutedCalendars returns the synced calendars of the user left out of the change notifications
+func (uc *ChangeNotificationUseCase) MutedCalendars(ctx context.Context, user *User) ([]*Calendar, error) {
+	calendars, err := uc.cr.List(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	var muted []*Calendar
+	for _, c := range calendars {
+		if user.CalendarMuted(c.GoogleID) {
+			muted = append(muted, c)
+		}
+	}
+	return muted, nil
+}
@@ -0,0 +1,48 @@
+package biz
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestChangeNotificationLine(t *testing.T) {
+	calendar := &Calendar{ID: uuid.New(), GoogleID: "team@group.calendar.google.com", Summary: "Team"}
+	untitled := &Calendar{ID: uuid.New(), GoogleID: "other@group.calendar.google.com"}
+	event := Event{ID: uuid.New(), GoogleID: "event", Summary: "Review", StartTime: at(10, 0), EndTime: at(11, 0)}
+	moved := event
+	moved.StartTime, moved.EndTime = at(14, 0), at(15, 0)
+	noTitle := event
+	noTitle.Summary = ""
+	tests := []struct {
+		name     string
+		title    string
+		calendar *Calendar
+		change   *EventHistory
+		want     string
+	}{
+		{"invitation", "New invitation", calendar, &EventHistory{EventID: event.ID, ChangeType: CREATED, NewEvent: event},
+			`New invitation in Team: "Review" Tue, 02 Jan 2024 10:00 UTC`},
+		{"moved", "Meeting moved", calendar, &EventHistory{EventID: event.ID, ChangeType: UPDATED, PrevEvent: event, NewEvent: moved},
+			`Meeting moved in Team: "Review" from Tue, 02 Jan 2024 10:00 UTC to Tue, 02 Jan 2024 14:00 UTC`},
+		{"cancelled", "Meeting cancelled", calendar, &EventHistory{EventID: event.ID, ChangeType: DELETED, PrevEvent: event},
+			`Meeting cancelled in Team: "Review" Tue, 02 Jan 2024 10:00 UTC`},
+		{"no summaries", "New invitation", untitled, &EventHistory{EventID: event.ID, ChangeType: CREATED, NewEvent: noTitle},
+			`New invitation in your calendar: "(no title)" Tue, 02 Jan 2024 10:00 UTC`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := changeNotificationLine(tt.title, tt.calendar, tt.change, time.UTC)
+			if got != tt.want {
+				t.Errorf("line = %q, want %q", got, tt.want)
+			}
+			for _, id := range []string{tt.change.EventID.String(), tt.calendar.ID.String(), tt.calendar.GoogleID, event.GoogleID} {
+				if strings.Contains(got, id) {
+					t.Errorf("line %q shows the ID %s", got, id)
+				}
+			}
+		})
+	}
+}
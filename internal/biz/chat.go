@@ -3,7 +3,10 @@ package biz
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/go-kratos/kratos/v2/log"
+	"github.com/google/uuid"
+	pb "github.com/kdimtricp/aical/api/chat/v1"
 	"github.com/kdimtricp/aical/internal/conf"
 	"github.com/kdimtricp/aical/pkg/openai"
 	"strings"
@@ -11,248 +14,223 @@ import (
 )
 
 type ChatUseCase struct {
-	log    *log.Helper
-	client *openai.Client
-	fr     *openai.Registry
-	gr     GoogleRepo
-	cr     CalendarRepo
-	er     EventRepo
+	log           *log.Helper
+	llm           openai.Provider
+	ts            *Toolset
+	runner        *openai.Runner
+	ur            UserRepo
+	cvr           ConversationRepo
+	usage         *UsageUseCase
+	historyTokens int
 }
 
 // NewChatUseCase .
-func NewChatUseCase(cfg *conf.OpenAI, logger log.Logger, gr GoogleRepo, cr CalendarRepo, er EventRepo) *ChatUseCase {
+func NewChatUseCase(cfg *conf.OpenAI, logger log.Logger, llm openai.Provider, ts *Toolset, ur UserRepo, cvr ConversationRepo, usage *UsageUseCase) *ChatUseCase {
 	return &ChatUseCase{
-		log:    log.NewHelper(logger),
-		client: openai.NewClient(cfg.Api.Key, cfg.Api.Model),
-		fr:     openai.NewRegistry(),
-		gr:     gr,
-		cr:     cr,
-		er:     er,
+		log:           log.NewHelper(logger),
+		llm:           llm,
+		ts:            ts,
+		runner:        openai.NewRunner(llm, ts.registry, runnerOptions(cfg)...),
+		ur:            ur,
+		cvr:           cvr,
+		usage:         usage,
+		historyTokens: int(cfg.GetHistory().GetMaxTokens()),
 	}
 }
 
 // systemMessage returns a system message for assistant
-func systemMessage() openai.ChatCompletionMessage {
+func systemMessage(user *User) openai.ChatCompletionMessage {
+	// every instruction ends with its full stop, they are joined with spaces
+	content := strings.Join([]string{
+		"You are an AI assistant that helps the user manage his calendar with smart event scheduling.",
+		"If a user asks to create an event without an exact time, use find_free_slots to get the free times and suggest the best ranked ones, " +
+			"never compute free times from list_events yourself.",
+		"If there are no free slots, notify the user.",
+		"Use create_event to finalize the creation of the event.",
+		"Use current_time to get the current time.",
+		"Use adjust_date to adjust the current date by a number of days.",
+		"For example to get tomorrow's date use current_time to get today's date and use adjust_date(1) to get tomorrow.",
+		"To invite people by name use find_contacts to get their email addresses, ask the user when a name is ambiguous or not found.",
+		"Use rsvp_event to answer invitations.",
+		"Set add_video_call for remote meetings and always give the conference_url of an event as the join link in the answer.",
+		"Use create_recurring_event for repeating events.",
+		"To change or cancel occurrences of a series use update_recurring_event or delete_recurring_event " +
+			"with the scope this, following or all, events with a recurring_google_event_id are occurrences.",
+		"Updates and deletions of events wait for the user confirmation, tell the user what will change when a call returns pending_confirmation.",
+	}, " ")
+	loc := user.Location()
+	content += fmt.Sprintf(" The user's time zone is %s (UTC%s), interpret and give times in this time zone "+
+		"and pass times to the functions in RFC3339 format with this offset.", loc, time.Now().In(loc).Format("-07:00"))
+	if user.ReadOnly {
+		content += " The user has enabled read-only mode, you can not create, update or delete events, only answer questions about them."
+	}
 	return openai.ChatCompletionMessage{
-		Role: "system",
-		Content: "You are an AI assistant that helps the user manage his calendar with smart event scheduling. " +
-			"If a user asks to create an event, first use list_events to analyze the user's existing events for the specified day. " +
-			"If there are no events or there are free slots, suggest the best times for the new event. If the day is fully booked, notify the user. " +
-			"Use create_event to finalize the creation of the event." +
-			"Use current_time to get the current time." +
-			"Use adjust_date to adjust the current date by a number of days. " +
-			"For example to get tomorrow's date use current_time to get today's date and use adjust_date(1) to get tomorrow.",
+		Role:    "system",
+		Content: content,
 	}
 }
 
-func (uc *ChatUseCase) UserChat(ctx context.Context, question string) (string, error) {
+// ChatReply is the answer of the assistant with the actions waiting for the user confirmation
+type ChatReply struct {
+	Answer         string
+	PendingActions []*PendingAction
+}
+
+// UserChat answers the user question, replaying the previous conversation with the user as context.
+// Messages of the current turn are appended to the conversation once the answer is ready.
+func (uc *ChatUseCase) UserChat(ctx context.Context, user *User, question string) (*ChatReply, error) {
+	return uc.chat(ctx, user, question, nil)
+}
+
+// UserChatStream answers the user question like UserChat, passing the answer to the handler as it is generated.
+func (uc *ChatUseCase) UserChatStream(ctx context.Context, user *User, question string, handler openai.StreamHandler) (*ChatReply, error) {
+	return uc.chat(ctx, user, question, handler)
+}
+
+// chat runs the function call loop for the user question, the completions are streamed if handler is not nil.
+// Destructive tool calls are staged as pending actions returned with the answer.
+func (uc *ChatUseCase) chat(ctx context.Context, user *User, question string, handler openai.StreamHandler) (*ChatReply, error) {
+	if err := uc.usage.CheckQuota(ctx, user.ID); err != nil {
+		return nil, err
+	}
+	history, err := uc.cvr.Load(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	ctx = SetChangeInitiator(SetUser(ctx, user), ASSISTANT)
+	ctx, staged := withStagedActions(ctx, user.ID)
 	messageContext := make([]openai.ChatCompletionMessage, 0)
-	messageContext = append(messageContext, systemMessage())
+	messageContext = append(messageContext, systemMessage(user))
+	messageContext = append(messageContext, truncateConversation(history, uc.historyTokens)...)
+	turnStart := len(messageContext)
 	messageContext = append(messageContext, openai.ChatCompletionMessage{
 		Role:    "user",
 		Content: question,
 	})
 
-	uc.fr.Register(currentTimeFunctionDescription().Name, currentTimeFunctionDescription(), currentTimeFunction)
-	uc.fr.Register(adjustDateFunctionDescription().Name, adjustDateFunctionDescription(), adjustDateFunction)
-	uc.fr.Register(createEventFunctionDescription().Name, createEventFunctionDescription(), uc.createEventFunction)
-	uc.fr.Register(updateEventFunctionDescription().Name, updateEventFunctionDescription(), uc.updateEventFunction)
-	uc.fr.Register(deleteEventFunctionDescription().Name, deleteEventFunctionDescription(), uc.deleteEventFunction)
-	uc.fr.Register(listEventsFunctionDescription().Name, listEventsFunctionDescription(), uc.listEventsFunction)
-	uc.fr.Register(listUserCalendarsFunctionDescription().Name, listUserCalendarsFunctionDescription(), uc.listUserCalendarsFunction)
-
+	tools := uc.ts.ForUser(user)
 	request := &openai.ChatCompletionRequest{
-		Messages:  messageContext,
-		Functions: uc.fr.Descriptions(),
+		Messages: messageContext,
+		Tools:    tools.Tools(),
 	}
 	uc.log.Debugf("Chat request: \n%v", request)
-	var answer string
-	for {
-		response, err := uc.client.DoRequest(ctx, request)
-		if err != nil {
-			return err.Error(), err
-		}
-		if response.Choices[0].FinishReason == "stop" {
-			uc.log.Debugf("\nQuestion: %s\nAnswer: %s",
-				question,
-				response.Choices[0].Message.Content,
-			)
-			answer = response.Choices[0].Message.Content
-			break
-		}
-		if response.Choices[0].FinishReason == "function_call" {
-			request.AddFunctionCall(
-				response.Choices[0].Message.FunctionCall.Name,
-				response.Choices[0].Message.FunctionCall.Arguments,
-				uc.fr.Execute(
-					ctx,
-					response.Choices[0].Message.FunctionCall.Name,
-					response.Choices[0].Message.FunctionCall.Arguments,
-				),
-			)
-		}
-	}
-	return answer, nil
+	result, err := uc.runner.WithRegistry(tools).Run(ctx, request, handler)
+	uc.usage.Record(ctx, user.ID, USAGE_KIND_CHAT, result)
+	if err != nil {
+		uc.log.Errorf("chat for user %s failed after %d steps: %v", user.ID, result.Steps, err)
+		return nil, agentError(err)
+	}
+	answer := result.Answer.Content
+	uc.log.Debugf("\nQuestion: %s\nAnswer: %s", question, answer)
+	if err := uc.cvr.Append(ctx, user.ID, request.Messages[turnStart:]); err != nil {
+		uc.log.Errorf("append conversation for user %s: %v", user.ID, err)
+	}
+	return &ChatReply{
+		Answer:         answer,
+		PendingActions: staged.list(),
+	}, nil
 }
 
-func (uc *ChatUseCase) createEventFunction(ctx context.Context, arguments string) string {
-	uc.log.Debugf("createEventFunction: %s", arguments)
-	args := &struct {
-		GoogleCalendarID string    `json:"google_calendar_id"`
-		Title            string    `json:"title"`
-		Location         string    `json:"location,omitempty"`
-		StartTime        time.Time `json:"start_time"`
-		EndTime          time.Time `json:"end_time"`
-	}{}
-
-	err := json.Unmarshal([]byte(arguments), args)
+// takeAction removes the pending action of the user and returns it
+func (uc *ChatUseCase) takeAction(ctx context.Context, user *User, actionID uuid.UUID) (*PendingAction, error) {
+	action, err := uc.ts.par.Get(ctx, actionID)
 	if err != nil {
-		return err.Error()
-	}
-
-	event := &Event{
-		Summary:   args.Title,
-		Location:  args.Location,
-		StartTime: args.StartTime,
-		EndTime:   args.EndTime,
-	}
-	// Create event in ggogle calendar
-	token := GetToken(ctx)
-	if token == nil {
-		return "token not found in context"
+		return nil, err
 	}
-	if args.GoogleCalendarID == "" {
-		args.GoogleCalendarID = "primary"
+	if action == nil || action.UserID != user.ID {
+		return nil, pb.ErrorPendingActionNotFound("The action has expired or has already been handled.")
 	}
-	e, err := uc.gr.CreateCalendarEvent(ctx, token, event, args.GoogleCalendarID)
+	action, err = uc.ts.par.Take(ctx, actionID)
 	if err != nil {
-		return err.Error()
+		return nil, err
 	}
-	return e.String()
+	if action == nil {
+		return nil, pb.ErrorPendingActionNotFound("The action has expired or has already been handled.")
+	}
+	return action, nil
 }
 
-func (uc *ChatUseCase) updateEventFunction(ctx context.Context, arguments string) string {
-	uc.log.Debugf("updateEventFunction: %s", arguments)
-	args := &struct {
-		GoogleCalendarID string    `json:"google_calendar_id"`
-		GoogleID         string    `json:"google_event_id"`
-		Title            string    `json:"title,omitempty"`
-		Location         string    `json:"location,omitempty"`
-		StartTime        time.Time `json:"start_time,omitempty"`
-		EndTime          time.Time `json:"end_time,omitempty"`
-	}{}
-
-	err := json.Unmarshal([]byte(arguments), args)
+// ConfirmAction executes the pending action of the user and returns the description of the change
+func (uc *ChatUseCase) ConfirmAction(ctx context.Context, user *User, actionID uuid.UUID) (string, error) {
+	uc.log.Debugf("confirm action %s for user %s", actionID, user.ID)
+	action, err := uc.takeAction(ctx, user, actionID)
 	if err != nil {
-		return err.Error()
+		return "", err
 	}
-	event := &Event{
-		GoogleID:  args.GoogleID,
-		Summary:   args.Title,
-		Location:  args.Location,
-		StartTime: args.StartTime,
-		EndTime:   args.EndTime,
+	// the settings may have changed since the action was staged
+	if !uc.ts.allowed(user, action.Function) {
+		uc.noteAction(ctx, user, fmt.Sprintf("The change is not applied, the user settings do not allow it anymore: %s", action.Preview))
+		return "", pb.ErrorToolDisabled("The change is not applied, %s is disabled in your settings.", action.Function)
 	}
-
-	token := GetToken(ctx)
-	if token == nil {
-		return "token not found in context"
-	}
-
-	if args.GoogleCalendarID == "" {
-		args.GoogleCalendarID = "primary"
+	result := uc.ts.execute(SetChangeInitiator(SetUser(ctx, user), ASSISTANT), action.Function, action.Arguments)
+	toolResult := &struct {
+		Error         string `json:"error"`
+		ConferenceURL string `json:"conference_url"`
+	}{}
+	if err := json.Unmarshal([]byte(result), toolResult); err == nil && toolResult.Error != "" {
+		uc.log.Errorf("confirmed action %s for user %s failed: %s", actionID, user.ID, toolResult.Error)
+		return "", pb.ErrorPendingActionFailed("The change could not be applied: %s", toolResult.Error)
 	}
-	e, err := uc.gr.UpdateCalendarEvent(ctx, token, event, args.GoogleCalendarID)
-	if err != nil {
-		return err.Error()
+	uc.noteAction(ctx, user, fmt.Sprintf("The user confirmed the change, it is applied: %s", action.Preview))
+	if toolResult.ConferenceURL != "" {
+		return fmt.Sprintf("%s\njoin: %s", action.Preview, toolResult.ConferenceURL), nil
 	}
-	return e.String()
+	return action.Preview, nil
 }
 
-func (uc *ChatUseCase) deleteEventFunction(ctx context.Context, arguments string) string {
-	uc.log.Debugf("deleteEventFunction: %s", arguments)
-	token := GetToken(ctx)
-	if token == nil {
-		return "token not found in context"
-	}
-	args := &struct {
-		GoogleCalendarID string `json:"google_calendar_id"`
-		GoogleEventID    string `json:"google_event_id"`
-	}{}
-	err := json.Unmarshal([]byte(arguments), args)
-	if err != nil {
-		return err.Error()
-	}
-
-	event := &Event{
-		GoogleID: args.GoogleEventID,
-	}
-	if args.GoogleCalendarID == "" {
-		args.GoogleCalendarID = "primary"
-	}
-	err = uc.gr.DeleteCalendarEvent(ctx, token, event, args.GoogleCalendarID)
+// RejectAction drops the pending action of the user and returns the description of the dropped change
+func (uc *ChatUseCase) RejectAction(ctx context.Context, user *User, actionID uuid.UUID) (string, error) {
+	uc.log.Debugf("reject action %s for user %s", actionID, user.ID)
+	action, err := uc.takeAction(ctx, user, actionID)
 	if err != nil {
-		return err.Error()
+		return "", err
 	}
-	return "Event deleted"
+	uc.noteAction(ctx, user, fmt.Sprintf("The user cancelled the change, it is not applied: %s", action.Preview))
+	return action.Preview, nil
 }
 
-func (uc *ChatUseCase) listEventsFunction(ctx context.Context, arguments string) string {
-	uc.log.Debugf("listEventsFunction: %s", arguments)
-	args := &struct {
-		GoogleCalendarID string `json:"google_calendar_id"`
-		StartTime        string `json:"start_time,omitempty"`
-		EndTime          string `json:"end_time,omitempty"`
-	}{}
-
-	err := json.Unmarshal([]byte(arguments), args)
+// noteAction adds the outcome of a pending action to the conversation, so the model knows it on the next turn
+func (uc *ChatUseCase) noteAction(ctx context.Context, user *User, note string) {
+	err := uc.cvr.Append(ctx, user.ID, []openai.ChatCompletionMessage{{
+		Role:    "assistant",
+		Content: note,
+	}})
 	if err != nil {
-		return err.Error()
-	}
-	if args.StartTime == "" {
-		args.StartTime = time.Now().AddDate(0, 0, -int(time.Now().Weekday())+1).Format(time.RFC3339) // this week
+		uc.log.Errorf("append conversation for user %s: %v", user.ID, err)
 	}
+}
 
-	if args.EndTime == "" {
-		args.EndTime = time.Now().AddDate(0, 0, 14-int(time.Now().Weekday())).Format(time.RFC3339) // next week
-	}
-	token := GetToken(ctx)
-	if token == nil {
-		return "error: token not found in context"
-	}
+// Usage returns the token usage of the user
+func (uc *ChatUseCase) Usage(ctx context.Context, user *User) (*UsageReport, error) {
+	return uc.usage.Report(ctx, user.ID)
+}
 
-	if args.GoogleCalendarID == "" {
-		args.GoogleCalendarID = "primary"
-	}
+// ResetConversation forgets the conversation with the user
+func (uc *ChatUseCase) ResetConversation(ctx context.Context, user *User) error {
+	uc.log.Debugf("reset conversation for user %s", user.ID)
+	return uc.cvr.Reset(ctx, user.ID)
+}
 
-	events, err := uc.gr.ListCalendarEvents(ctx, token, args.GoogleCalendarID, &GoogleListEventsOption{
-		TimeMin: args.StartTime,
-		TimeMax: args.EndTime,
-	})
-	if err != nil {
-		return err.Error()
-	}
-	eventsString := make([]string, len(events))
-	for i, event := range events {
-		eventsString[i] = event.String()
-	}
-	return "[" + strings.Join(eventsString, ",") + "]"
+// ListTools returns the tools with their state for the user
+func (uc *ChatUseCase) ListTools(_ context.Context, user *User) []*ToolState {
+	return uc.ts.States(user)
 }
 
-func (uc *ChatUseCase) listUserCalendarsFunction(ctx context.Context, arguments string) string {
-	uc.log.Debugf("listUserCalendarsFunction: %s", arguments)
-	token := GetToken(ctx)
-	calendars, err := uc.gr.ListUserCalendars(ctx, token)
-	if err != nil {
-		return err.Error()
+// UpdateTools sets the read-only mode, the disabled tools and the conflict policy of the user
+func (uc *ChatUseCase) UpdateTools(ctx context.Context, user *User, readOnly bool, disabledTools []string, conflictPolicy string) ([]*ToolState, error) {
+	uc.log.Debugf("update tools for user %s: read only %t, disabled %v, conflicts %s", user.ID, readOnly, disabledTools, conflictPolicy)
+	if err := uc.ts.Validate(disabledTools); err != nil {
+		return nil, err
+	}
+	policy, ok := ParseConflictPolicy(conflictPolicy)
+	if !ok {
+		return nil, errInvalidConflictPolicy(conflictPolicy)
 	}
-	calendarsString := make([]string, len(calendars))
-	for i, calendar := range calendars {
-		c, err := uc.cr.Get(ctx, calendar)
-		if err != nil {
-			return err.Error()
-		}
-		calendarsString[i] = c.String()
+	user.ReadOnly = readOnly
+	user.DisabledTools = disabledTools
+	user.ConflictPolicy = string(policy)
+	if err := uc.ur.Update(ctx, user); err != nil {
+		return nil, err
 	}
-	return "[" + strings.Join(calendarsString, ",") + "]"
+	return uc.ts.States(user), nil
 }
@@ -0,0 +1,17 @@
+package biz
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestSystemMessageSentences(t *testing.T) {
+	// a full stop followed by a letter means two instructions were joined without a space
+	joined := regexp.MustCompile(`[.,][A-Za-z]`)
+	for _, user := range []*User{{}, {ReadOnly: true, Timezone: "Europe/Berlin"}} {
+		content := systemMessage(user).Content
+		if match := joined.FindString(content); match != "" {
+			t.Errorf("system message joins sentences without a space at %q: %s", match, content)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package biz
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	pb "github.com/kdimtricp/aical/api/chat/v1"
+	"strings"
+	"time"
+)
+
+// ConflictPolicy is what happens when a created or updated event overlaps other events
+type ConflictPolicy string
+
+//goland:noinspection GoSnakeCaseUsage
+const (
+	// CONFLICT_POLICY_BLOCK refuses the change
+	CONFLICT_POLICY_BLOCK ConflictPolicy = "block"
+	// CONFLICT_POLICY_WARN applies the change and reports the overlapping events
+	CONFLICT_POLICY_WARN ConflictPolicy = "warn"
+	// CONFLICT_POLICY_ALLOW applies the change without a check
+	CONFLICT_POLICY_ALLOW ConflictPolicy = "allow"
+
+	DEFAULT_CONFLICT_POLICY = CONFLICT_POLICY_WARN
+)
+
+// ParseConflictPolicy returns the policy of the name, the default policy for an empty name
+func ParseConflictPolicy(name string) (ConflictPolicy, bool) {
+	switch policy := ConflictPolicy(strings.ToLower(strings.TrimSpace(name))); policy {
+	case "":
+		return DEFAULT_CONFLICT_POLICY, true
+	case CONFLICT_POLICY_BLOCK, CONFLICT_POLICY_WARN, CONFLICT_POLICY_ALLOW:
+		return policy, true
+	default:
+		return "", false
+	}
+}
+
+func errInvalidConflictPolicy(name string) error {
+	return pb.ErrorInvalidConflictPolicy("unknown conflict policy %q, use %s, %s or %s",
+		name, CONFLICT_POLICY_BLOCK, CONFLICT_POLICY_WARN, CONFLICT_POLICY_ALLOW)
+}
+
+// conflictError is returned to the model when the block policy refuses a change,
+// it is encoded as JSON with the overlapping events
+type conflictError struct {
+	Message   string         `json:"error"`
+	Policy    ConflictPolicy `json:"policy"`
+	Conflicts []*eventResult `json:"conflicts"`
+	Hint      string         `json:"hint"`
+}
+
+func (e *conflictError) Error() string {
+	return e.Message
+}
+
+func (e *conflictError) MarshalJSON() ([]byte, error) {
+	type report conflictError
+	return json.Marshal((*report)(e))
+}
+
+// changedEventResult is the created or updated event with the events it overlaps
+type changedEventResult struct {
+	*eventResult
+	Conflicts []*eventResult `json:"conflicts,omitempty"`
+	Warning   string         `json:"warning,omitempty"`
+}
+
+// conflictPolicy returns the policy of the user in the context
+func conflictPolicy(ctx context.Context) ConflictPolicy {
+	if user := GetUser(ctx); user != nil {
+		if policy, ok := ParseConflictPolicy(user.ConflictPolicy); ok {
+			return policy
+		}
+	}
+	return DEFAULT_CONFLICT_POLICY
+}
+
+func conflictSummary(conflicts []*Event, loc *time.Location) string {
+	titles := make([]string, len(conflicts))
+	for i, e := range conflicts {
+		titles[i] = fmt.Sprintf("%q (%s – %s)", e.Summary, previewTime(e.StartTime, loc), previewTime(e.EndTime, loc))
+	}
+	return fmt.Sprintf("the event overlaps %d event(s): %s", len(conflicts), strings.Join(titles, ", "))
+}
+
+// conflicts returns the timed events of the calendar overlapping the interval, except the event itself.
+// The locally synced events are checked, Google is asked when the calendar is not synced.
+func (t *calendarTools) conflicts(ctx context.Context, calendarID string, start, end time.Time, googleEventID string) ([]*Event, error) {
+	var events []*Event
+	if c := t.localCalendar(ctx, calendarID); c != nil {
+		stored, err := t.er.ListBetween(ctx, c.ID, start, end)
+		if err != nil {
+			return nil, err
+		}
+		events = stored
+	} else {
+		listed, err := t.gr.ListCalendarEvents(ctx, GetToken(ctx), googleCalendarID(calendarID), &GoogleListEventsOption{
+			TimeMin: start.Format(time.RFC3339),
+			TimeMax: end.Format(time.RFC3339),
+		})
+		if err != nil {
+			return nil, err
+		}
+		events = listed
+	}
+	conflicts := make([]*Event, 0)
+	for _, e := range events {
+		// all-day events mark the day rather than a busy time
+		if e.IsAllDay || e.GoogleID == googleEventID {
+			continue
+		}
+		if e.StartTime.Before(end) && e.EndTime.After(start) {
+			conflicts = append(conflicts, e)
+		}
+	}
+	return conflicts, nil
+}
+
+// checkConflicts applies the conflict policy of the user to the interval.
+// It returns a conflictError for the block policy and the overlapping events for the warn policy.
+func (t *calendarTools) checkConflicts(ctx context.Context, calendarID string, start, end time.Time, googleEventID string) ([]*Event, error) {
+	return t.checkConflictsIn(ctx, []string{calendarID}, start, end, googleEventID)
+}
+
+// checkConflictsIn applies the conflict policy of the user to the interval in all the calendars
+func (t *calendarTools) checkConflictsIn(ctx context.Context, calendarIDs []string, start, end time.Time, googleEventID string) ([]*Event, error) {
+	policy := conflictPolicy(ctx)
+	if policy == CONFLICT_POLICY_ALLOW {
+		return nil, nil
+	}
+	conflicts := make([]*Event, 0)
+	for _, calendarID := range calendarIDs {
+		found, err := t.conflicts(ctx, calendarID, start, end, googleEventID)
+		if err != nil {
+			// an unchecked change could overlap events the policy does not allow
+			return nil, fmt.Errorf("checking conflicts in calendar %s: %w", calendarID, err)
+		}
+		conflicts = append(conflicts, found...)
+	}
+	if len(conflicts) == 0 || policy == CONFLICT_POLICY_WARN {
+		return conflicts, nil
+	}
+	results := make([]*eventResult, len(conflicts))
+	for i, e := range conflicts {
+		results[i] = newEventResult(e)
+	}
+	return nil, &conflictError{
+		Message:   fmt.Sprintf("not applied, %s", conflictSummary(conflicts, userLocation(ctx))),
+		Policy:    policy,
+		Conflicts: results,
+		Hint:      "The user does not allow overlapping events. Tell the user about the conflicts and suggest another time, e.g. with find_free_slots.",
+	}
+}
+
+// newChangedEventResult returns the changed event with the conflicts reported by the warn policy
+func newChangedEventResult(ctx context.Context, event *Event, conflicts []*Event) *changedEventResult {
+	result := &changedEventResult{eventResult: newEventResult(event)}
+	if len(conflicts) == 0 {
+		return result
+	}
+	result.Conflicts = make([]*eventResult, len(conflicts))
+	for i, e := range conflicts {
+		result.Conflicts[i] = newEventResult(e)
+	}
+	result.Warning = fmt.Sprintf("The change is applied, but %s. Tell the user about the conflicts.", conflictSummary(conflicts, userLocation(ctx)))
+	return result
+}
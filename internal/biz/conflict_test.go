@@ -0,0 +1,61 @@
+package biz
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/google/uuid"
+)
+
+// failingEventRepo fails every ListBetween call, the other calls panic
+type failingEventRepo struct {
+	EventRepo
+}
+
+func (r *failingEventRepo) ListBetween(context.Context, uuid.UUID, time.Time, time.Time) ([]*Event, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestCheckConflicts(t *testing.T) {
+	user := &User{ID: uuid.New(), Email: "user@example.com"}
+	calendar := &Calendar{ID: uuid.New(), UserID: user.ID, GoogleID: user.Email}
+	stored := newFakeEventRepo(&Event{CalendarID: calendar.ID, GoogleID: "lunch", Summary: "Lunch", StartTime: at(12, 0), EndTime: at(13, 0)})
+	tests := []struct {
+		name      string
+		policy    ConflictPolicy
+		er        EventRepo
+		start     time.Time
+		conflicts int
+		blocked   bool
+		err       bool
+	}{
+		{"free", CONFLICT_POLICY_BLOCK, stored, at(14, 0), 0, false, false},
+		{"warn", CONFLICT_POLICY_WARN, stored, at(12, 30), 1, false, false},
+		{"block", CONFLICT_POLICY_BLOCK, stored, at(12, 30), 0, true, false},
+		{"allow", CONFLICT_POLICY_ALLOW, stored, at(12, 30), 0, false, false},
+		{"lookup error with warn", CONFLICT_POLICY_WARN, &failingEventRepo{}, at(12, 30), 0, false, true},
+		{"lookup error with block", CONFLICT_POLICY_BLOCK, &failingEventRepo{}, at(12, 30), 0, false, true},
+		{"lookup error with allow", CONFLICT_POLICY_ALLOW, &failingEventRepo{}, at(12, 30), 0, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := *user
+			u.ConflictPolicy = string(tt.policy)
+			tools := newCalendarTools(log.DefaultLogger, nil, &fakeCalendarRepo{calendar: calendar}, tt.er)
+			conflicts, err := tools.checkConflicts(SetUser(context.Background(), &u), "primary", tt.start, tt.start.Add(time.Hour), "")
+			var blocked *conflictError
+			if errors.As(err, &blocked) != tt.blocked {
+				t.Fatalf("error = %v, want blocked %t", err, tt.blocked)
+			}
+			if !tt.blocked && (err != nil) != tt.err {
+				t.Fatalf("error = %v, want an error %t", err, tt.err)
+			}
+			if len(conflicts) != tt.conflicts {
+				t.Errorf("conflicts = %d, want %d", len(conflicts), tt.conflicts)
+			}
+		})
+	}
+}
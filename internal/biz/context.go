@@ -8,6 +8,9 @@ import (
 //goland:noinspection ALL,GoUnnecessarilyExportedIdentifiers
 const (
 	TOKEN_KEY = "token"
+	USER_KEY  = "user"
+
+	CHANGE_INITIATOR_KEY = "change_initiator"
 )
 
 // SetToken returns context with token
@@ -19,3 +22,27 @@ func SetToken(ctx context.Context, token *oauth2.Token) context.Context {
 func GetToken(ctx context.Context) *oauth2.Token {
 	return ctx.Value(TOKEN_KEY).(*oauth2.Token)
 }
+
+// SetUser returns context with user
+func SetUser(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, USER_KEY, user)
+}
+
+// GetUser returns user from context, nil if the context has no user
+func GetUser(ctx context.Context) *User {
+	user, _ := ctx.Value(USER_KEY).(*User)
+	return user
+}
+
+// SetChangeInitiator returns context in which the event changes are recorded as made by the initiator
+func SetChangeInitiator(ctx context.Context, initiator ChangeInitiatorEnum) context.Context {
+	return context.WithValue(ctx, CHANGE_INITIATOR_KEY, initiator)
+}
+
+// GetChangeInitiator returns the initiator of the event changes, SYNC if the context has none
+func GetChangeInitiator(ctx context.Context) ChangeInitiatorEnum {
+	if initiator, ok := ctx.Value(CHANGE_INITIATOR_KEY).(ChangeInitiatorEnum); ok {
+		return initiator
+	}
+	return SYNC
+}
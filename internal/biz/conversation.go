@@ -0,0 +1,66 @@
+package biz
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/kdimtricp/aical/pkg/openai"
+)
+
+//goland:noinspection GoSnakeCaseUsage,GoUnnecessarilyExportedIdentifiers
+const (
+	DEFAULT_HISTORY_MAX_TOKENS = 2000
+	// MESSAGE_TOKEN_OVERHEAD is the approximate number of tokens every message costs on top of its content
+	MESSAGE_TOKEN_OVERHEAD = 4
+)
+
+// ConversationRepo stores the chat messages exchanged between a user and the assistant
+type ConversationRepo interface {
+	Load(ctx context.Context, userID uuid.UUID) ([]openai.ChatCompletionMessage, error)
+	Append(ctx context.Context, userID uuid.UUID, messages []openai.ChatCompletionMessage) error
+	Reset(ctx context.Context, userID uuid.UUID) error
+}
+
+// estimateTokens returns a rough token count of the message, assuming ~4 characters per token
+func estimateTokens(message openai.ChatCompletionMessage) int {
+	chars := len(message.Content) + len(message.Name)
+	for _, call := range message.ToolCalls {
+		chars += len(call.ID) + len(call.Function.Name) + len(call.Function.Arguments)
+	}
+	return chars/4 + MESSAGE_TOKEN_OVERHEAD
+}
+
+// truncateConversation keeps the most recent messages that fit into the token budget.
+// The kept history always starts with a user message, so tool results are never replayed
+// without the assistant call that produced them.
+func truncateConversation(messages []openai.ChatCompletionMessage, maxTokens int) []openai.ChatCompletionMessage {
+	if maxTokens <= 0 {
+		maxTokens = DEFAULT_HISTORY_MAX_TOKENS
+	}
+	messages = dropLegacyFunctionMessages(messages)
+	start := len(messages)
+	tokens := 0
+	for i := len(messages) - 1; i >= 0; i-- {
+		tokens += estimateTokens(messages[i])
+		if tokens > maxTokens {
+			break
+		}
+		start = i
+	}
+	for start < len(messages) && messages[start].Role != "user" {
+		start++
+	}
+	return messages[start:]
+}
+
+// dropLegacyFunctionMessages removes messages of the deprecated function call protocol,
+// they can not be mixed with tool calls in one request.
+func dropLegacyFunctionMessages(messages []openai.ChatCompletionMessage) []openai.ChatCompletionMessage {
+	kept := make([]openai.ChatCompletionMessage, 0, len(messages))
+	for _, message := range messages {
+		if message.Role == "function" || (message.Role == "assistant" && message.Content == "" && len(message.ToolCalls) == 0) {
+			continue
+		}
+		kept = append(kept, message)
+	}
+	return kept
+}
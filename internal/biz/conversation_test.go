@@ -0,0 +1,57 @@
+package biz
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kdimtricp/aical/pkg/openai"
+)
+
+func userMessage(content string) openai.ChatCompletionMessage {
+	return openai.ChatCompletionMessage{Role: "user", Content: content}
+}
+
+func assistantMessage(content string) openai.ChatCompletionMessage {
+	return openai.ChatCompletionMessage{Role: "assistant", Content: content}
+}
+
+func TestTruncateConversation(t *testing.T) {
+	// every message of 36 characters costs 9+4 tokens
+	long := strings.Repeat("x", 36)
+	toolCall := openai.ChatCompletionMessage{Role: "assistant", ToolCalls: []openai.ToolCall{{
+		ID: "call", Type: openai.TOOL_TYPE_FUNCTION, Function: openai.FunctionCall{Name: "list", Arguments: "{}"},
+	}}}
+	toolResult := openai.ChatCompletionMessage{Role: "tool", ToolCallID: "call", Content: long}
+	tests := []struct {
+		name      string
+		messages  []openai.ChatCompletionMessage
+		maxTokens int
+		want      []openai.ChatCompletionMessage
+	}{
+		{"empty", nil, 100, []openai.ChatCompletionMessage{}},
+		{"fits", []openai.ChatCompletionMessage{userMessage(long), assistantMessage(long)}, 100,
+			[]openai.ChatCompletionMessage{userMessage(long), assistantMessage(long)}},
+		{"drops the oldest",
+			[]openai.ChatCompletionMessage{userMessage("a" + long), assistantMessage(long), userMessage("b" + long), assistantMessage(long)}, 30,
+			[]openai.ChatCompletionMessage{userMessage("b" + long), assistantMessage(long)}},
+		{"starts with a user message",
+			[]openai.ChatCompletionMessage{userMessage(long), toolCall, toolResult, assistantMessage(long), userMessage("b"), assistantMessage("c")}, 40,
+			[]openai.ChatCompletionMessage{userMessage("b"), assistantMessage("c")}},
+		{"keeps tool calls with the question",
+			[]openai.ChatCompletionMessage{userMessage(long), toolCall, toolResult, assistantMessage(long)}, 100,
+			[]openai.ChatCompletionMessage{userMessage(long), toolCall, toolResult, assistantMessage(long)}},
+		{"nothing fits", []openai.ChatCompletionMessage{userMessage(long)}, 10, []openai.ChatCompletionMessage{}},
+		{"default budget", []openai.ChatCompletionMessage{userMessage(long)}, 0, []openai.ChatCompletionMessage{userMessage(long)}},
+		{"drops legacy function messages",
+			[]openai.ChatCompletionMessage{userMessage("a"), {Role: "assistant"}, {Role: "function", Name: "list", Content: long}, assistantMessage("b")}, 100,
+			[]openai.ChatCompletionMessage{userMessage("a"), assistantMessage("b")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := truncateConversation(tt.messages, tt.maxTokens); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("truncateConversation = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -20,6 +20,26 @@ type Event struct {
 	CreatedAt  time.Time `json:"created_at,omitempty"`
 	UpdatedAt  time.Time `json:"updated_at,omitempty"`
 	IsAllDay   bool      `json:"is_all_day,omitempty"`
+	// TimeZone is the IANA time zone the event times are shown in
+	TimeZone string `json:"time_zone,omitempty"`
+	// Recurrence holds the RRULE, RDATE and EXDATE lines of a recurring series
+	Recurrence []string `json:"recurrence,omitempty" gorm:"serializer:json"`
+	// RecurringEventID is the Google ID of the series of an occurrence
+	RecurringEventID string `json:"recurring_event_id,omitempty"`
+	// OriginalStartTime is the start of an occurrence as the series defines it, before the occurrence was moved
+	OriginalStartTime time.Time `json:"original_start_time,omitempty"`
+	Description       string    `json:"description,omitempty"`
+	// Organizer is the email of the organizer
+	Organizer string      `json:"organizer,omitempty"`
+	Attendees []*Attendee `json:"attendees,omitempty" gorm:"serializer:json"`
+	// ConferenceURL is the video call link of the event
+	ConferenceURL string `json:"conference_url,omitempty"`
+	// SendUpdates is who Google notifies about the change: all, externalOnly or none, it is not stored
+	SendUpdates string `json:"-" gorm:"-"`
+	// AddVideoCall asks Google to create a Meet conference with the change, the link is set to ConferenceURL
+	AddVideoCall bool `json:"-" gorm:"-"`
+	// Cancelled is set on the events deleted in Google, they are listed by the incremental sync
+	Cancelled bool `json:"-" gorm:"-"`
 }
 
 // String .
@@ -49,16 +69,46 @@ func (e *Event) String() string {
 	if e.IsAllDay {
 		parts = append(parts, "IsAllDay: true")
 	}
+	if len(e.Recurrence) > 0 {
+		parts = append(parts, fmt.Sprintf("Recurrence: %s", strings.Join(e.Recurrence, " ")))
+	}
+	if e.RecurringEventID != "" {
+		parts = append(parts, fmt.Sprintf("RecurringEventID: %s", e.RecurringEventID))
+	}
+	if e.Description != "" {
+		parts = append(parts, fmt.Sprintf("Description: %s", e.Description))
+	}
+	if e.Organizer != "" {
+		parts = append(parts, fmt.Sprintf("Organizer: %s", e.Organizer))
+	}
+	if len(e.Attendees) > 0 {
+		attendees := make([]string, len(e.Attendees))
+		for i, a := range e.Attendees {
+			attendees[i] = a.String()
+		}
+		parts = append(parts, fmt.Sprintf("Attendees: %s", strings.Join(attendees, ", ")))
+	}
+	if e.ConferenceURL != "" {
+		parts = append(parts, fmt.Sprintf("ConferenceURL: %s", e.ConferenceURL))
+	}
 	return fmt.Sprintf("%s\n", strings.Join(parts, "\n"))
 }
 
 // EventRepo .
+// WeekStart returns the midnight of the Monday of the week of the time, in the location of the time
+func WeekStart(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d-(int(t.Weekday())+6)%7, 0, 0, 0, 0, t.Location())
+}
+
 type EventRepo interface {
 	Get(ctx context.Context, event *Event) (*Event, error)
 	Create(ctx context.Context, event *Event) (*Event, error)
 	Update(ctx context.Context, event *Event) (*Event, error)
 	Delete(ctx context.Context, event *Event) error
 	List(ctx context.Context, calendarID uuid.UUID) ([]*Event, error)
+	// ListBetween returns the events of the calendar overlapping the interval
+	ListBetween(ctx context.Context, calendarID uuid.UUID, start, end time.Time) ([]*Event, error)
 }
 
 type EventUseCase struct {
@@ -97,6 +147,12 @@ func (uc *EventUseCase) Delete(ctx context.Context, event *Event) error {
 	return uc.db.Delete(ctx, event)
 }
 
+// ListBetween lists the events of the calendar overlapping the interval
+func (uc *EventUseCase) ListBetween(ctx context.Context, calendarID uuid.UUID, start, end time.Time) ([]*Event, error) {
+	uc.log.Debugf("list events for calendar %s between %s and %s", calendarID, start, end)
+	return uc.db.ListBetween(ctx, calendarID, start, end)
+}
+
 // Update updates an event
 func (uc *EventUseCase) Update(ctx context.Context, event *Event) (*Event, error) {
 	uc.log.Debugf("Update event: %v", event)
@@ -135,7 +191,10 @@ func (uc *EventUseCase) Sync(ctx context.Context, calendarID uuid.UUID, events [
 			// Update db events that are present in Google if they are updated after db events
 			if ge.UpdatedAt.After(e.UpdatedAt) {
 				uc.log.Debugf("Update event %s", e)
-				if _, err := uc.db.Update(ctx, e); err != nil {
+				event := *ge
+				event.ID = e.ID
+				event.CalendarID = calendarID
+				if _, err := uc.db.Update(ctx, &event); err != nil {
 					return err
 				}
 			}
@@ -145,18 +204,70 @@ func (uc *EventUseCase) Sync(ctx context.Context, calendarID uuid.UUID, events [
 	for _, e := range events {
 		if _, ok := dbEventsMap[e.GoogleID]; !ok {
 			uc.log.Debugf("Create event %s", e)
-			if _, err := uc.db.Create(ctx, &Event{
-				CalendarID: calendarID,
-				GoogleID:   e.GoogleID,
-				Summary:    e.Summary,
-				Location:   e.Location,
-				StartTime:  e.StartTime,
-				EndTime:    e.EndTime,
-				IsAllDay:   e.IsAllDay,
-			}); err != nil {
+			event := *e
+			event.ID = uuid.Nil
+			event.CalendarID = calendarID
+			if _, err := uc.db.Create(ctx, &event); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ApplyChanges applies the changes listed by the incremental sync to the database events.
+// The incremental sync lists the changes of all events, the window is the range of the full sync.
+//   - if event is cancelled, delete it and the occurrences of its series
+//   - if event exists in db, update it
+//   - if event not in db and in the window, create it, a series is created whatever its start
+func (uc *EventUseCase) ApplyChanges(ctx context.Context, calendarID uuid.UUID, events []*Event, windowStart, windowEnd time.Time) error {
+	uc.log.Debugf("Apply %d changes to calendar %s", len(events), calendarID)
+	if len(events) == 0 {
+		return nil
+	}
+	dbEvents, err := uc.db.List(ctx, calendarID)
+	if err != nil {
+		return err
+	}
+	dbEventsMap := make(map[string]*Event)
+	for _, e := range dbEvents {
+		dbEventsMap[e.GoogleID] = e
+	}
+	for _, ge := range events {
+		if ge.Cancelled {
+			for _, e := range dbEvents {
+				if _, ok := dbEventsMap[e.GoogleID]; ok && (e.GoogleID == ge.GoogleID || e.RecurringEventID == ge.GoogleID) {
+					uc.log.Debugf("Delete event %s", e)
+					if err := uc.db.Delete(ctx, e); err != nil {
+						return err
+					}
+					delete(dbEventsMap, e.GoogleID)
+				}
+			}
+			continue
+		}
+		event := *ge
+		event.CalendarID = calendarID
+		if e, ok := dbEventsMap[ge.GoogleID]; ok {
+			uc.log.Debugf("Update event %s", e)
+			event.ID = e.ID
+			if _, err := uc.db.Update(ctx, &event); err != nil {
 				return err
 			}
+			continue
+		}
+		if len(ge.Recurrence) == 0 && (!ge.StartTime.Before(windowEnd) || !ge.EndTime.After(windowStart)) {
+			uc.log.Debugf("Skip event %s outside the sync window", ge)
+			continue
+		}
+		uc.log.Debugf("Create event %s", ge)
+		event.ID = uuid.Nil
+		created, err := uc.db.Create(ctx, &event)
+		if err != nil {
+			return err
 		}
+		dbEvents = append(dbEvents, created)
+		dbEventsMap[created.GoogleID] = created
 	}
 	return nil
 }
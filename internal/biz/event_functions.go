@@ -2,197 +2,476 @@ package biz
 
 import (
 	"context"
-	"encoding/json"
-	"github.com/kdimtricp/aical/pkg/openai"
+	"errors"
+	"fmt"
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/google/uuid"
+	"strings"
 	"time"
 )
 
-// createEventFunctionDescription is a function that returns description of a function that creates an event
-func createEventFunctionDescription() openai.FunctionDescription {
-	return openai.FunctionDescription{
-		Name:        "create_event",
-		Description: "Creates an event in the calendar",
-		Parameters: map[string]interface{}{
-			"type": "object",
-			"properties": map[string]interface{}{
-				"google_calendar_id": map[string]interface{}{
-					"type":        "string",
-					"description": "The Google ID of the calendar for the event creation.",
-				},
-				"title": map[string]interface{}{
-					"type":        "string",
-					"description": "The summary or title of the event.",
-				},
-				"location": map[string]interface{}{
-					"type":        "string",
-					"description": "The location of the event. Optional parameter.",
-				},
-				"start_time": map[string]interface{}{
-					"type":        "string",
-					"description": "The start time of the event in RFC3339 format.",
-				},
-				"end_time": map[string]interface{}{
-					"type":        "string",
-					"description": "The end time of the event in RFC3339 format.",
-				},
-			},
-			"required": []string{"google_calendar_id", "title", "start_time", "end_time"},
-		},
-	}
-}
-
-// UpdateEventFunctionDescription is a function that returns description of a function that updates an event
-func updateEventFunctionDescription() openai.FunctionDescription {
-	return openai.FunctionDescription{
-		Name:        "update_event",
-		Description: "Updates an event in the calendar",
-		Parameters: map[string]interface{}{
-			"type": "object",
-			"properties": map[string]interface{}{
-				"google_calendar_id": map[string]interface{}{
-					"type":        "string",
-					"description": "The ID of the Google calendar for the event update.",
-				},
-				"google_event_id": map[string]interface{}{
-					"type":        "string",
-					"description": "The Google ID of the event.",
-				},
-				"title": map[string]interface{}{
-					"type":        "string",
-					"description": "The summary or title of the event. Optional parameter.",
-				},
-				"location": map[string]interface{}{
-					"type":        "string",
-					"description": "The location of the event. Optional parameter.",
-				},
-				"start_time": map[string]interface{}{
-					"type":        "string",
-					"description": "The start time of the event in RFC3339 format. Optional parameter.",
-				},
-				"end_time": map[string]interface{}{
-					"type":        "string",
-					"description": "The end time of the event in RFC3339 format. Optional parameter.",
-				},
-			},
-			"required": []string{"google_calendar_id", "google_event_id"},
-		},
-	}
-}
-
-// deleteEventFunctionDescription is a function that returns description of a function that deletes an event
-func deleteEventFunctionDescription() openai.FunctionDescription {
-	return openai.FunctionDescription{
-		Name:        "delete_event",
-		Description: "Deletes an event from the calendar",
-		Parameters: map[string]interface{}{
-			"type": "object",
-			"properties": map[string]interface{}{
-				"google_calendar_id": map[string]interface{}{
-					"type":        "string",
-					"description": "The ID of the Google calendar for the event deletion.",
-				},
-				"google_event_id": map[string]interface{}{
-					"type":        "string",
-					"description": "The Google ID of the event.",
-				},
-			},
-			"required": []string{"google_calendar_id", "google_event_id"},
-		},
-	}
-}
-
-func currentTimeFunction(_ context.Context, _ string) string {
-	return time.Now().Format(time.RFC3339)
-}
-
-// currentTimeFunctionDescription is a function that returns description of a function that returns current time
-func currentTimeFunctionDescription() openai.FunctionDescription {
-	return openai.FunctionDescription{
-		Name:        "current_time",
-		Description: "Returns current time in RFC3339 format",
-		Parameters: map[string]interface{}{
-			"type":       "object",
-			"properties": map[string]interface{}{},
-		},
-	}
-}
-
-// listEventsFunctionDescription is a function that returns description of a function that lists events
-func listEventsFunctionDescription() openai.FunctionDescription {
-	return openai.FunctionDescription{
-		Name:        "list_events",
-		Description: "Lists events in the google calendar",
-		Parameters: map[string]interface{}{
-			"type": "object",
-			"properties": map[string]interface{}{
-				"google_calendar_id": map[string]interface{}{
-					"type":        "string",
-					"description": "The Google provided ID of the calendar where the events should be listed.",
-				},
-				"start_time": map[string]interface{}{
-					"type":        "string",
-					"description": "The start time of the events in RFC3339 format. Optional parameter.",
-				},
-				"end_time": map[string]interface{}{
-					"type":        "string",
-					"description": "The end time of the events in RFC3339 format. Optional parameter.",
-				},
-			},
-			"required": []string{"google_calendar_id", "start_time", "end_time"},
-		},
-	}
-}
-
-// listUserCalendarsFunctionDescription is a function that returns description of a function that lists user calendars
-func listUserCalendarsFunctionDescription() openai.FunctionDescription {
-	return openai.FunctionDescription{
-		Name:        "list_user_calendars",
-		Description: "Lists user calendars",
-		Parameters: map[string]interface{}{
-			"type":       "object",
-			"properties": map[string]interface{}{},
-		},
-	}
-}
-
-func adjustDateFunction(_ context.Context, arguments string) string {
-	args := &struct {
-		Date string `json:"date"`
-		Days int    `json:"days"`
-	}{}
-
-	err := json.Unmarshal([]byte(arguments), args)
+//goland:noinspection GoSnakeCaseUsage
+const (
+	FUNCTION_CURRENT_TIME        = "current_time"
+	FUNCTION_ADJUST_DATE         = "adjust_date"
+	FUNCTION_CREATE_EVENT        = "create_event"
+	FUNCTION_UPDATE_EVENT        = "update_event"
+	FUNCTION_DELETE_EVENT        = "delete_event"
+	FUNCTION_LIST_EVENTS         = "list_events"
+	FUNCTION_LIST_USER_CALENDARS = "list_user_calendars"
+	FUNCTION_FIND_FREE_SLOTS     = "find_free_slots"
+
+	FUNCTION_CREATE_RECURRING_EVENT = "create_recurring_event"
+	FUNCTION_UPDATE_RECURRING_EVENT = "update_recurring_event"
+	FUNCTION_DELETE_RECURRING_EVENT = "delete_recurring_event"
+
+	FUNCTION_RSVP_EVENT    = "rsvp_event"
+	FUNCTION_FIND_CONTACTS = "find_contacts"
+
+	DEFAULT_GOOGLE_CALENDAR_ID = "primary"
+)
+
+var errTokenNotFound = errors.New("token not found in context")
+
+type currentTimeArgs struct{}
+
+type adjustDateArgs struct {
+	Date time.Time `json:"date" description:"The date in RFC3339 format."`
+	Days int       `json:"days" description:"The number of days to add or subtract."`
+}
+
+type createEventArgs struct {
+	GoogleCalendarID string    `json:"google_calendar_id" description:"The Google ID of the calendar for the event creation."`
+	Title            string    `json:"title" description:"The summary or title of the event."`
+	Location         string    `json:"location,omitempty" description:"The location of the event."`
+	StartTime        time.Time `json:"start_time" description:"The start time of the event in RFC3339 format."`
+	EndTime          time.Time `json:"end_time" description:"The end time of the event in RFC3339 format."`
+	Description      string    `json:"description,omitempty" description:"The description or agenda of the event."`
+	Attendees        []string  `json:"attendees,omitempty" description:"The email addresses of the guests to invite, use find_contacts to resolve names."`
+	AddVideoCall     bool      `json:"add_video_call,omitempty" description:"Whether to add a Google Meet video call link to the event."`
+	SendUpdates      string    `json:"send_updates,omitempty" enum:"all,externalOnly,none" description:"Who gets an email about the change, defaults to all guests."`
+}
+
+type updateEventArgs struct {
+	GoogleCalendarID string     `json:"google_calendar_id" description:"The ID of the Google calendar for the event update."`
+	GoogleEventID    string     `json:"google_event_id" description:"The Google ID of the event."`
+	Title            string     `json:"title,omitempty" description:"The summary or title of the event."`
+	Location         string     `json:"location,omitempty" description:"The location of the event."`
+	StartTime        *time.Time `json:"start_time,omitempty" description:"The start time of the event in RFC3339 format, without end_time the event keeps its duration."`
+	EndTime          *time.Time `json:"end_time,omitempty" description:"The end time of the event in RFC3339 format."`
+	Description      string     `json:"description,omitempty" description:"The description or agenda of the event."`
+	AddAttendees     []string   `json:"add_attendees,omitempty" description:"The email addresses of the guests to invite."`
+	RemoveAttendees  []string   `json:"remove_attendees,omitempty" description:"The email addresses of the guests to remove."`
+	AddVideoCall     bool       `json:"add_video_call,omitempty" description:"Whether to add a Google Meet video call link to the event."`
+	SendUpdates      string     `json:"send_updates,omitempty" enum:"all,externalOnly,none" description:"Who gets an email about the change, defaults to all guests."`
+}
+
+type deleteEventArgs struct {
+	GoogleCalendarID string `json:"google_calendar_id" description:"The ID of the Google calendar for the event deletion."`
+	GoogleEventID    string `json:"google_event_id" description:"The Google ID of the event."`
+	SendUpdates      string `json:"send_updates,omitempty" enum:"all,externalOnly,none" description:"Who gets an email about the cancellation, defaults to all guests."`
+}
+
+type listEventsArgs struct {
+	GoogleCalendarID string     `json:"google_calendar_id" description:"The Google provided ID of the calendar where the events should be listed."`
+	StartTime        *time.Time `json:"start_time,omitempty" description:"The start time of the events in RFC3339 format, defaults to the start of this week."`
+	EndTime          *time.Time `json:"end_time,omitempty" description:"The end time of the events in RFC3339 format, defaults to the end of next week."`
+}
+
+type listUserCalendarsArgs struct{}
+
+// eventResult is the event returned to the model
+type eventResult struct {
+	GoogleEventID string    `json:"google_event_id"`
+	Title         string    `json:"title,omitempty"`
+	Location      string    `json:"location,omitempty"`
+	StartTime     time.Time `json:"start_time"`
+	EndTime       time.Time `json:"end_time"`
+	IsAllDay      bool      `json:"is_all_day,omitempty"`
+	// RecurringGoogleEventID is the series of an occurrence
+	RecurringGoogleEventID string      `json:"recurring_google_event_id,omitempty"`
+	OriginalStartTime      *time.Time  `json:"original_start_time,omitempty"`
+	Recurrence             []string    `json:"recurrence,omitempty"`
+	Description            string      `json:"description,omitempty"`
+	Organizer              string      `json:"organizer,omitempty"`
+	Attendees              []*Attendee `json:"attendees,omitempty"`
+	ConferenceURL          string      `json:"conference_url,omitempty"`
+}
+
+func newEventResult(e *Event) *eventResult {
+	result := &eventResult{
+		GoogleEventID:          e.GoogleID,
+		Title:                  e.Summary,
+		Location:               e.Location,
+		StartTime:              e.StartTime,
+		EndTime:                e.EndTime,
+		IsAllDay:               e.IsAllDay,
+		RecurringGoogleEventID: e.RecurringEventID,
+		Recurrence:             e.Recurrence,
+		Description:            e.Description,
+		Organizer:              e.Organizer,
+		Attendees:              e.Attendees,
+		ConferenceURL:          e.ConferenceURL,
+	}
+	if !e.OriginalStartTime.IsZero() {
+		originalStart := e.OriginalStartTime
+		result.OriginalStartTime = &originalStart
+	}
+	return result
+}
+
+// calendarResult is the calendar returned to the model
+type calendarResult struct {
+	GoogleCalendarID string `json:"google_calendar_id"`
+	Summary          string `json:"summary"`
+}
+
+type deleteEventResult struct {
+	GoogleEventID string `json:"google_event_id"`
+	Deleted       bool   `json:"deleted"`
+}
+
+// calendarTools are the functions the model uses to read and change the user calendars,
+// the google token of the user is taken from the context
+type calendarTools struct {
+	log *log.Helper
+	gr  GoogleRepo
+	cr  CalendarRepo
+	er  EventRepo
+}
+
+func newCalendarTools(logger log.Logger, gr GoogleRepo, cr CalendarRepo, er EventRepo) *calendarTools {
+	return &calendarTools{
+		log: log.NewHelper(logger),
+		gr:  gr,
+		cr:  cr,
+		er:  er,
+	}
+}
+
+func googleCalendarID(id string) string {
+	if id == "" {
+		return DEFAULT_GOOGLE_CALENDAR_ID
+	}
+	return id
+}
+
+// currentTime returns the current time in the time zone of the user
+func (t *calendarTools) currentTime(ctx context.Context, _ currentTimeArgs) (string, error) {
+	return time.Now().In(userLocation(ctx)).Format(time.RFC3339), nil
+}
+
+func (t *calendarTools) adjustDate(_ context.Context, args adjustDateArgs) (string, error) {
+	return args.Date.AddDate(0, 0, args.Days).Format(time.RFC3339), nil
+}
+
+func (t *calendarTools) createEvent(ctx context.Context, args createEventArgs) (*changedEventResult, error) {
+	return t.createEventChecked(ctx, args, []string{args.GoogleCalendarID})
+}
+
+// createEventChecked creates the event after the conflict check in the calendars
+func (t *calendarTools) createEventChecked(ctx context.Context, args createEventArgs, conflictCalendarIDs []string) (*changedEventResult, error) {
+	t.log.Debugf("createEvent: %+v", args)
+	token := GetToken(ctx)
+	if token == nil {
+		return nil, errTokenNotFound
+	}
+	conflicts, err := t.checkConflictsIn(ctx, conflictCalendarIDs, args.StartTime, args.EndTime, "")
+	if err != nil {
+		return nil, err
+	}
+	event := &Event{
+		Summary:      args.Title,
+		Location:     args.Location,
+		StartTime:    args.StartTime,
+		EndTime:      args.EndTime,
+		TimeZone:     userLocation(ctx).String(),
+		Description:  args.Description,
+		Attendees:    withAttendees(nil, args.Attendees, nil),
+		SendUpdates:  sendUpdates(args.SendUpdates),
+		AddVideoCall: args.AddVideoCall,
+	}
+	e, err := t.gr.CreateCalendarEvent(ctx, token, event, googleCalendarID(args.GoogleCalendarID))
+	if err != nil {
+		return nil, err
+	}
+	t.recordCreated(ctx, args.GoogleCalendarID, e)
+	return newChangedEventResult(ctx, e, conflicts), nil
+}
+
+// updatedEvent returns the current event and the event with the changes of the arguments applied
+func (t *calendarTools) updatedEvent(ctx context.Context, args updateEventArgs) (*Event, *Event, error) {
+	token := GetToken(ctx)
+	if token == nil {
+		return nil, nil, errTokenNotFound
+	}
+	current, err := t.gr.GetCalendarEvent(ctx, token, &Event{GoogleID: args.GoogleEventID}, googleCalendarID(args.GoogleCalendarID))
 	if err != nil {
-		return err.Error()
+		return nil, nil, err
 	}
+	event := *current
+	if args.Title != "" {
+		event.Summary = args.Title
+	}
+	if args.Location != "" {
+		event.Location = args.Location
+	}
+	if args.StartTime != nil {
+		event.StartTime = *args.StartTime
+		// a moved event keeps its duration unless the end is given too
+		if args.EndTime == nil {
+			event.EndTime = event.StartTime.Add(current.EndTime.Sub(current.StartTime))
+		}
+	}
+	if args.EndTime != nil {
+		event.EndTime = *args.EndTime
+	}
+	if !event.EndTime.After(event.StartTime) {
+		return nil, nil, fmt.Errorf("end_time must be after start_time")
+	}
+	if event.TimeZone == "" {
+		event.TimeZone = userLocation(ctx).String()
+	}
+	if args.Description != "" {
+		event.Description = args.Description
+	}
+	if len(args.AddAttendees) > 0 || len(args.RemoveAttendees) > 0 {
+		event.Attendees = withAttendees(current.Attendees, args.AddAttendees, args.RemoveAttendees)
+	}
+	event.SendUpdates = sendUpdates(args.SendUpdates)
+	// an event has one call, a second one is not added
+	event.AddVideoCall = args.AddVideoCall && current.ConferenceURL == ""
+	return current, &event, nil
+}
+
+// updateEventConflicts checks the new times of the event, the other changes do not need a check
+func (t *calendarTools) updateEventConflicts(ctx context.Context, args updateEventArgs, event *Event) ([]*Event, error) {
+	if args.StartTime == nil && args.EndTime == nil {
+		return nil, nil
+	}
+	return t.checkConflicts(ctx, args.GoogleCalendarID, event.StartTime, event.EndTime, args.GoogleEventID)
+}
+
+// checkUpdateEvent refuses to stage an update the conflict policy blocks
+func (t *calendarTools) checkUpdateEvent(ctx context.Context, args updateEventArgs) error {
+	_, event, err := t.updatedEvent(ctx, args)
+	if err != nil {
+		return err
+	}
+	_, err = t.updateEventConflicts(ctx, args, event)
+	return err
+}
 
-	currentDate, err := time.Parse(time.RFC3339, args.Date)
+func (t *calendarTools) updateEvent(ctx context.Context, args updateEventArgs) (*changedEventResult, error) {
+	t.log.Debugf("updateEvent: %+v", args)
+	_, event, err := t.updatedEvent(ctx, args)
 	if err != nil {
-		return err.Error()
-	}
-
-	newDate := currentDate.AddDate(0, 0, args.Days)
-	return newDate.Format(time.RFC3339)
-}
-
-// adjustDateFunctionDescription is a function that returns description of a function that adjusts date
-func adjustDateFunctionDescription() openai.FunctionDescription {
-	return openai.FunctionDescription{
-		Name:        "adjust_date",
-		Description: "Adjusts date by adding or subtracting days",
-		Parameters: map[string]interface{}{
-			"type": "object",
-			"properties": map[string]interface{}{
-				"date": map[string]interface{}{
-					"type":        "string",
-					"description": "The date in RFC3339 format.",
-				},
-				"days": map[string]interface{}{
-					"type":        "integer",
-					"description": "The number of days to add or subtract.",
-				},
-			},
-			"required": []string{"date", "days"},
-		},
+		return nil, err
+	}
+	conflicts, err := t.updateEventConflicts(ctx, args, event)
+	if err != nil {
+		return nil, err
+	}
+	e, err := t.gr.UpdateCalendarEvent(ctx, GetToken(ctx), event, googleCalendarID(args.GoogleCalendarID))
+	if err != nil {
+		return nil, err
+	}
+	t.recordUpdated(ctx, args.GoogleCalendarID, e)
+	return newChangedEventResult(ctx, e, conflicts), nil
+}
+
+// updateEventPreview describes the changes of the event update
+func (t *calendarTools) updateEventPreview(ctx context.Context, args updateEventArgs) string {
+	current, event, err := t.updatedEvent(ctx, args)
+	if err != nil {
+		t.log.Errorf("updateEventPreview: %v", err)
+		return fmt.Sprintf("Update event %s", args.GoogleEventID)
+	}
+	lines := []string{fmt.Sprintf("Update event %q:", current.Summary)}
+	if event.Summary != current.Summary {
+		lines = append(lines, fmt.Sprintf("title: %s → %s", current.Summary, event.Summary))
+	}
+	if event.Location != current.Location {
+		lines = append(lines, fmt.Sprintf("location: %s → %s", current.Location, event.Location))
+	}
+	loc := userLocation(ctx)
+	if !event.StartTime.Equal(current.StartTime) {
+		lines = append(lines, fmt.Sprintf("start: %s → %s", previewTime(current.StartTime, loc), previewTime(event.StartTime, loc)))
+	}
+	if !event.EndTime.Equal(current.EndTime) {
+		lines = append(lines, fmt.Sprintf("end: %s → %s", previewTime(current.EndTime, loc), previewTime(event.EndTime, loc)))
+	}
+	if event.Description != current.Description {
+		lines = append(lines, fmt.Sprintf("description: %s", event.Description))
+	}
+	if len(args.AddAttendees) > 0 {
+		lines = append(lines, fmt.Sprintf("invite: %s", strings.Join(args.AddAttendees, ", ")))
+	}
+	if len(args.RemoveAttendees) > 0 {
+		lines = append(lines, fmt.Sprintf("remove guests: %s", strings.Join(args.RemoveAttendees, ", ")))
+	}
+	if event.AddVideoCall {
+		lines = append(lines, "add a Google Meet link")
+	}
+	if conflicts, err := t.updateEventConflicts(ctx, args, event); err == nil && len(conflicts) > 0 {
+		lines = append(lines, fmt.Sprintf("warning: %s", conflictSummary(conflicts, loc)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (t *calendarTools) deleteEvent(ctx context.Context, args deleteEventArgs) (*deleteEventResult, error) {
+	t.log.Debugf("deleteEvent: %+v", args)
+	token := GetToken(ctx)
+	if token == nil {
+		return nil, errTokenNotFound
+	}
+	event := &Event{
+		GoogleID:    args.GoogleEventID,
+		SendUpdates: sendUpdates(args.SendUpdates),
+	}
+	if err := t.gr.DeleteCalendarEvent(ctx, token, event, googleCalendarID(args.GoogleCalendarID)); err != nil {
+		return nil, err
+	}
+	t.recordDeleted(ctx, args.GoogleCalendarID, event)
+	return &deleteEventResult{GoogleEventID: args.GoogleEventID, Deleted: true}, nil
+}
+
+// deleteEventPreview describes the deleted event
+func (t *calendarTools) deleteEventPreview(ctx context.Context, args deleteEventArgs) string {
+	token := GetToken(ctx)
+	if token == nil {
+		return fmt.Sprintf("Delete event %s", args.GoogleEventID)
+	}
+	event, err := t.gr.GetCalendarEvent(ctx, token, &Event{GoogleID: args.GoogleEventID}, googleCalendarID(args.GoogleCalendarID))
+	if err != nil {
+		t.log.Errorf("deleteEventPreview: %v", err)
+		return fmt.Sprintf("Delete event %s", args.GoogleEventID)
+	}
+	loc := userLocation(ctx)
+	preview := fmt.Sprintf("Delete event %q: %s – %s", event.Summary, previewTime(event.StartTime, loc), previewTime(event.EndTime, loc))
+	if len(event.Attendees) > 0 {
+		preview += fmt.Sprintf("\nguests: %s", attendeeEmails(event.Attendees))
+	}
+	return preview
+}
+
+// localCalendar returns the stored calendar of the user with the Google ID, nil if it is not synced
+func (t *calendarTools) localCalendar(ctx context.Context, id string) *Calendar {
+	user := GetUser(ctx)
+	if user == nil {
+		return nil
+	}
+	// the primary calendar of the user has the email as the Google ID
+	if id = googleCalendarID(id); id == DEFAULT_GOOGLE_CALENDAR_ID {
+		id = user.Email
+	}
+	c, err := t.cr.Get(ctx, &Calendar{UserID: user.ID, GoogleID: id})
+	if err != nil {
+		t.log.Debugf("calendar %s of user %s is not synced: %v", id, user.ID, err)
+		return nil
+	}
+	return c
+}
+
+// recordCreated stores the created event, so the change is recorded in the event history
+func (t *calendarTools) recordCreated(ctx context.Context, googleCalendarID string, event *Event) {
+	c := t.localCalendar(ctx, googleCalendarID)
+	if c == nil {
+		return
+	}
+	e := *event
+	e.ID = uuid.Nil
+	e.CalendarID = c.ID
+	if _, err := t.er.Create(ctx, &e); err != nil {
+		t.log.Errorf("recording created event %s: %v", event.GoogleID, err)
+	}
+}
+
+// recordUpdated stores the updated event, so the change is recorded in the event history
+func (t *calendarTools) recordUpdated(ctx context.Context, googleCalendarID string, event *Event) {
+	c := t.localCalendar(ctx, googleCalendarID)
+	if c == nil {
+		return
+	}
+	stored, err := t.er.Get(ctx, &Event{CalendarID: c.ID, GoogleID: event.GoogleID})
+	if err != nil {
+		t.recordCreated(ctx, googleCalendarID, event)
+		return
+	}
+	e := *event
+	e.ID = stored.ID
+	e.CalendarID = c.ID
+	if _, err := t.er.Update(ctx, &e); err != nil {
+		t.log.Errorf("recording updated event %s: %v", event.GoogleID, err)
+	}
+}
+
+// recordDeleted deletes the stored event, so the change is recorded in the event history
+func (t *calendarTools) recordDeleted(ctx context.Context, googleCalendarID string, event *Event) {
+	c := t.localCalendar(ctx, googleCalendarID)
+	if c == nil {
+		return
+	}
+	stored, err := t.er.Get(ctx, &Event{CalendarID: c.ID, GoogleID: event.GoogleID})
+	if err != nil {
+		t.log.Debugf("deleted event %s is not stored: %v", event.GoogleID, err)
+		return
+	}
+	if err := t.er.Delete(ctx, stored); err != nil {
+		t.log.Errorf("recording deleted event %s: %v", event.GoogleID, err)
+	}
+}
+
+// previewTime formats the time in the time zone of the user
+func previewTime(t time.Time, loc *time.Location) string {
+	return t.In(loc).Format("Mon, 02 Jan 2006 15:04 MST")
+}
+
+func (t *calendarTools) listEvents(ctx context.Context, args listEventsArgs) ([]*eventResult, error) {
+	t.log.Debugf("listEvents: %+v", args)
+	token := GetToken(ctx)
+	if token == nil {
+		return nil, errTokenNotFound
+	}
+	timeMin := WeekStart(time.Now().In(userLocation(ctx))) // this week
+	timeMax := timeMin.AddDate(0, 0, 14)                   // and the next one
+	if args.StartTime != nil {
+		timeMin = *args.StartTime
+	}
+	if args.EndTime != nil {
+		timeMax = *args.EndTime
+	}
+	events, err := t.gr.ListCalendarEvents(ctx, token, googleCalendarID(args.GoogleCalendarID), &GoogleListEventsOption{
+		TimeMin: timeMin.Format(time.RFC3339),
+		TimeMax: timeMax.Format(time.RFC3339),
+	})
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*eventResult, len(events))
+	for i, event := range events {
+		results[i] = newEventResult(event)
+	}
+	return results, nil
+}
+
+func (t *calendarTools) listUserCalendars(ctx context.Context, _ listUserCalendarsArgs) ([]*calendarResult, error) {
+	t.log.Debugf("listUserCalendars")
+	token := GetToken(ctx)
+	if token == nil {
+		return nil, errTokenNotFound
+	}
+	calendars, err := t.gr.ListUserCalendars(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*calendarResult, len(calendars))
+	for i, calendar := range calendars {
+		c, err := t.cr.Get(ctx, calendar)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = &calendarResult{GoogleCalendarID: c.GoogleID, Summary: c.Summary}
 	}
+	return results, nil
 }
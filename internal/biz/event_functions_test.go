@@ -0,0 +1,62 @@
+package biz
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/log"
+	"golang.org/x/oauth2"
+)
+
+// fakeEventGoogleRepo returns the event for every GetCalendarEvent call, the other calls panic
+type fakeEventGoogleRepo struct {
+	GoogleRepo
+	event *Event
+}
+
+func (r *fakeEventGoogleRepo) GetCalendarEvent(context.Context, *oauth2.Token, *Event, string) (*Event, error) {
+	e := *r.event
+	return &e, nil
+}
+
+func TestUpdatedEventTimes(t *testing.T) {
+	current := &Event{GoogleID: "event", StartTime: at(10, 0), EndTime: at(11, 30), TimeZone: "UTC"}
+	timePtr := func(t time.Time) *time.Time { return &t }
+	tests := []struct {
+		name  string
+		start *time.Time
+		end   *time.Time
+		// wantStart and wantEnd are zero when the update is refused
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{"no times", nil, nil, at(10, 0), at(11, 30)},
+		{"start keeps the duration", timePtr(at(14, 0)), nil, at(14, 0), at(15, 30)},
+		{"start after the old end keeps the duration", timePtr(at(12, 0)), nil, at(12, 0), at(13, 30)},
+		{"start and end", timePtr(at(14, 0)), timePtr(at(14, 30)), at(14, 0), at(14, 30)},
+		{"end only", nil, timePtr(at(12, 0)), at(10, 0), at(12, 0)},
+		{"end before the start", timePtr(at(14, 0)), timePtr(at(13, 0)), time.Time{}, time.Time{}},
+		{"end before the current start", nil, timePtr(at(9, 0)), time.Time{}, time.Time{}},
+		{"empty event", timePtr(at(14, 0)), timePtr(at(14, 0)), time.Time{}, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tools := newCalendarTools(log.DefaultLogger, &fakeEventGoogleRepo{event: current}, nil, nil)
+			ctx := SetToken(context.Background(), &oauth2.Token{AccessToken: "token"})
+			_, event, err := tools.updatedEvent(ctx, updateEventArgs{GoogleEventID: "event", StartTime: tt.start, EndTime: tt.end})
+			if tt.wantStart.IsZero() {
+				if err == nil {
+					t.Fatalf("updatedEvent = %s – %s, want an error", event.StartTime, event.EndTime)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("updatedEvent: %v", err)
+			}
+			if !event.StartTime.Equal(tt.wantStart) || !event.EndTime.Equal(tt.wantEnd) {
+				t.Errorf("updatedEvent = %s – %s, want %s – %s", event.StartTime, event.EndTime, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
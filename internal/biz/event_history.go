@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/go-kratos/kratos/v2/log"
 	"github.com/google/uuid"
+	"strings"
 	"time"
 )
 
@@ -16,14 +17,31 @@ const (
 	DELETED ChangeTypeEnum = "DELETED"
 )
 
+// ChangeInitiatorEnum is who made the change
+type ChangeInitiatorEnum string
+
+const (
+	// SYNC changes come from the calendar sync
+	SYNC ChangeInitiatorEnum = "SYNC"
+	// ASSISTANT changes are made by the assistant tools
+	ASSISTANT ChangeInitiatorEnum = "ASSISTANT"
+	// UNDO changes revert assistant changes
+	UNDO ChangeInitiatorEnum = "UNDO"
+)
+
 type EventHistory struct {
-	ID         uuid.UUID      `json:"history_id,omitempty"`
-	EventID    uuid.UUID      `json:"event_id,omitempty"`
-	CalendarID uuid.UUID      `json:"calendar_id,omitempty"`
-	ChangeType ChangeTypeEnum `json:"change_type_enum,omitempty"`
-	ChangeTime time.Time      `json:"change_time,omitempty"`
-	PrevEvent  Event          `json:"prev_event"`
-	NewEvent   Event          `json:"new_event"`
+	ID         uuid.UUID           `json:"history_id,omitempty"`
+	EventID    uuid.UUID           `json:"event_id,omitempty"`
+	CalendarID uuid.UUID           `json:"calendar_id,omitempty"`
+	ChangeType ChangeTypeEnum      `json:"change_type_enum,omitempty"`
+	ChangeTime time.Time           `json:"change_time,omitempty"`
+	PrevEvent  Event               `json:"prev_event"`
+	NewEvent   Event               `json:"new_event"`
+	Initiator  ChangeInitiatorEnum `json:"initiator,omitempty"`
+	// Reverted is set when the change has been undone
+	Reverted bool `json:"reverted,omitempty"`
+	// Delivered is set when the change has been handled by the change notifications
+	Delivered bool `json:"delivered,omitempty"`
 }
 
 // changeDescription returns a string representation of the change
@@ -47,9 +65,43 @@ func (e *EventHistory) changeDescription() string {
 	}
 }
 
+// notificationTitle names the change made by others to an event of the user for the change notifications,
+// it is empty for the changes the user is not notified about
+func (e *EventHistory) notificationTitle(userEmail string) string {
+	event := e.NewEvent
+	if e.ChangeType == DELETED {
+		event = e.PrevEvent
+	}
+	// the user organizes the event, so the user made the change
+	if event.Organizer == "" || strings.EqualFold(event.Organizer, userEmail) {
+		return ""
+	}
+	switch e.ChangeType {
+	case CREATED:
+		return "New invitation"
+	case UPDATED:
+		if !e.NewEvent.StartTime.Equal(e.PrevEvent.StartTime) || !e.NewEvent.EndTime.Equal(e.PrevEvent.EndTime) {
+			return "Meeting moved"
+		}
+	case DELETED:
+		return "Meeting cancelled"
+	}
+	return ""
+}
+
 type EventHistoryRepo interface {
 	ListCalendarEventHistory(ctx context.Context, calendarID uuid.UUID) ([]*EventHistory, error)
 	DeleteCalendarEventHistory(ctx context.Context, calendarID uuid.UUID) error
+	// ListUndoable returns the most recent assistant changes of the user that are not reverted, newest first
+	ListUndoable(ctx context.Context, userID uuid.UUID, limit int) ([]*EventHistory, error)
+	MarkReverted(ctx context.Context, id uuid.UUID) error
+	// ListUserChanges returns the changes of the user calendars made by the initiator after the time, oldest first
+	ListUserChanges(ctx context.Context, userID uuid.UUID, initiator ChangeInitiatorEnum, since time.Time) ([]*EventHistory, error)
+	// ListUndelivered returns the synced changes of the user calendars made after the time that are not delivered, oldest first
+	ListUndelivered(ctx context.Context, userID uuid.UUID, since time.Time) ([]*EventHistory, error)
+	MarkDelivered(ctx context.Context, ids []uuid.UUID) error
+	// DeleteBefore deletes the changes made before the time and returns how many were deleted
+	DeleteBefore(ctx context.Context, before time.Time) (int64, error)
 }
 
 type EventHistoryUseCase struct {
@@ -73,3 +125,10 @@ func (uc *EventHistoryUseCase) DeleteCalendarEventHistory(ctx context.Context, c
 	uc.log.Debugf("delete events for calendar %s", calendarID)
 	return uc.db.DeleteCalendarEventHistory(ctx, calendarID)
 }
+
+// Prune deletes the changes older than the retention
+func (uc *EventHistoryUseCase) Prune(ctx context.Context, retention time.Duration) (int64, error) {
+	before := time.Now().Add(-retention)
+	uc.log.Debugf("delete event history before %s", before)
+	return uc.db.DeleteBefore(ctx, before)
+}
@@ -0,0 +1,209 @@
+package biz
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/google/uuid"
+)
+
+// fakeEventRepo keeps the events of the calendars in memory and records the changes like the event history
+type fakeEventRepo struct {
+	events  map[uuid.UUID]*Event
+	changes []*EventHistory
+}
+
+func newFakeEventRepo(events ...*Event) *fakeEventRepo {
+	r := &fakeEventRepo{events: make(map[uuid.UUID]*Event)}
+	for _, e := range events {
+		_, _ = r.Create(context.Background(), e)
+	}
+	r.changes = nil
+	return r
+}
+
+// record records the change with the initiator of the context
+func (r *fakeEventRepo) record(ctx context.Context, changeType ChangeTypeEnum, eventID uuid.UUID, prev, next *Event) {
+	change := &EventHistory{ID: uuid.New(), EventID: eventID, ChangeType: changeType, ChangeTime: time.Now(), Initiator: GetChangeInitiator(ctx)}
+	if prev != nil {
+		change.CalendarID = prev.CalendarID
+		change.PrevEvent = *prev
+	}
+	if next != nil {
+		change.CalendarID = next.CalendarID
+		change.NewEvent = *next
+	}
+	r.changes = append(r.changes, change)
+}
+
+func (r *fakeEventRepo) Get(_ context.Context, event *Event) (*Event, error) {
+	if e, ok := r.events[event.ID]; ok {
+		return e, nil
+	}
+	for _, e := range r.events {
+		if event.GoogleID != "" && e.CalendarID == event.CalendarID && e.GoogleID == event.GoogleID {
+			return e, nil
+		}
+	}
+	return nil, errors.New("record not found")
+}
+
+func (r *fakeEventRepo) Create(ctx context.Context, event *Event) (*Event, error) {
+	e := *event
+	e.ID = uuid.New()
+	r.events[e.ID] = &e
+	r.record(ctx, CREATED, e.ID, nil, &e)
+	return &e, nil
+}
+
+func (r *fakeEventRepo) Update(ctx context.Context, event *Event) (*Event, error) {
+	e := *event
+	r.record(ctx, UPDATED, e.ID, r.events[e.ID], &e)
+	r.events[e.ID] = &e
+	return &e, nil
+}
+
+func (r *fakeEventRepo) Delete(ctx context.Context, event *Event) error {
+	if e, ok := r.events[event.ID]; ok {
+		r.record(ctx, DELETED, e.ID, e, nil)
+	}
+	delete(r.events, event.ID)
+	return nil
+}
+
+func (r *fakeEventRepo) List(_ context.Context, calendarID uuid.UUID) ([]*Event, error) {
+	var events []*Event
+	for _, e := range r.events {
+		if e.CalendarID == calendarID {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func (r *fakeEventRepo) ListBetween(ctx context.Context, calendarID uuid.UUID, start, end time.Time) ([]*Event, error) {
+	events, _ := r.List(ctx, calendarID)
+	var between []*Event
+	for _, e := range events {
+		if e.StartTime.Before(end) && e.EndTime.After(start) {
+			between = append(between, e)
+		}
+	}
+	return between, nil
+}
+
+// summaries returns the sorted Google IDs and summaries of the calendar events
+func (r *fakeEventRepo) summaries(calendarID uuid.UUID) []string {
+	events, _ := r.List(context.Background(), calendarID)
+	s := make([]string, 0, len(events))
+	for _, e := range events {
+		s = append(s, e.GoogleID+":"+e.Summary)
+	}
+	sort.Strings(s)
+	return s
+}
+
+func TestEventUseCaseApplyChanges(t *testing.T) {
+	calendarID := uuid.New()
+	otherCalendarID := uuid.New()
+	stored := func() []*Event {
+		return []*Event{
+			{CalendarID: calendarID, GoogleID: "single", Summary: "Lunch"},
+			{CalendarID: calendarID, GoogleID: "series", Summary: "Standup", Recurrence: []string{"RRULE:FREQ=DAILY"}},
+			{CalendarID: calendarID, GoogleID: "series_1", Summary: "Late standup", RecurringEventID: "series"},
+			{CalendarID: otherCalendarID, GoogleID: "single", Summary: "Other lunch"},
+		}
+	}
+	windowStart, windowEnd := at(0, 0), at(0, 0).AddDate(0, 0, 14)
+	inWindow := func(e *Event) *Event {
+		e.StartTime, e.EndTime = at(12, 0), at(13, 0)
+		return e
+	}
+	later := at(12, 0).AddDate(0, 0, 30)
+	tests := []struct {
+		name    string
+		changes []*Event
+		want    []string
+	}{
+		{"no changes", nil, []string{"series:Standup", "series_1:Late standup", "single:Lunch"}},
+		{"update", []*Event{{GoogleID: "single", Summary: "Brunch"}},
+			[]string{"series:Standup", "series_1:Late standup", "single:Brunch"}},
+		{"create", []*Event{inWindow(&Event{GoogleID: "new", Summary: "Review"})},
+			[]string{"new:Review", "series:Standup", "series_1:Late standup", "single:Lunch"}},
+		{"delete", []*Event{{GoogleID: "single", Cancelled: true}},
+			[]string{"series:Standup", "series_1:Late standup"}},
+		{"delete the series with occurrences", []*Event{{GoogleID: "series", Cancelled: true}},
+			[]string{"single:Lunch"}},
+		{"delete an occurrence", []*Event{{GoogleID: "series_1", Cancelled: true}},
+			[]string{"series:Standup", "single:Lunch"}},
+		{"delete unknown", []*Event{{GoogleID: "unknown", Cancelled: true}},
+			[]string{"series:Standup", "series_1:Late standup", "single:Lunch"}},
+		{"create then update", []*Event{inWindow(&Event{GoogleID: "new", Summary: "Review"}), {GoogleID: "new", Summary: "Code review"}},
+			[]string{"new:Code review", "series:Standup", "series_1:Late standup", "single:Lunch"}},
+		{"create then delete", []*Event{inWindow(&Event{GoogleID: "new", Summary: "Review"}), {GoogleID: "new", Cancelled: true}},
+			[]string{"series:Standup", "series_1:Late standup", "single:Lunch"}},
+		{"create outside the window", []*Event{{GoogleID: "new", Summary: "Review", StartTime: later, EndTime: later.Add(time.Hour)}},
+			[]string{"series:Standup", "series_1:Late standup", "single:Lunch"}},
+		{"create a series started before the window", []*Event{{GoogleID: "weekly", Summary: "Weekly", Recurrence: []string{"RRULE:FREQ=WEEKLY"},
+			StartTime: at(9, 0).AddDate(0, 0, -30), EndTime: at(10, 0).AddDate(0, 0, -30)}},
+			[]string{"series:Standup", "series_1:Late standup", "single:Lunch", "weekly:Weekly"}},
+		{"update moved outside the window", []*Event{{GoogleID: "single", Summary: "Late lunch", StartTime: later, EndTime: later.Add(time.Hour)}},
+			[]string{"series:Standup", "series_1:Late standup", "single:Late lunch"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newFakeEventRepo(stored()...)
+			uc := NewEventUseCase(repo, log.DefaultLogger)
+			if err := uc.ApplyChanges(context.Background(), calendarID, tt.changes, windowStart, windowEnd); err != nil {
+				t.Fatalf("ApplyChanges: %v", err)
+			}
+			if got := repo.summaries(calendarID); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("events = %v, want %v", got, tt.want)
+			}
+			if got := repo.summaries(otherCalendarID); !reflect.DeepEqual(got, []string{"single:Other lunch"}) {
+				t.Errorf("other calendar events = %v, want it untouched", got)
+			}
+		})
+	}
+}
+
+func TestEventUseCaseApplyChangesKeepsID(t *testing.T) {
+	calendarID := uuid.New()
+	repo := newFakeEventRepo(&Event{CalendarID: calendarID, GoogleID: "single", Summary: "Lunch"})
+	before, _ := repo.List(context.Background(), calendarID)
+	uc := NewEventUseCase(repo, log.DefaultLogger)
+	if err := uc.ApplyChanges(context.Background(), calendarID, []*Event{{ID: uuid.New(), GoogleID: "single", Summary: "Brunch"}}, at(0, 0), at(0, 0).AddDate(0, 0, 14)); err != nil {
+		t.Fatalf("ApplyChanges: %v", err)
+	}
+	after, _ := repo.List(context.Background(), calendarID)
+	if len(after) != 1 || after[0].ID != before[0].ID || after[0].CalendarID != calendarID {
+		t.Errorf("updated event = %+v, want ID %s in calendar %s", after, before[0].ID, calendarID)
+	}
+}
+
+func TestWeekStart(t *testing.T) {
+	cet := time.FixedZone("CET", 60*60)
+	tests := []struct {
+		name string
+		t    time.Time
+		want time.Time
+	}{
+		{"monday", time.Date(2024, 1, 8, 15, 0, 0, 0, time.UTC), time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC)},
+		{"wednesday", time.Date(2024, 1, 10, 15, 0, 0, 0, time.UTC), time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC)},
+		{"sunday", time.Date(2024, 1, 14, 23, 0, 0, 0, time.UTC), time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC)},
+		{"across the month", time.Date(2024, 3, 2, 9, 0, 0, 0, time.UTC), time.Date(2024, 2, 26, 0, 0, 0, 0, time.UTC)},
+		{"in the location", time.Date(2024, 1, 8, 0, 30, 0, 0, cet), time.Date(2024, 1, 8, 0, 0, 0, 0, cet)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := WeekStart(tt.t); !got.Equal(tt.want) || got.Location() != tt.want.Location() {
+				t.Errorf("WeekStart(%s) = %s, want %s", tt.t, got, tt.want)
+			}
+		})
+	}
+}
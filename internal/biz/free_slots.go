@@ -0,0 +1,267 @@
+package biz
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+//goland:noinspection GoSnakeCaseUsage
+const (
+	DEFAULT_WORKDAY_START = "09:00"
+	DEFAULT_WORKDAY_END   = "18:00"
+	DEFAULT_FREE_SLOTS    = 5
+	MAX_FREE_SLOTS        = 20
+	MAX_FREE_SLOTS_RANGE  = 31 * 24 * time.Hour
+	// FREE_SLOT_STEP is the granularity of the slot start times
+	FREE_SLOT_STEP = 30 * time.Minute
+)
+
+type findFreeSlotsArgs struct {
+	GoogleCalendarIDs []string  `json:"google_calendar_ids,omitempty" description:"The Google IDs of the calendars that must be free, defaults to the primary calendar."`
+	DurationMinutes   int       `json:"duration_minutes" description:"The duration of the event in minutes."`
+	StartTime         time.Time `json:"start_time" description:"The start of the search range in RFC3339 format."`
+	EndTime           time.Time `json:"end_time" description:"The end of the search range in RFC3339 format."`
+	WorkdayStart      string    `json:"workday_start,omitempty" description:"The start of the working hours as HH:MM in the user time zone, defaults to 09:00."`
+	WorkdayEnd        string    `json:"workday_end,omitempty" description:"The end of the working hours as HH:MM in the user time zone, defaults to 18:00."`
+	BufferMinutes     int       `json:"buffer_minutes,omitempty" description:"The minutes to keep free before and after existing events."`
+	IncludeWeekends   bool      `json:"include_weekends,omitempty" description:"Whether slots on Saturday and Sunday are allowed."`
+	MaxResults        int       `json:"max_results,omitempty" description:"The maximum number of slots to return, defaults to 5."`
+}
+
+// slotResult is a free slot returned to the model, the slots are ranked best first
+type slotResult struct {
+	Rank      int       `json:"rank"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+}
+
+// freeSlotsResult is the ranked free slots with the calendars that could not be checked
+type freeSlotsResult struct {
+	Slots       []*slotResult `json:"slots"`
+	Unavailable []string      `json:"unavailable_calendars,omitempty"`
+	Warning     string        `json:"warning,omitempty"`
+}
+
+// slotQuery is the validated search of free slots
+type slotQuery struct {
+	start        time.Time
+	end          time.Time
+	duration     time.Duration
+	buffer       time.Duration
+	workdayStart time.Duration
+	workdayEnd   time.Duration
+	weekends     bool
+	max          int
+	loc          *time.Location
+}
+
+// parseClock returns the time of day of HH:MM as the duration since midnight
+func parseClock(value string, fallback string) (time.Duration, error) {
+	if value == "" {
+		value = fallback
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, use HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func newSlotQuery(args findFreeSlotsArgs, loc *time.Location) (*slotQuery, error) {
+	if args.DurationMinutes <= 0 {
+		return nil, fmt.Errorf("duration_minutes must be positive")
+	}
+	if !args.EndTime.After(args.StartTime) {
+		return nil, fmt.Errorf("end_time must be after start_time")
+	}
+	if args.EndTime.Sub(args.StartTime) > MAX_FREE_SLOTS_RANGE {
+		return nil, fmt.Errorf("the search range can not be longer than %d days", MAX_FREE_SLOTS_RANGE/(24*time.Hour))
+	}
+	if args.BufferMinutes < 0 {
+		return nil, fmt.Errorf("buffer_minutes can not be negative")
+	}
+	workdayStart, err := parseClock(args.WorkdayStart, DEFAULT_WORKDAY_START)
+	if err != nil {
+		return nil, err
+	}
+	workdayEnd, err := parseClock(args.WorkdayEnd, DEFAULT_WORKDAY_END)
+	if err != nil {
+		return nil, err
+	}
+	if workdayEnd <= workdayStart {
+		return nil, fmt.Errorf("workday_end must be after workday_start")
+	}
+	q := &slotQuery{
+		start:        args.StartTime.In(loc),
+		end:          args.EndTime.In(loc),
+		duration:     time.Duration(args.DurationMinutes) * time.Minute,
+		buffer:       time.Duration(args.BufferMinutes) * time.Minute,
+		workdayStart: workdayStart,
+		workdayEnd:   workdayEnd,
+		weekends:     args.IncludeWeekends,
+		max:          args.MaxResults,
+		loc:          loc,
+	}
+	if q.max <= 0 {
+		q.max = DEFAULT_FREE_SLOTS
+	}
+	if q.max > MAX_FREE_SLOTS {
+		q.max = MAX_FREE_SLOTS
+	}
+	return q, nil
+}
+
+// mergeBusy widens the busy periods by the buffer and merges the overlapping ones
+func mergeBusy(busy []*BusyPeriod, buffer time.Duration) []*BusyPeriod {
+	periods := make([]*BusyPeriod, len(busy))
+	for i, b := range busy {
+		periods[i] = &BusyPeriod{Start: b.Start.Add(-buffer), End: b.End.Add(buffer)}
+	}
+	sort.Slice(periods, func(i, j int) bool {
+		return periods[i].Start.Before(periods[j].Start)
+	})
+	merged := make([]*BusyPeriod, 0, len(periods))
+	for _, p := range periods {
+		if last := len(merged) - 1; last >= 0 && !p.Start.After(merged[last].End) {
+			if p.End.After(merged[last].End) {
+				merged[last].End = p.End
+			}
+			continue
+		}
+		merged = append(merged, p)
+	}
+	return merged
+}
+
+// freeWindows returns the parts of the window not covered by the merged busy periods
+func freeWindows(start, end time.Time, busy []*BusyPeriod) []*BusyPeriod {
+	var windows []*BusyPeriod
+	for _, b := range busy {
+		if !b.End.After(start) {
+			continue
+		}
+		if !b.Start.Before(end) {
+			break
+		}
+		if b.Start.After(start) {
+			windows = append(windows, &BusyPeriod{Start: start, End: b.Start})
+		}
+		start = b.End
+	}
+	if end.After(start) {
+		windows = append(windows, &BusyPeriod{Start: start, End: end})
+	}
+	return windows
+}
+
+// clock returns the time of day on the day of the midnight in the time zone of the query
+func (q *slotQuery) clock(midnight time.Time, offset time.Duration) time.Time {
+	y, m, d := midnight.Date()
+	return time.Date(y, m, d, int(offset/time.Hour), int(offset%time.Hour/time.Minute), 0, 0, q.loc)
+}
+
+// alignSlot rounds the time up to the slot step counted from the midnight of its day
+func alignSlot(t time.Time, midnight time.Time) time.Time {
+	offset := t.Sub(midnight)
+	return midnight.Add((offset + FREE_SLOT_STEP - 1) / FREE_SLOT_STEP * FREE_SLOT_STEP)
+}
+
+// slots returns the ranked free slots. Within a day the slots starting right at the start of a free window
+// are preferred, as they keep the rest of the window in one piece, and the days are taken in turns,
+// so the candidates spread over the range instead of filling the first free morning.
+func (q *slotQuery) slots(busy []*BusyPeriod) []*slotResult {
+	merged := mergeBusy(busy, q.buffer)
+	var days [][]*slotResult
+	y, m, d := q.start.Date()
+	for midnight := time.Date(y, m, d, 0, 0, 0, 0, q.loc); midnight.Before(q.end); midnight = midnight.AddDate(0, 0, 1) {
+		if weekday := midnight.Weekday(); !q.weekends && (weekday == time.Saturday || weekday == time.Sunday) {
+			continue
+		}
+		// the working hours are wall clock times, a DST change at night does not move them
+		start, end := q.clock(midnight, q.workdayStart), q.clock(midnight, q.workdayEnd)
+		if start.Before(q.start) {
+			start = q.start
+		}
+		if end.After(q.end) {
+			end = q.end
+		}
+		var first, rest []*slotResult
+		for _, window := range freeWindows(start, end, merged) {
+			slot := alignSlot(window.Start, midnight)
+			for i := 0; !slot.Add(q.duration).After(window.End); i++ {
+				result := &slotResult{StartTime: slot, EndTime: slot.Add(q.duration)}
+				if i == 0 {
+					first = append(first, result)
+				} else {
+					rest = append(rest, result)
+				}
+				slot = slot.Add(FREE_SLOT_STEP)
+			}
+		}
+		if day := append(first, rest...); len(day) > 0 {
+			days = append(days, day)
+		}
+	}
+	results := make([]*slotResult, 0, q.max)
+	for round := 0; len(results) < q.max; round++ {
+		added := false
+		for _, day := range days {
+			if round < len(day) && len(results) < q.max {
+				day[round].Rank = len(results) + 1
+				results = append(results, day[round])
+				added = true
+			}
+		}
+		if !added {
+			break
+		}
+	}
+	return results
+}
+
+// findFreeSlots returns the ranked slots in which all the calendars are free,
+// the calendars Google could not check are left out and reported
+func (t *calendarTools) findFreeSlots(ctx context.Context, args findFreeSlotsArgs) (*freeSlotsResult, error) {
+	t.log.Debugf("findFreeSlots: %+v", args)
+	token := GetToken(ctx)
+	if token == nil {
+		return nil, errTokenNotFound
+	}
+	query, err := newSlotQuery(args, userLocation(ctx))
+	if err != nil {
+		return nil, err
+	}
+	calendarIDs := make([]string, 0, len(args.GoogleCalendarIDs))
+	for _, id := range args.GoogleCalendarIDs {
+		calendarIDs = append(calendarIDs, googleCalendarID(id))
+	}
+	if len(calendarIDs) == 0 {
+		calendarIDs = append(calendarIDs, DEFAULT_GOOGLE_CALENDAR_ID)
+	}
+	calendars, err := t.gr.FreeBusy(ctx, token, calendarIDs, query.start, query.end)
+	if err != nil {
+		return nil, err
+	}
+	var busy []*BusyPeriod
+	result := &freeSlotsResult{}
+	for _, id := range calendarIDs {
+		periods, ok := calendars[id]
+		if !ok {
+			result.Unavailable = append(result.Unavailable, id)
+			continue
+		}
+		busy = append(busy, periods...)
+	}
+	if len(result.Unavailable) == len(calendarIDs) {
+		return nil, fmt.Errorf("the free/busy information of the calendars is not available")
+	}
+	result.Slots = query.slots(busy)
+	if len(result.Unavailable) > 0 {
+		result.Warning = fmt.Sprintf("The calendars %s could not be checked, the slots may overlap their events. Tell the user.",
+			strings.Join(result.Unavailable, ", "))
+	}
+	return result, nil
+}
@@ -0,0 +1,195 @@
+package biz
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/log"
+	"golang.org/x/oauth2"
+)
+
+// at returns the time of the hour and minute on the test day
+func at(hour, minute int) time.Time {
+	return time.Date(2024, 1, 2, hour, minute, 0, 0, time.UTC)
+}
+
+func periods(pairs ...time.Time) []*BusyPeriod {
+	ps := make([]*BusyPeriod, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		ps = append(ps, &BusyPeriod{Start: pairs[i], End: pairs[i+1]})
+	}
+	return ps
+}
+
+func equalPeriods(got, want []*BusyPeriod) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if !got[i].Start.Equal(want[i].Start) || !got[i].End.Equal(want[i].End) {
+			return false
+		}
+	}
+	return true
+}
+
+func formatPeriods(ps []*BusyPeriod) []string {
+	s := make([]string, len(ps))
+	for i, p := range ps {
+		s[i] = p.Start.Format("15:04") + "-" + p.End.Format("15:04")
+	}
+	return s
+}
+
+func TestMergeBusy(t *testing.T) {
+	tests := []struct {
+		name   string
+		busy   []*BusyPeriod
+		buffer time.Duration
+		want   []*BusyPeriod
+	}{
+		{"empty", nil, 0, periods()},
+		{"disjoint unsorted", periods(at(13, 0), at(14, 0), at(9, 0), at(10, 0)), 0,
+			periods(at(9, 0), at(10, 0), at(13, 0), at(14, 0))},
+		{"overlapping", periods(at(9, 0), at(10, 30), at(10, 0), at(11, 0)), 0,
+			periods(at(9, 0), at(11, 0))},
+		{"contained", periods(at(9, 0), at(12, 0), at(10, 0), at(11, 0)), 0,
+			periods(at(9, 0), at(12, 0))},
+		{"adjacent", periods(at(9, 0), at(10, 0), at(10, 0), at(11, 0)), 0,
+			periods(at(9, 0), at(11, 0))},
+		{"merged by the buffer", periods(at(9, 0), at(10, 0), at(10, 20), at(11, 0)), 10 * time.Minute,
+			periods(at(8, 50), at(11, 10))},
+		{"apart with the buffer", periods(at(9, 0), at(10, 0), at(11, 0), at(12, 0)), 15 * time.Minute,
+			periods(at(8, 45), at(10, 15), at(10, 45), at(12, 15))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeBusy(tt.busy, tt.buffer); !equalPeriods(got, tt.want) {
+				t.Errorf("mergeBusy = %v, want %v", formatPeriods(got), formatPeriods(tt.want))
+			}
+		})
+	}
+}
+
+func TestMergeBusyKeepsInput(t *testing.T) {
+	busy := periods(at(9, 0), at(10, 0), at(9, 30), at(11, 0))
+	mergeBusy(busy, time.Hour)
+	if !busy[0].End.Equal(at(10, 0)) || !busy[0].Start.Equal(at(9, 0)) {
+		t.Errorf("mergeBusy changed its input: %v", formatPeriods(busy))
+	}
+}
+
+func TestFreeWindows(t *testing.T) {
+	tests := []struct {
+		name string
+		busy []*BusyPeriod
+		want []*BusyPeriod
+	}{
+		{"free day", nil, periods(at(9, 0), at(18, 0))},
+		{"busy in the middle", periods(at(12, 0), at(13, 0)),
+			periods(at(9, 0), at(12, 0), at(13, 0), at(18, 0))},
+		{"busy at the start", periods(at(8, 0), at(10, 0)),
+			periods(at(10, 0), at(18, 0))},
+		{"busy at the end", periods(at(17, 0), at(19, 0)),
+			periods(at(9, 0), at(17, 0))},
+		{"busy before and after the window", periods(at(6, 0), at(7, 0), at(19, 0), at(20, 0)),
+			periods(at(9, 0), at(18, 0))},
+		{"busy all day", periods(at(8, 0), at(19, 0)), nil},
+		{"several", periods(at(9, 0), at(10, 0), at(11, 0), at(12, 0), at(16, 0), at(18, 0)),
+			periods(at(10, 0), at(11, 0), at(12, 0), at(16, 0))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := freeWindows(at(9, 0), at(18, 0), tt.busy); !equalPeriods(got, tt.want) {
+				t.Errorf("freeWindows = %v, want %v", formatPeriods(got), formatPeriods(tt.want))
+			}
+		})
+	}
+}
+
+func TestSlotsAcrossDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone database: %v", err)
+	}
+	tests := []struct {
+		name string
+		day  time.Time
+	}{
+		{"clocks go forward", time.Date(2024, 3, 10, 0, 0, 0, 0, loc)},
+		{"clocks go back", time.Date(2024, 11, 3, 0, 0, 0, 0, loc)},
+		{"no change", time.Date(2024, 3, 9, 0, 0, 0, 0, loc)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := newSlotQuery(findFreeSlotsArgs{DurationMinutes: 60, StartTime: tt.day, EndTime: tt.day.AddDate(0, 0, 1),
+				IncludeWeekends: true, MaxResults: MAX_FREE_SLOTS}, loc)
+			if err != nil {
+				t.Fatalf("newSlotQuery: %v", err)
+			}
+			slots := q.slots(nil)
+			if len(slots) == 0 {
+				t.Fatal("no slots")
+			}
+			for _, s := range slots {
+				if start, end := s.StartTime.In(loc).Format("15:04"), s.EndTime.In(loc).Format("15:04"); start < DEFAULT_WORKDAY_START || end > DEFAULT_WORKDAY_END {
+					t.Errorf("slot %s – %s is outside the working hours", start, end)
+				}
+			}
+			if first := slots[0].StartTime.In(loc).Format("15:04"); first != DEFAULT_WORKDAY_START {
+				t.Errorf("first slot at %s, want %s", first, DEFAULT_WORKDAY_START)
+			}
+		})
+	}
+}
+
+// fakeFreeBusyGoogleRepo returns the busy periods of the calendars, the other calls panic
+type fakeFreeBusyGoogleRepo struct {
+	GoogleRepo
+	busy map[string][]*BusyPeriod
+}
+
+func (r *fakeFreeBusyGoogleRepo) FreeBusy(context.Context, *oauth2.Token, []string, time.Time, time.Time) (map[string][]*BusyPeriod, error) {
+	return r.busy, nil
+}
+
+func TestFindFreeSlotsUnavailableCalendar(t *testing.T) {
+	args := findFreeSlotsArgs{GoogleCalendarIDs: []string{"primary", "team"}, DurationMinutes: 60, StartTime: at(9, 0), EndTime: at(18, 0), MaxResults: 1}
+	tests := []struct {
+		name        string
+		busy        map[string][]*BusyPeriod
+		first       time.Time
+		unavailable []string
+		err         bool
+	}{
+		{"both calendars", map[string][]*BusyPeriod{"primary": periods(at(9, 0), at(10, 0)), "team": periods(at(10, 0), at(11, 0))},
+			at(11, 0), nil, false},
+		{"team calendar unavailable", map[string][]*BusyPeriod{"primary": periods(at(9, 0), at(10, 0))},
+			at(10, 0), []string{"team"}, false},
+		{"no calendar available", map[string][]*BusyPeriod{}, time.Time{}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tools := newCalendarTools(log.DefaultLogger, &fakeFreeBusyGoogleRepo{busy: tt.busy}, nil, nil)
+			ctx := SetToken(SetUser(context.Background(), &User{Timezone: "UTC"}), &oauth2.Token{AccessToken: "token"})
+			result, err := tools.findFreeSlots(ctx, args)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("findFreeSlots = %+v, want an error", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("findFreeSlots: %v", err)
+			}
+			if len(result.Slots) != 1 || !result.Slots[0].StartTime.Equal(tt.first) {
+				t.Errorf("slots = %+v, want the first at %s", result.Slots, tt.first)
+			}
+			if !reflect.DeepEqual(result.Unavailable, tt.unavailable) || (len(tt.unavailable) > 0) != (result.Warning != "") {
+				t.Errorf("unavailable = %v with warning %q, want %v", result.Unavailable, result.Warning, tt.unavailable)
+			}
+		})
+	}
+}
@@ -2,9 +2,11 @@ package biz
 
 import (
 	"context"
+	"errors"
 	"github.com/go-kratos/kratos/v2/log"
 	"golang.org/x/oauth2"
 	calendarAPI "google.golang.org/api/calendar/v3"
+	"time"
 )
 
 type GoogleRepo interface {
@@ -19,6 +21,45 @@ type GoogleRepo interface {
 	GetCalendarEvent(ctx context.Context, token *oauth2.Token, event *Event, calendarID string) (*Event, error)
 	DeleteCalendarEvent(ctx context.Context, token *oauth2.Token, event *Event, calendarID string) error
 	ListCalendarEvents(ctx context.Context, token *oauth2.Token, calendarID string, opts *GoogleListEventsOption) ([]*Event, error)
+	// SyncCalendarEvents lists the events changed since the sync token, or all the events of the window
+	// of the options for an empty token. It returns ErrSyncTokenExpired when Google no longer accepts the token.
+	SyncCalendarEvents(ctx context.Context, token *oauth2.Token, calendarID string, syncToken string, opts *GoogleListEventsOption) (*EventChanges, error)
+	// WatchCalendarEvents registers the channel for push notifications of the calendar events changes,
+	// it returns the channel with the resource ID and the expiration set by Google
+	WatchCalendarEvents(ctx context.Context, token *oauth2.Token, calendarID string, channel *WatchChannel, address string) (*WatchChannel, error)
+	StopWatchChannel(ctx context.Context, token *oauth2.Token, channel *WatchChannel) error
+	// RespondCalendarEvent sets the response of the user to the invitation
+	RespondCalendarEvent(ctx context.Context, token *oauth2.Token, event *Event, calendarID string, response string, comment string) (*Event, error)
+	// SearchContacts returns the contacts of the user matching the name or email
+	SearchContacts(ctx context.Context, token *oauth2.Token, query string, limit int) ([]*Contact, error)
+	// ListEventInstances lists the occurrences of the recurring event
+	ListEventInstances(ctx context.Context, token *oauth2.Token, calendarID string, eventID string, opts *GoogleListEventsOption) ([]*Event, error)
+	// CalendarTimezone returns the time zone of the user calendar settings
+	CalendarTimezone(ctx context.Context, token *oauth2.Token) (string, error)
+	// FreeBusy returns the busy periods of the calendars between timeMin and timeMax, keyed by the calendar ID
+	FreeBusy(ctx context.Context, token *oauth2.Token, calendarIDs []string, timeMin, timeMax time.Time) (map[string][]*BusyPeriod, error)
+}
+
+// ErrSyncTokenExpired is returned when the sync token is invalidated by Google, the calendar needs a full sync
+var ErrSyncTokenExpired = errors.New("sync token expired")
+
+// ErrContactsScopeMissing is returned when the Google token was granted before the contacts were asked for
+var ErrContactsScopeMissing = errors.New("contacts scope missing")
+
+// EventChanges is the result of an events sync with all the pages listed
+type EventChanges struct {
+	// Events are the changed events, the cancelled ones are to be deleted
+	Events []*Event
+	// NextSyncToken is stored to list only the changes made after this sync
+	NextSyncToken string
+	// Full is set when the events are all the events of the calendar rather than the changes
+	Full bool
+}
+
+// BusyPeriod is a time range in which a calendar has events
+type BusyPeriod struct {
+	Start time.Time
+	End   time.Time
 }
 
 type GoogleUseCase struct {
@@ -63,6 +104,18 @@ func (uc *GoogleUseCase) ListUserCalendars(ctx context.Context, token *oauth2.To
 	return uc.repo.ListUserCalendars(ctx, token)
 }
 
+// SyncCalendarEvents lists the events changed since the sync token
+func (uc *GoogleUseCase) SyncCalendarEvents(ctx context.Context, token *oauth2.Token, calendarID string, syncToken string, opts *GoogleListEventsOption) (*EventChanges, error) {
+	uc.log.Debugf("SyncCalendarEvents calendarID: %s, incremental: %t", calendarID, syncToken != "")
+	return uc.repo.SyncCalendarEvents(ctx, token, calendarID, syncToken, opts)
+}
+
+// CalendarTimezone returns the time zone of the user calendar settings
+func (uc *GoogleUseCase) CalendarTimezone(ctx context.Context, token *oauth2.Token) (string, error) {
+	uc.log.Debugf("CalendarTimezone")
+	return uc.repo.CalendarTimezone(ctx, token)
+}
+
 // GoogleListEventsOption is the option for list events
 type GoogleListEventsOption struct {
 	TimeMin           string
@@ -70,6 +123,8 @@ type GoogleListEventsOption struct {
 	UpdatedMin        string
 	MaxResults        int64
 	OrderByUpdateTime bool
+	// OriginalStart selects the occurrence of a series by its original start, only for instances
+	OriginalStart string
 }
 
 // ListEventsCallWithOpts returns a call to list events
@@ -103,6 +158,9 @@ func (o *GoogleListEventsOption) ListEventsInstancesCallWithOpts(call *calendarA
 	if o.MaxResults > 0 {
 		call = call.MaxResults(o.MaxResults)
 	}
+	if o.OriginalStart != "" {
+		call = call.OriginalStart(o.OriginalStart)
+	}
 	return call
 }
 
@@ -0,0 +1,176 @@
+package biz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/google/uuid"
+	"time"
+)
+
+//goland:noinspection GoSnakeCaseUsage
+const (
+	// LOCK_RETRY_INTERVAL is how often a waiting Lock tries to acquire the lock again
+	LOCK_RETRY_INTERVAL = 500 * time.Millisecond
+)
+
+// ErrLockHeld is returned by TryLock when another process holds the lock
+var ErrLockHeld = errors.New("lock is held by another process")
+
+// LockRepo is a lease shared by the replicas, the lease is owned by the token that acquired it
+type LockRepo interface {
+	// Acquire takes the lease when it is free
+	Acquire(ctx context.Context, name string, owner string, ttl time.Duration) (bool, error)
+	// Renew extends the lease when it is still owned
+	Renew(ctx context.Context, name string, owner string, ttl time.Duration) (bool, error)
+	// Release frees the lease when it is still owned
+	Release(ctx context.Context, name string, owner string) error
+}
+
+type LockUseCase struct {
+	db  LockRepo
+	log *log.Helper
+}
+
+func NewLockUseCase(repo LockRepo, logger log.Logger) *LockUseCase {
+	return &LockUseCase{
+		db:  repo,
+		log: log.NewHelper(log.With(logger, "caller", "biz.lock.usecase")),
+	}
+}
+
+// Lock is an acquired lease, it is renewed in the background until Unlock
+type Lock struct {
+	uc     *LockUseCase
+	name   string
+	owner  string
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// UserSyncLockName is the lock of the calendars sync of the user
+func UserSyncLockName(userID uuid.UUID) string {
+	return fmt.Sprintf("sync:user:%s", userID)
+}
+
+// JobLockName is the lock of the scheduled runs of the cron job
+func JobLockName(job string) string {
+	return fmt.Sprintf("cron:job:%s", job)
+}
+
+// JobRunLockName is the lock of a running cron job, scheduled or triggered
+func JobRunLockName(job string) string {
+	return fmt.Sprintf("cron:job:%s:running", job)
+}
+
+// TryLock acquires the lock or returns ErrLockHeld right away
+func (uc *LockUseCase) TryLock(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	owner := uuid.NewString()
+	acquired := time.Now()
+	ok, err := uc.db.Acquire(ctx, name, owner, ttl)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrLockHeld
+	}
+	uc.log.Debugf("lock use case: acquired %s", name)
+	lockCtx, cancel := context.WithCancel(ctx)
+	l := &Lock{
+		uc:     uc,
+		name:   name,
+		owner:  owner,
+		ctx:    lockCtx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go l.renew(ttl, acquired)
+	return l, nil
+}
+
+// Once returns true only for the first call with the name until the ttl passes,
+// the lease is never released, so it marks a thing done once across the replicas
+func (uc *LockUseCase) Once(ctx context.Context, name string, ttl time.Duration) (bool, error) {
+	return uc.db.Acquire(ctx, name, uuid.NewString(), ttl)
+}
+
+// Lock waits until the lock is acquired or the context is done
+func (uc *LockUseCase) Lock(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	for {
+		l, err := uc.TryLock(ctx, name, ttl)
+		if !errors.Is(err, ErrLockHeld) {
+			return l, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for lock %s: %w", name, ctx.Err())
+		case <-time.After(LOCK_RETRY_INTERVAL):
+		}
+	}
+}
+
+// renew extends the lease at a third of its lifetime, the context of the lock is cancelled when the lease is lost.
+// A failed renewal is retried on the next tick until the last renewed lease runs out, then the lock is given up,
+// another process may hold it already.
+func (l *Lock) renew(ttl time.Duration, lastRenew time.Time) {
+	defer close(l.done)
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.ctx.Done():
+			return
+		case <-ticker.C:
+			renewed := time.Now()
+			ok, err := l.uc.db.Renew(l.ctx, l.name, l.owner, ttl)
+			if err != nil {
+				l.uc.log.Errorf("lock use case: renew %s: %v", l.name, err)
+				if time.Since(lastRenew) >= ttl {
+					l.uc.log.Errorf("lock use case: lost %s, the lease expired", l.name)
+					l.cancel()
+					return
+				}
+				continue
+			}
+			if !ok {
+				l.uc.log.Errorf("lock use case: lost %s", l.name)
+				l.cancel()
+				return
+			}
+			lastRenew = renewed
+		}
+	}
+}
+
+// Context is done when the lock is released or its lease is lost
+func (l *Lock) Context() context.Context {
+	return l.ctx
+}
+
+// Unlock stops the renewal and releases the lease
+func (l *Lock) Unlock() {
+	l.Keep(0)
+}
+
+// Keep stops the renewal and leaves the lease to expire after the duration,
+// so the other processes do not take the lock until then. A zero duration releases the lease.
+func (l *Lock) Keep(d time.Duration) {
+	l.cancel()
+	<-l.done
+	// the lock context is done already, the lease is released even when the parent context is over
+	ctx, cancel := context.WithTimeout(context.Background(), LOCK_RETRY_INTERVAL*4)
+	defer cancel()
+	if d > 0 {
+		if _, err := l.uc.db.Renew(ctx, l.name, l.owner, d); err != nil {
+			l.uc.log.Errorf("lock use case: keep %s: %v", l.name, err)
+		}
+		return
+	}
+	if err := l.uc.db.Release(ctx, l.name, l.owner); err != nil {
+		l.uc.log.Errorf("lock use case: release %s: %v", l.name, err)
+		return
+	}
+	l.uc.log.Debugf("lock use case: released %s", l.name)
+}
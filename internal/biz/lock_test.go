@@ -0,0 +1,70 @@
+package biz
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/log"
+)
+
+// fakeLockRepo grants every lease, the renewals fail with the error
+type fakeLockRepo struct {
+	mu       sync.Mutex
+	renewErr error
+	renewals int
+}
+
+func (r *fakeLockRepo) Acquire(context.Context, string, string, time.Duration) (bool, error) {
+	return true, nil
+}
+
+func (r *fakeLockRepo) Renew(context.Context, string, string, time.Duration) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.renewals++
+	return r.renewErr == nil, r.renewErr
+}
+
+func (r *fakeLockRepo) Release(context.Context, string, string) error {
+	return nil
+}
+
+func TestLockRenewFailures(t *testing.T) {
+	const ttl = 60 * time.Millisecond
+	tests := []struct {
+		name     string
+		renewErr error
+		lost     bool
+	}{
+		{"renewed", nil, false},
+		{"renew errors until the lease expires", errors.New("connection refused"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeLockRepo{renewErr: tt.renewErr}
+			l, err := NewLockUseCase(repo, log.DefaultLogger).TryLock(context.Background(), "test", ttl)
+			if err != nil {
+				t.Fatalf("TryLock: %v", err)
+			}
+			defer l.Unlock()
+			select {
+			case <-l.Context().Done():
+				if !tt.lost {
+					t.Fatal("the renewed lock was lost")
+				}
+			case <-time.After(3 * ttl):
+				if tt.lost {
+					t.Fatal("the lock outlived its lease")
+				}
+			}
+			repo.mu.Lock()
+			defer repo.mu.Unlock()
+			if repo.renewals < 2 {
+				t.Errorf("renewed %d times, want the renewal retried", repo.renewals)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package biz
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kratos/kratos/v2/log"
+)
+
+// ErrUserNotReachable is returned for users without a messenger to notify
+var ErrUserNotReachable = errors.New("user has no messenger to notify")
+
+// Notification is a message sent to the user on the initiative of the service
+type Notification struct {
+	Text string
+	// Actions are sent after the text, each with the buttons to confirm or cancel it
+	Actions []*PendingAction
+}
+
+// Notifier delivers the notifications to the messenger of the user
+type Notifier interface {
+	Notify(ctx context.Context, user *User, notification *Notification) error
+}
+
+type NotificationUseCase struct {
+	n   Notifier
+	log *log.Helper
+}
+
+func NewNotificationUseCase(n Notifier, logger log.Logger) *NotificationUseCase {
+	return &NotificationUseCase{
+		n:   n,
+		log: log.NewHelper(log.With(logger, "caller", "biz.notification.usecase")),
+	}
+}
+
+// Notify sends the notification to the user
+func (uc *NotificationUseCase) Notify(ctx context.Context, user *User, notification *Notification) error {
+	uc.log.Debugf("notification use case: notify user %s", user.ID)
+	if user.TGID == "" {
+		return ErrUserNotReachable
+	}
+	return uc.n.Notify(ctx, user, notification)
+}
@@ -0,0 +1,130 @@
+package biz
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/google/uuid"
+	"sync"
+	"time"
+)
+
+//goland:noinspection GoSnakeCaseUsage
+const (
+	DEFAULT_PENDING_ACTION_TTL = 15 * time.Minute
+
+	PENDING_ACTION_STATUS = "pending_confirmation"
+
+	// PENDING_ACTION_CONFIRM and PENDING_ACTION_CANCEL are the answers of the messenger buttons, the data of a button is <answer>:<action id>
+	PENDING_ACTION_CONFIRM = "confirm"
+	PENDING_ACTION_CANCEL  = "cancel"
+)
+
+// PendingAction is a tool call staged until the user confirms or rejects it
+type PendingAction struct {
+	ID        uuid.UUID `json:"id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Function  string    `json:"function"`
+	Arguments string    `json:"arguments"`
+	// Preview describes the change for the user
+	Preview   string    `json:"preview"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// PendingActionRepo stores the staged actions until they expire
+type PendingActionRepo interface {
+	Create(ctx context.Context, action *PendingAction) error
+	// Get returns nil if the action does not exist or has expired
+	Get(ctx context.Context, id uuid.UUID) (*PendingAction, error)
+	// Take removes the action and returns it, nil if it has already been taken or has expired
+	Take(ctx context.Context, id uuid.UUID) (*PendingAction, error)
+}
+
+// pendingActionResult is returned to the model instead of the result of a staged tool call
+type pendingActionResult struct {
+	Status   string    `json:"status"`
+	ActionID uuid.UUID `json:"action_id"`
+	Preview  string    `json:"preview"`
+	Note     string    `json:"note"`
+}
+
+//goland:noinspection GoSnakeCaseUsage
+const STAGED_ACTIONS_KEY = "staged_actions"
+
+// stagedActions collects the actions staged during a chat turn
+type stagedActions struct {
+	mu      sync.Mutex
+	userID  uuid.UUID
+	actions []*PendingAction
+}
+
+// withStagedActions returns context in which destructive tool calls of the user are staged instead of executed
+func withStagedActions(ctx context.Context, userID uuid.UUID) (context.Context, *stagedActions) {
+	staged := &stagedActions{userID: userID}
+	return context.WithValue(ctx, STAGED_ACTIONS_KEY, staged), staged
+}
+
+func getStagedActions(ctx context.Context) *stagedActions {
+	staged, _ := ctx.Value(STAGED_ACTIONS_KEY).(*stagedActions)
+	return staged
+}
+
+func (s *stagedActions) add(action *PendingAction) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.actions = append(s.actions, action)
+}
+
+func (s *stagedActions) list() []*PendingAction {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	actions := make([]*PendingAction, len(s.actions))
+	copy(actions, s.actions)
+	return actions
+}
+
+// staged wraps the handler of a destructive tool. In a chat turn the call is stored as a pending action
+// with a preview of the change and executed only when the user confirms it, otherwise it is executed directly.
+// The optional check refuses to stage a call that would fail anyway.
+func staged[Args any, Result any](
+	ts *Toolset,
+	name string,
+	check func(ctx context.Context, args Args) error,
+	preview func(ctx context.Context, args Args) string,
+	handler func(ctx context.Context, args Args) (Result, error),
+) func(ctx context.Context, args Args) (interface{}, error) {
+	return func(ctx context.Context, args Args) (interface{}, error) {
+		actions := getStagedActions(ctx)
+		if actions == nil {
+			return handler(ctx, args)
+		}
+		if check != nil {
+			if err := check(ctx, args); err != nil {
+				return nil, err
+			}
+		}
+		arguments, err := json.Marshal(args)
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		action := &PendingAction{
+			UserID:    actions.userID,
+			Function:  name,
+			Arguments: string(arguments),
+			Preview:   preview(ctx, args),
+			CreatedAt: now,
+			ExpiresAt: now.Add(ts.actionTTL),
+		}
+		if err := ts.par.Create(ctx, action); err != nil {
+			return nil, err
+		}
+		actions.add(action)
+		return &pendingActionResult{
+			Status:   PENDING_ACTION_STATUS,
+			ActionID: action.ID,
+			Preview:  action.Preview,
+			Note:     "The change is not applied yet. Tell the user what will change, they confirm or cancel it with the buttons below your answer.",
+		}, nil
+	}
+}
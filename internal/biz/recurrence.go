@@ -0,0 +1,426 @@
+package biz
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//goland:noinspection GoSnakeCaseUsage
+const (
+	// RECURRENCE_SCOPE_THIS changes one occurrence of the series
+	RECURRENCE_SCOPE_THIS = "this"
+	// RECURRENCE_SCOPE_FOLLOWING changes the occurrence and all following ones, the series is split in two
+	RECURRENCE_SCOPE_FOLLOWING = "following"
+	// RECURRENCE_SCOPE_ALL changes the whole series
+	RECURRENCE_SCOPE_ALL = "all"
+
+	RRULE_PREFIX      = "RRULE:"
+	RRULE_TIME_LAYOUT = "20060102T150405Z"
+	// RRULE_DATE_LAYOUT is the UNTIL of the all-day series, they have no time of day
+	RRULE_DATE_LAYOUT = "20060102"
+)
+
+var rruleFrequencies = map[string]bool{"DAILY": true, "WEEKLY": true, "MONTHLY": true, "YEARLY": true}
+
+var rruleWeekdays = map[string]bool{"MO": true, "TU": true, "WE": true, "TH": true, "FR": true, "SA": true, "SU": true}
+
+type createRecurringEventArgs struct {
+	GoogleCalendarID string     `json:"google_calendar_id" description:"The Google ID of the calendar for the series creation."`
+	Title            string     `json:"title" description:"The summary or title of the events."`
+	Location         string     `json:"location,omitempty" description:"The location of the events."`
+	StartTime        time.Time  `json:"start_time" description:"The start time of the first occurrence in RFC3339 format."`
+	EndTime          time.Time  `json:"end_time" description:"The end time of the first occurrence in RFC3339 format."`
+	Frequency        string     `json:"frequency" enum:"daily,weekly,monthly,yearly" description:"How often the event repeats."`
+	Interval         int        `json:"interval,omitempty" description:"Repeat every interval periods, e.g. 2 with weekly is every other week, defaults to 1."`
+	ByDay            []string   `json:"by_day,omitempty" description:"The weekdays of a weekly series as MO, TU, WE, TH, FR, SA, SU."`
+	Count            int        `json:"count,omitempty" description:"The number of occurrences, can not be combined with until."`
+	Until            *time.Time `json:"until,omitempty" description:"The last time an occurrence may start in RFC3339 format, can not be combined with count."`
+	AddVideoCall     bool       `json:"add_video_call,omitempty" description:"Whether to add a Google Meet video call link to the events."`
+}
+
+type updateRecurringEventArgs struct {
+	GoogleCalendarID  string     `json:"google_calendar_id" description:"The ID of the Google calendar of the series."`
+	GoogleEventID     string     `json:"google_event_id" description:"The Google ID of the occurrence, or of the series together with original_start_time."`
+	OriginalStartTime *time.Time `json:"original_start_time,omitempty" description:"The original start time of the occurrence in RFC3339 format, needed when google_event_id is the series ID."`
+	Scope             string     `json:"scope" enum:"this,following,all" description:"Change only this occurrence, this and all following occurrences, or all occurrences."`
+	Title             string     `json:"title,omitempty" description:"The new summary or title."`
+	Location          string     `json:"location,omitempty" description:"The new location."`
+	StartTime         *time.Time `json:"start_time,omitempty" description:"The new start time of the occurrence in RFC3339 format, the other occurrences of the scope move by the same amount."`
+	EndTime           *time.Time `json:"end_time,omitempty" description:"The new end time of the occurrence in RFC3339 format."`
+}
+
+type deleteRecurringEventArgs struct {
+	GoogleCalendarID  string     `json:"google_calendar_id" description:"The ID of the Google calendar of the series."`
+	GoogleEventID     string     `json:"google_event_id" description:"The Google ID of the occurrence, or of the series together with original_start_time."`
+	OriginalStartTime *time.Time `json:"original_start_time,omitempty" description:"The original start time of the occurrence in RFC3339 format, needed when google_event_id is the series ID."`
+	Scope             string     `json:"scope" enum:"this,following,all" description:"Cancel only this occurrence, this and all following occurrences, or the whole series."`
+}
+
+// buildRRule returns the RRULE line of the series
+func buildRRule(args createRecurringEventArgs) (string, error) {
+	frequency := strings.ToUpper(args.Frequency)
+	if !rruleFrequencies[frequency] {
+		return "", fmt.Errorf("unknown frequency %q", args.Frequency)
+	}
+	parts := []string{"FREQ=" + frequency}
+	if args.Interval > 1 {
+		parts = append(parts, fmt.Sprintf("INTERVAL=%d", args.Interval))
+	}
+	if len(args.ByDay) > 0 {
+		days := make([]string, len(args.ByDay))
+		for i, day := range args.ByDay {
+			days[i] = strings.ToUpper(day)
+			if !rruleWeekdays[days[i]] {
+				return "", fmt.Errorf("unknown weekday %q, use MO, TU, WE, TH, FR, SA or SU", day)
+			}
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if args.Count > 0 && args.Until != nil {
+		return "", fmt.Errorf("count and until can not be combined")
+	}
+	if args.Count > 0 {
+		parts = append(parts, fmt.Sprintf("COUNT=%d", args.Count))
+	}
+	if args.Until != nil {
+		parts = append(parts, ruleUntil(*args.Until, false))
+	}
+	return RRULE_PREFIX + strings.Join(parts, ";"), nil
+}
+
+// ruleCount returns the COUNT of the RRULE, 0 if the series is not limited by a count
+func ruleCount(recurrence []string) int {
+	for _, line := range recurrence {
+		if !strings.HasPrefix(line, RRULE_PREFIX) {
+			continue
+		}
+		for _, part := range strings.Split(strings.TrimPrefix(line, RRULE_PREFIX), ";") {
+			if value, ok := strings.CutPrefix(part, "COUNT="); ok {
+				count, _ := strconv.Atoi(value)
+				return count
+			}
+		}
+	}
+	return 0
+}
+
+// ruleUntil returns the UNTIL part of the RRULE, a date for the all-day series and a UTC date-time otherwise
+func ruleUntil(until time.Time, allDay bool) string {
+	if allDay {
+		return "UNTIL=" + until.Format(RRULE_DATE_LAYOUT)
+	}
+	return "UNTIL=" + until.UTC().Format(RRULE_TIME_LAYOUT)
+}
+
+// withRuleEnd returns the recurrence with the end of the RRULE replaced by until or by count
+func withRuleEnd(recurrence []string, until time.Time, allDay bool, count int) []string {
+	lines := make([]string, len(recurrence))
+	for i, line := range recurrence {
+		lines[i] = line
+		if !strings.HasPrefix(line, RRULE_PREFIX) {
+			continue
+		}
+		var parts []string
+		for _, part := range strings.Split(strings.TrimPrefix(line, RRULE_PREFIX), ";") {
+			if !strings.HasPrefix(part, "COUNT=") && !strings.HasPrefix(part, "UNTIL=") {
+				parts = append(parts, part)
+			}
+		}
+		if !until.IsZero() {
+			parts = append(parts, ruleUntil(until, allDay))
+		} else if count > 0 {
+			parts = append(parts, fmt.Sprintf("COUNT=%d", count))
+		}
+		lines[i] = RRULE_PREFIX + strings.Join(parts, ";")
+	}
+	return lines
+}
+
+func (t *calendarTools) createRecurringEvent(ctx context.Context, args createRecurringEventArgs) (*changedEventResult, error) {
+	t.log.Debugf("createRecurringEvent: %+v", args)
+	token := GetToken(ctx)
+	if token == nil {
+		return nil, errTokenNotFound
+	}
+	rule, err := buildRRule(args)
+	if err != nil {
+		return nil, err
+	}
+	// the first occurrence is checked, the series is too long to check every occurrence, the tool description says so
+	conflicts, err := t.checkConflicts(ctx, args.GoogleCalendarID, args.StartTime, args.EndTime, "")
+	if err != nil {
+		return nil, err
+	}
+	event := &Event{
+		Summary:      args.Title,
+		Location:     args.Location,
+		StartTime:    args.StartTime,
+		EndTime:      args.EndTime,
+		TimeZone:     userLocation(ctx).String(),
+		Recurrence:   []string{rule},
+		AddVideoCall: args.AddVideoCall,
+	}
+	e, err := t.gr.CreateCalendarEvent(ctx, token, event, googleCalendarID(args.GoogleCalendarID))
+	if err != nil {
+		return nil, err
+	}
+	t.recordCreated(ctx, args.GoogleCalendarID, e)
+	return newChangedEventResult(ctx, e, conflicts), nil
+}
+
+// occurrence returns the series and its occurrence addressed by the event ID and the original start time,
+// the occurrence is nil when the series ID is given without the original start time
+func (t *calendarTools) occurrence(ctx context.Context, calendarID string, eventID string, originalStart *time.Time) (*Event, *Event, error) {
+	token := GetToken(ctx)
+	if token == nil {
+		return nil, nil, errTokenNotFound
+	}
+	event, err := t.gr.GetCalendarEvent(ctx, token, &Event{GoogleID: eventID}, calendarID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if event.RecurringEventID != "" {
+		series, err := t.gr.GetCalendarEvent(ctx, token, &Event{GoogleID: event.RecurringEventID}, calendarID)
+		if err != nil {
+			return nil, nil, err
+		}
+		return series, event, nil
+	}
+	if len(event.Recurrence) == 0 {
+		return nil, nil, fmt.Errorf("event %s is not recurring, use %s or %s", eventID, FUNCTION_UPDATE_EVENT, FUNCTION_DELETE_EVENT)
+	}
+	if originalStart == nil {
+		return event, nil, nil
+	}
+	instances, err := t.gr.ListEventInstances(ctx, token, calendarID, event.GoogleID, &GoogleListEventsOption{
+		OriginalStart: originalStart.Format(time.RFC3339),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(instances) == 0 {
+		return nil, nil, fmt.Errorf("series %s has no occurrence starting at %s", eventID, originalStart.Format(time.RFC3339))
+	}
+	return event, instances[0], nil
+}
+
+// recurringTarget resolves the series and the occurrence of the scope,
+// a change of the following occurrences from the first one is a change of the whole series
+func (t *calendarTools) recurringTarget(ctx context.Context, calendarID string, eventID string, originalStart *time.Time, scope string) (*Event, *Event, string, error) {
+	series, occurrence, err := t.occurrence(ctx, googleCalendarID(calendarID), eventID, originalStart)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	switch scope {
+	case RECURRENCE_SCOPE_THIS, RECURRENCE_SCOPE_FOLLOWING:
+		if occurrence == nil {
+			return nil, nil, "", fmt.Errorf("original_start_time is required to change %s occurrence of the series", scope)
+		}
+		if scope == RECURRENCE_SCOPE_FOLLOWING && !occurrence.OriginalStartTime.After(series.StartTime) {
+			scope = RECURRENCE_SCOPE_ALL
+		}
+	case RECURRENCE_SCOPE_ALL:
+	default:
+		return nil, nil, "", fmt.Errorf("unknown scope %q, use %s, %s or %s", scope, RECURRENCE_SCOPE_THIS, RECURRENCE_SCOPE_FOLLOWING, RECURRENCE_SCOPE_ALL)
+	}
+	return series, occurrence, scope, nil
+}
+
+// scopeDescription describes the occurrences of the scope
+func scopeDescription(scope string, series *Event, occurrence *Event, loc *time.Location) string {
+	switch scope {
+	case RECURRENCE_SCOPE_THIS:
+		return fmt.Sprintf("the occurrence of %q on %s", series.Summary, previewTime(occurrence.StartTime, loc))
+	case RECURRENCE_SCOPE_FOLLOWING:
+		return fmt.Sprintf("the occurrences of %q from %s on", series.Summary, previewTime(occurrence.StartTime, loc))
+	default:
+		return fmt.Sprintf("all occurrences of %q", series.Summary)
+	}
+}
+
+// applyRecurringChanges applies the changes of the arguments to the event, the new times of the occurrence
+// move the event by the same amount, so a series keeps its rhythm
+func applyRecurringChanges(event *Event, reference *Event, args updateRecurringEventArgs) {
+	if args.Title != "" {
+		event.Summary = args.Title
+	}
+	if args.Location != "" {
+		event.Location = args.Location
+	}
+	if args.StartTime != nil {
+		event.StartTime = event.StartTime.Add(args.StartTime.Sub(reference.StartTime))
+	}
+	if args.EndTime != nil {
+		event.EndTime = event.EndTime.Add(args.EndTime.Sub(reference.EndTime))
+	}
+}
+
+// checkUpdateRecurringEvent refuses to stage an update of occurrences that can not be found
+func (t *calendarTools) checkUpdateRecurringEvent(ctx context.Context, args updateRecurringEventArgs) error {
+	_, _, _, err := t.recurringTarget(ctx, args.GoogleCalendarID, args.GoogleEventID, args.OriginalStartTime, args.Scope)
+	return err
+}
+
+func (t *calendarTools) updateRecurringEventPreview(ctx context.Context, args updateRecurringEventArgs) string {
+	series, occurrence, scope, err := t.recurringTarget(ctx, args.GoogleCalendarID, args.GoogleEventID, args.OriginalStartTime, args.Scope)
+	if err != nil {
+		t.log.Errorf("updateRecurringEventPreview: %v", err)
+		return fmt.Sprintf("Update %s occurrences of event %s", args.Scope, args.GoogleEventID)
+	}
+	loc := userLocation(ctx)
+	lines := []string{fmt.Sprintf("Update %s:", scopeDescription(scope, series, occurrence, loc))}
+	if args.Title != "" {
+		lines = append(lines, fmt.Sprintf("title: %s → %s", series.Summary, args.Title))
+	}
+	if args.Location != "" {
+		lines = append(lines, fmt.Sprintf("location: %s → %s", series.Location, args.Location))
+	}
+	if args.StartTime != nil {
+		lines = append(lines, fmt.Sprintf("start: %s", previewTime(*args.StartTime, loc)))
+	}
+	if args.EndTime != nil {
+		lines = append(lines, fmt.Sprintf("end: %s", previewTime(*args.EndTime, loc)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (t *calendarTools) updateRecurringEvent(ctx context.Context, args updateRecurringEventArgs) (*eventResult, error) {
+	t.log.Debugf("updateRecurringEvent: %+v", args)
+	calendarID := googleCalendarID(args.GoogleCalendarID)
+	series, occurrence, scope, err := t.recurringTarget(ctx, calendarID, args.GoogleEventID, args.OriginalStartTime, args.Scope)
+	if err != nil {
+		return nil, err
+	}
+	token := GetToken(ctx)
+	switch scope {
+	case RECURRENCE_SCOPE_THIS:
+		event := *occurrence
+		applyRecurringChanges(&event, occurrence, args)
+		if args.StartTime != nil || args.EndTime != nil {
+			if _, err := t.checkConflicts(ctx, calendarID, event.StartTime, event.EndTime, event.GoogleID); err != nil {
+				return nil, err
+			}
+		}
+		e, err := t.gr.UpdateCalendarEvent(ctx, token, &event, calendarID)
+		if err != nil {
+			return nil, err
+		}
+		t.recordUpdated(ctx, calendarID, e)
+		return newEventResult(e), nil
+	case RECURRENCE_SCOPE_FOLLOWING:
+		return t.splitSeries(ctx, calendarID, series, occurrence, args)
+	default:
+		reference := series
+		if occurrence != nil {
+			reference = occurrence
+		}
+		event := *series
+		applyRecurringChanges(&event, reference, args)
+		e, err := t.gr.UpdateCalendarEvent(ctx, token, &event, calendarID)
+		if err != nil {
+			return nil, err
+		}
+		t.recordUpdated(ctx, calendarID, e)
+		return newEventResult(e), nil
+	}
+}
+
+// splitSeries ends the series before the occurrence and starts a new series with the changes from the occurrence on
+func (t *calendarTools) splitSeries(ctx context.Context, calendarID string, series *Event, occurrence *Event, args updateRecurringEventArgs) (*eventResult, error) {
+	token := GetToken(ctx)
+	recurrence := series.Recurrence
+	if count := ruleCount(series.Recurrence); count > 0 {
+		before, err := t.gr.ListEventInstances(ctx, token, calendarID, series.GoogleID, &GoogleListEventsOption{
+			TimeMin: series.StartTime.Format(time.RFC3339),
+			TimeMax: occurrence.OriginalStartTime.Format(time.RFC3339),
+		})
+		if err != nil {
+			return nil, err
+		}
+		recurrence = withRuleEnd(series.Recurrence, time.Time{}, false, count-len(before))
+	}
+	start := occurrence.OriginalStartTime
+	next := &Event{
+		Summary:    series.Summary,
+		Location:   series.Location,
+		StartTime:  start,
+		EndTime:    start.Add(series.EndTime.Sub(series.StartTime)),
+		TimeZone:   series.TimeZone,
+		Recurrence: recurrence,
+	}
+	applyRecurringChanges(next, occurrence, args)
+	created, err := t.gr.CreateCalendarEvent(ctx, token, next, calendarID)
+	if err != nil {
+		return nil, err
+	}
+	ended, err := t.endSeries(ctx, calendarID, series, occurrence)
+	if err != nil {
+		// do not leave the following occurrences twice in the calendar
+		if err := t.gr.DeleteCalendarEvent(ctx, token, created, calendarID); err != nil {
+			t.log.Errorf("removing the new series %s: %v", created.GoogleID, err)
+		}
+		return nil, err
+	}
+	// the new series is recorded before the ended one, so the undo restores the original series first
+	t.recordCreated(ctx, calendarID, created)
+	t.recordUpdated(ctx, calendarID, ended)
+	return newEventResult(created), nil
+}
+
+// endSeries ends the series before the occurrence and returns the ended series.
+// An all-day series ends with the day before the occurrence, UNTIL is a date for it.
+func (t *calendarTools) endSeries(ctx context.Context, calendarID string, series *Event, occurrence *Event) (*Event, error) {
+	event := *series
+	until := occurrence.OriginalStartTime.Add(-time.Second)
+	if series.IsAllDay {
+		until = occurrence.OriginalStartTime.AddDate(0, 0, -1)
+	}
+	event.Recurrence = withRuleEnd(series.Recurrence, until, series.IsAllDay, 0)
+	return t.gr.UpdateCalendarEvent(ctx, GetToken(ctx), &event, calendarID)
+}
+
+// checkDeleteRecurringEvent refuses to stage a deletion of occurrences that can not be found
+func (t *calendarTools) checkDeleteRecurringEvent(ctx context.Context, args deleteRecurringEventArgs) error {
+	_, _, _, err := t.recurringTarget(ctx, args.GoogleCalendarID, args.GoogleEventID, args.OriginalStartTime, args.Scope)
+	return err
+}
+
+func (t *calendarTools) deleteRecurringEventPreview(ctx context.Context, args deleteRecurringEventArgs) string {
+	series, occurrence, scope, err := t.recurringTarget(ctx, args.GoogleCalendarID, args.GoogleEventID, args.OriginalStartTime, args.Scope)
+	if err != nil {
+		t.log.Errorf("deleteRecurringEventPreview: %v", err)
+		return fmt.Sprintf("Delete %s occurrences of event %s", args.Scope, args.GoogleEventID)
+	}
+	return fmt.Sprintf("Delete %s", scopeDescription(scope, series, occurrence, userLocation(ctx)))
+}
+
+func (t *calendarTools) deleteRecurringEvent(ctx context.Context, args deleteRecurringEventArgs) (*deleteEventResult, error) {
+	t.log.Debugf("deleteRecurringEvent: %+v", args)
+	calendarID := googleCalendarID(args.GoogleCalendarID)
+	series, occurrence, scope, err := t.recurringTarget(ctx, calendarID, args.GoogleEventID, args.OriginalStartTime, args.Scope)
+	if err != nil {
+		return nil, err
+	}
+	switch scope {
+	case RECURRENCE_SCOPE_THIS:
+		if err := t.gr.DeleteCalendarEvent(ctx, GetToken(ctx), occurrence, calendarID); err != nil {
+			return nil, err
+		}
+		t.recordDeleted(ctx, calendarID, occurrence)
+	case RECURRENCE_SCOPE_FOLLOWING:
+		ended, err := t.endSeries(ctx, calendarID, series, occurrence)
+		if err != nil {
+			return nil, err
+		}
+		t.recordUpdated(ctx, calendarID, ended)
+	default:
+		if err := t.gr.DeleteCalendarEvent(ctx, GetToken(ctx), series, calendarID); err != nil {
+			return nil, err
+		}
+		t.recordDeleted(ctx, calendarID, series)
+	}
+	return &deleteEventResult{GoogleEventID: args.GoogleEventID, Deleted: true}, nil
+}
@@ -0,0 +1,208 @@
+package biz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+)
+
+// fakeEventHistoryRepo lists the changes recorded by the fake event repo
+type fakeEventHistoryRepo struct {
+	EventHistoryRepo
+	events *fakeEventRepo
+}
+
+func (r *fakeEventHistoryRepo) ListUndoable(_ context.Context, _ uuid.UUID, limit int) ([]*EventHistory, error) {
+	var changes []*EventHistory
+	for i := len(r.events.changes) - 1; i >= 0 && len(changes) < limit; i-- {
+		if c := r.events.changes[i]; c.Initiator == ASSISTANT && !c.Reverted {
+			changes = append(changes, c)
+		}
+	}
+	return changes, nil
+}
+
+func (r *fakeEventHistoryRepo) MarkReverted(_ context.Context, id uuid.UUID) error {
+	for _, c := range r.events.changes {
+		if c.ID == id {
+			c.Reverted = true
+		}
+	}
+	return nil
+}
+
+// fakeCalendarRepo returns the calendar for every Get call, the other calls panic
+type fakeCalendarRepo struct {
+	CalendarRepo
+	calendar *Calendar
+}
+
+func (r *fakeCalendarRepo) Get(context.Context, *Calendar) (*Calendar, error) {
+	return r.calendar, nil
+}
+
+// fakeCalendarGoogleRepo keeps the Google events of one calendar in memory, the other calls panic
+type fakeCalendarGoogleRepo struct {
+	GoogleRepo
+	events map[string]*Event
+	next   int
+}
+
+func newFakeCalendarGoogleRepo(events ...*Event) *fakeCalendarGoogleRepo {
+	r := &fakeCalendarGoogleRepo{events: make(map[string]*Event)}
+	for _, e := range events {
+		c := *e
+		r.events[e.GoogleID] = &c
+	}
+	return r
+}
+
+func (r *fakeCalendarGoogleRepo) GetCalendarEvent(_ context.Context, _ *oauth2.Token, event *Event, _ string) (*Event, error) {
+	e, ok := r.events[event.GoogleID]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	c := *e
+	return &c, nil
+}
+
+func (r *fakeCalendarGoogleRepo) CreateCalendarEvent(_ context.Context, _ *oauth2.Token, event *Event, _ string) (*Event, error) {
+	r.next++
+	e := *event
+	e.GoogleID = fmt.Sprintf("created%d", r.next)
+	r.events[e.GoogleID] = &e
+	c := e
+	return &c, nil
+}
+
+func (r *fakeCalendarGoogleRepo) UpdateCalendarEvent(_ context.Context, _ *oauth2.Token, event *Event, _ string) (*Event, error) {
+	if _, ok := r.events[event.GoogleID]; !ok {
+		return nil, errors.New("not found")
+	}
+	e := *event
+	r.events[e.GoogleID] = &e
+	c := e
+	return &c, nil
+}
+
+func (r *fakeCalendarGoogleRepo) DeleteCalendarEvent(_ context.Context, _ *oauth2.Token, event *Event, _ string) error {
+	delete(r.events, event.GoogleID)
+	return nil
+}
+
+// series returns the sorted summaries and recurrences of the recurring events
+func (r *fakeCalendarGoogleRepo) series() []string {
+	var s []string
+	for _, e := range r.events {
+		if len(e.Recurrence) > 0 {
+			s = append(s, e.Summary+" "+strings.Join(e.Recurrence, " "))
+		}
+	}
+	sort.Strings(s)
+	return s
+}
+
+func TestUndoRecurringChanges(t *testing.T) {
+	user := &User{ID: uuid.New(), Email: "user@example.com"}
+	calendar := &Calendar{ID: uuid.New(), UserID: user.ID, GoogleID: user.Email}
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	series := &Event{CalendarID: calendar.ID, GoogleID: "standup", Summary: "Standup",
+		StartTime: start, EndTime: start.Add(15 * time.Minute), Recurrence: []string{"RRULE:FREQ=DAILY"}}
+	occurrenceStart := start.AddDate(0, 0, 7)
+	occurrence := &Event{CalendarID: calendar.ID, GoogleID: "standup_20240108", Summary: "Standup",
+		StartTime: occurrenceStart, EndTime: occurrenceStart.Add(15 * time.Minute),
+		RecurringEventID: series.GoogleID, OriginalStartTime: occurrenceStart}
+	tests := []struct {
+		name string
+		// change makes the assistant changes
+		change func(ctx context.Context, tools *calendarTools) error
+		// undo is the number of the changes to undo
+		undo int
+	}{
+		{"create", func(ctx context.Context, tools *calendarTools) error {
+			_, err := tools.createRecurringEvent(ctx, createRecurringEventArgs{GoogleCalendarID: "primary", Title: "Review",
+				StartTime: start.Add(time.Hour), EndTime: start.Add(2 * time.Hour), Frequency: "weekly"})
+			return err
+		}, 1},
+		{"update all", func(ctx context.Context, tools *calendarTools) error {
+			_, err := tools.updateRecurringEvent(ctx, updateRecurringEventArgs{GoogleCalendarID: "primary",
+				GoogleEventID: series.GoogleID, Scope: RECURRENCE_SCOPE_ALL, Title: "Daily"})
+			return err
+		}, 1},
+		{"update following", func(ctx context.Context, tools *calendarTools) error {
+			_, err := tools.updateRecurringEvent(ctx, updateRecurringEventArgs{GoogleCalendarID: "primary",
+				GoogleEventID: occurrence.GoogleID, Scope: RECURRENCE_SCOPE_FOLLOWING, Title: "Daily"})
+			return err
+		}, 2},
+		{"delete following", func(ctx context.Context, tools *calendarTools) error {
+			_, err := tools.deleteRecurringEvent(ctx, deleteRecurringEventArgs{GoogleCalendarID: "primary",
+				GoogleEventID: occurrence.GoogleID, Scope: RECURRENCE_SCOPE_FOLLOWING})
+			return err
+		}, 1},
+		{"delete all", func(ctx context.Context, tools *calendarTools) error {
+			_, err := tools.deleteRecurringEvent(ctx, deleteRecurringEventArgs{GoogleCalendarID: "primary",
+				GoogleEventID: series.GoogleID, Scope: RECURRENCE_SCOPE_ALL})
+			return err
+		}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gr := newFakeCalendarGoogleRepo(series, occurrence)
+			er := newFakeEventRepo(series)
+			cr := &fakeCalendarRepo{calendar: calendar}
+			tools := newCalendarTools(log.DefaultLogger, gr, cr, er)
+			ctx := SetToken(SetUser(context.Background(), user), &oauth2.Token{AccessToken: "token"})
+			before := gr.series()
+
+			if err := tt.change(SetChangeInitiator(ctx, ASSISTANT), tools); err != nil {
+				t.Fatalf("change: %v", err)
+			}
+			if len(er.changes) != tt.undo {
+				t.Fatalf("recorded %d changes, want %d", len(er.changes), tt.undo)
+			}
+			reverted, err := NewUndoUseCase(log.DefaultLogger, &fakeEventHistoryRepo{events: er}, er, cr, gr).UndoLastChanges(ctx, user, tt.undo)
+			if err != nil {
+				t.Fatalf("UndoLastChanges: %v", err)
+			}
+			if len(reverted) != tt.undo {
+				t.Errorf("reverted %v, want %d changes", reverted, tt.undo)
+			}
+			if got := gr.series(); !reflect.DeepEqual(got, before) {
+				t.Errorf("series after the undo = %v, want %v", got, before)
+			}
+		})
+	}
+}
+
+func TestWithRuleEnd(t *testing.T) {
+	until := time.Date(2024, 1, 7, 23, 59, 59, 0, time.FixedZone("UTC+2", 2*60*60))
+	tests := []struct {
+		name       string
+		recurrence []string
+		until      time.Time
+		allDay     bool
+		count      int
+		want       []string
+	}{
+		{"until", []string{"RRULE:FREQ=DAILY;COUNT=10"}, until, false, 0, []string{"RRULE:FREQ=DAILY;UNTIL=20240107T215959Z"}},
+		{"all-day until", []string{"RRULE:FREQ=DAILY;UNTIL=20240201"}, until, true, 0, []string{"RRULE:FREQ=DAILY;UNTIL=20240107"}},
+		{"count", []string{"EXDATE:20240103T090000Z", "RRULE:FREQ=WEEKLY;BYDAY=MO;UNTIL=20240201T000000Z"}, time.Time{}, false, 3,
+			[]string{"EXDATE:20240103T090000Z", "RRULE:FREQ=WEEKLY;BYDAY=MO;COUNT=3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := withRuleEnd(tt.recurrence, tt.until, tt.allDay, tt.count); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("withRuleEnd = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
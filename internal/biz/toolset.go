@@ -0,0 +1,164 @@
+package biz
+
+import (
+	"context"
+	"github.com/go-kratos/kratos/v2/log"
+	pb "github.com/kdimtricp/aical/api/chat/v1"
+	"github.com/kdimtricp/aical/internal/conf"
+	"github.com/kdimtricp/aical/pkg/openai"
+	"time"
+)
+
+// Tool is a function offered to the model
+type Tool struct {
+	Name        string
+	Description string
+	// ReadOnly tools do not change the calendars, they stay enabled in read-only mode
+	ReadOnly bool
+	// Confirm tools are staged in chat until the user confirms them
+	Confirm bool
+}
+
+// ToolState is a tool with its state for the user
+type ToolState struct {
+	*Tool
+	Enabled bool
+}
+
+// Toolset is the ordered set of calendar tools, it is built once at wiring time and shared by the use cases
+type Toolset struct {
+	registry  *openai.Registry
+	tools     []*Tool
+	par       PendingActionRepo
+	actionTTL time.Duration
+}
+
+// NewToolset registers the calendar tools, the registration order is the order the tools are offered to the model
+func NewToolset(cfg *conf.OpenAI, logger log.Logger, gr GoogleRepo, cr CalendarRepo, er EventRepo, par PendingActionRepo) *Toolset {
+	t := newCalendarTools(logger, gr, cr, er)
+	ts := &Toolset{
+		registry:  openai.NewRegistry(),
+		par:       par,
+		actionTTL: DEFAULT_PENDING_ACTION_TTL,
+	}
+	// a zero or negative TTL would expire the actions before the user can confirm them
+	if ttl := cfg.GetPendingActions().GetTtl().AsDuration(); ttl > 0 {
+		ts.actionTTL = ttl
+	}
+	addTool(ts, FUNCTION_CURRENT_TIME, "Returns current time in RFC3339 format", true, t.currentTime)
+	addTool(ts, FUNCTION_ADJUST_DATE, "Adjusts date by adding or subtracting days", true, t.adjustDate)
+	addTool(ts, FUNCTION_LIST_USER_CALENDARS, "Lists user calendars", true, t.listUserCalendars)
+	addTool(ts, FUNCTION_LIST_EVENTS, "Lists events in the google calendar", true, t.listEvents)
+	addTool(ts, FUNCTION_FIND_FREE_SLOTS, "Finds free time slots for an event of the given duration within the working hours, ranked best first", true, t.findFreeSlots)
+	addTool(ts, FUNCTION_CREATE_EVENT, "Creates an event in the calendar", false, t.createEvent)
+	addStagedTool(ts, FUNCTION_UPDATE_EVENT, "Updates an event in the calendar", t.checkUpdateEvent, t.updateEventPreview, t.updateEvent)
+	addStagedTool(ts, FUNCTION_DELETE_EVENT, "Deletes an event from the calendar", nil, t.deleteEventPreview, t.deleteEvent)
+	addTool(ts, FUNCTION_FIND_CONTACTS, "Finds the email addresses of the user contacts by name", true, t.findContacts)
+	addTool(ts, FUNCTION_RSVP_EVENT, "Accepts, declines or tentatively accepts an invitation to an event", false, t.rsvpEvent)
+	addTool(ts, FUNCTION_CREATE_RECURRING_EVENT, "Creates a recurring series of events in the calendar. Only the first occurrence is checked for conflicts, tell the user that later occurrences may overlap other events.", false, t.createRecurringEvent)
+	addStagedTool(ts, FUNCTION_UPDATE_RECURRING_EVENT, "Updates one occurrence, the following occurrences or all occurrences of a recurring event",
+		t.checkUpdateRecurringEvent, t.updateRecurringEventPreview, t.updateRecurringEvent)
+	addStagedTool(ts, FUNCTION_DELETE_RECURRING_EVENT, "Cancels one occurrence, the following occurrences or all occurrences of a recurring event",
+		t.checkDeleteRecurringEvent, t.deleteRecurringEventPreview, t.deleteRecurringEvent)
+	// the autopilot proposals are not offered in chat, the registry executes them when the user confirms them
+	openai.RegisterFunc(ts.registry, FUNCTION_PROPOSE_EVENT, "Proposes an event for the autopilot calendar, the user confirms it before it is created",
+		staged(ts, FUNCTION_PROPOSE_EVENT, nil, t.proposeEventPreview, t.proposeEvent))
+	return ts
+}
+
+// addTool registers the typed tool handler
+func addTool[Args any, Result any](ts *Toolset, name string, description string, readOnly bool, handler func(ctx context.Context, args Args) (Result, error)) {
+	openai.RegisterFunc(ts.registry, name, description, handler)
+	ts.tools = append(ts.tools, &Tool{
+		Name:        name,
+		Description: description,
+		ReadOnly:    readOnly,
+	})
+}
+
+// addStagedTool registers the handler of a destructive tool, in chat its calls wait for the user confirmation
+func addStagedTool[Args any, Result any](
+	ts *Toolset,
+	name string,
+	description string,
+	check func(ctx context.Context, args Args) error,
+	preview func(ctx context.Context, args Args) string,
+	handler func(ctx context.Context, args Args) (Result, error),
+) {
+	addTool(ts, name, description, false, staged(ts, name, check, preview, handler))
+	ts.tools[len(ts.tools)-1].Confirm = true
+}
+
+// enabled reports if the tool is enabled for the user
+func (ts *Toolset) enabled(user *User, tool *Tool) bool {
+	if user.ReadOnly && !tool.ReadOnly {
+		return false
+	}
+	for _, name := range user.DisabledTools {
+		if name == tool.Name {
+			return false
+		}
+	}
+	return true
+}
+
+// allowed reports if the user settings allow calling the named tool now,
+// the tools hidden from chat change the calendars and follow the same settings
+func (ts *Toolset) allowed(user *User, name string) bool {
+	for _, tool := range ts.tools {
+		if tool.Name == name {
+			return ts.enabled(user, tool)
+		}
+	}
+	return ts.enabled(user, &Tool{Name: name})
+}
+
+// ForUser returns the registry of the tools enabled for the user
+func (ts *Toolset) ForUser(user *User) *openai.Registry {
+	names := make([]string, 0, len(ts.tools))
+	for _, tool := range ts.tools {
+		if ts.enabled(user, tool) {
+			names = append(names, tool.Name)
+		}
+	}
+	return ts.registry.Subset(names...)
+}
+
+// Subset returns the registry of the named tools
+func (ts *Toolset) Subset(names ...string) *openai.Registry {
+	return ts.registry.Subset(names...)
+}
+
+// States returns all tools with their state for the user
+func (ts *Toolset) States(user *User) []*ToolState {
+	states := make([]*ToolState, len(ts.tools))
+	for i, tool := range ts.tools {
+		states[i] = &ToolState{
+			Tool:    tool,
+			Enabled: ts.enabled(user, tool),
+		}
+	}
+	return states
+}
+
+// execute calls the tool with the JSON encoded arguments and returns the JSON encoded result
+func (ts *Toolset) execute(ctx context.Context, name string, arguments string) string {
+	return ts.registry.Execute(ctx, name, arguments)
+}
+
+// Validate returns an error if one of the names is not a tool of the set
+func (ts *Toolset) Validate(names []string) error {
+	for _, name := range names {
+		known := false
+		for _, tool := range ts.tools {
+			if tool.Name == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return pb.ErrorUnknownTool("unknown tool %q", name)
+		}
+	}
+	return nil
+}
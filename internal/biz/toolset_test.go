@@ -0,0 +1,56 @@
+package biz
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/kdimtricp/aical/internal/conf"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestToolsetAllowed(t *testing.T) {
+	ts := NewToolset(&conf.OpenAI{}, log.DefaultLogger, nil, nil, nil, nil)
+	tests := []struct {
+		name string
+		user *User
+		tool string
+		want bool
+	}{
+		{"staged tool", &User{}, FUNCTION_DELETE_EVENT, true},
+		{"read-only mode", &User{ReadOnly: true}, FUNCTION_DELETE_EVENT, false},
+		{"read-only tool in read-only mode", &User{ReadOnly: true}, FUNCTION_LIST_EVENTS, true},
+		{"disabled tool", &User{DisabledTools: []string{FUNCTION_UPDATE_EVENT}}, FUNCTION_UPDATE_EVENT, false},
+		{"other tool disabled", &User{DisabledTools: []string{FUNCTION_UPDATE_EVENT}}, FUNCTION_DELETE_EVENT, true},
+		{"proposal", &User{}, FUNCTION_PROPOSE_EVENT, true},
+		{"proposal in read-only mode", &User{ReadOnly: true}, FUNCTION_PROPOSE_EVENT, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ts.allowed(tt.user, tt.tool); got != tt.want {
+				t.Errorf("allowed(%s) = %t, want %t", tt.tool, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToolsetActionTTL(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *conf.OpenAI
+		want time.Duration
+	}{
+		{"no configuration", &conf.OpenAI{}, DEFAULT_PENDING_ACTION_TTL},
+		{"no TTL", &conf.OpenAI{PendingActions: &conf.OpenAI_PendingActions{}}, DEFAULT_PENDING_ACTION_TTL},
+		{"zero TTL", &conf.OpenAI{PendingActions: &conf.OpenAI_PendingActions{Ttl: durationpb.New(0)}}, DEFAULT_PENDING_ACTION_TTL},
+		{"negative TTL", &conf.OpenAI{PendingActions: &conf.OpenAI_PendingActions{Ttl: durationpb.New(-time.Minute)}}, DEFAULT_PENDING_ACTION_TTL},
+		{"TTL", &conf.OpenAI{PendingActions: &conf.OpenAI_PendingActions{Ttl: durationpb.New(time.Hour)}}, time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewToolset(tt.cfg, log.DefaultLogger, nil, nil, nil, nil).actionTTL; got != tt.want {
+				t.Errorf("action TTL = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
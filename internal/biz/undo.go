@@ -0,0 +1,143 @@
+package biz
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/google/uuid"
+	pb "github.com/kdimtricp/aical/api/chat/v1"
+	"time"
+)
+
+//goland:noinspection GoSnakeCaseUsage
+const (
+	MAX_UNDO_COUNT = 10
+)
+
+// UndoUseCase reverts the calendar changes made by the assistant, the reverts are recorded in the event history too
+type UndoUseCase struct {
+	log *log.Helper
+	ehr EventHistoryRepo
+	er  EventRepo
+	cr  CalendarRepo
+	gr  GoogleRepo
+}
+
+// NewUndoUseCase .
+func NewUndoUseCase(logger log.Logger, ehr EventHistoryRepo, er EventRepo, cr CalendarRepo, gr GoogleRepo) *UndoUseCase {
+	return &UndoUseCase{
+		log: log.NewHelper(logger),
+		ehr: ehr,
+		er:  er,
+		cr:  cr,
+		gr:  gr,
+	}
+}
+
+// UndoLastChanges reverts the n most recent assistant changes of the user, newest first,
+// and returns the descriptions of the reverted changes
+func (uc *UndoUseCase) UndoLastChanges(ctx context.Context, user *User, n int) ([]string, error) {
+	uc.log.Debugf("undo %d changes for user %s", n, user.ID)
+	if n < 1 {
+		n = 1
+	}
+	if n > MAX_UNDO_COUNT {
+		n = MAX_UNDO_COUNT
+	}
+	token := GetToken(ctx)
+	if token == nil {
+		return nil, errTokenNotFound
+	}
+	changes, err := uc.ehr.ListUndoable(ctx, user.ID, n)
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 {
+		return nil, pb.ErrorNothingToUndo("There are no changes to undo.")
+	}
+	ctx = SetChangeInitiator(ctx, UNDO)
+	reverted := make([]string, 0, len(changes))
+	for _, change := range changes {
+		description, err := uc.revert(ctx, change)
+		if err != nil {
+			uc.log.Errorf("undo change %s for user %s: %v", change.ID, user.ID, err)
+			if len(reverted) == 0 {
+				return nil, pb.ErrorUndoFailed("The change could not be undone: %v", err)
+			}
+			// report the changes reverted before the failure
+			break
+		}
+		if err := uc.ehr.MarkReverted(ctx, change.ID); err != nil {
+			return nil, err
+		}
+		reverted = append(reverted, description)
+	}
+	return reverted, nil
+}
+
+// revert applies the opposite of the change to Google and the stored events
+func (uc *UndoUseCase) revert(ctx context.Context, change *EventHistory) (string, error) {
+	c, err := uc.cr.Get(ctx, &Calendar{ID: change.CalendarID})
+	if err != nil {
+		return "", err
+	}
+	token := GetToken(ctx)
+	switch change.ChangeType {
+	case CREATED:
+		event := &Event{GoogleID: change.NewEvent.GoogleID}
+		if err := uc.gr.DeleteCalendarEvent(ctx, token, event, c.GoogleID); err != nil {
+			return "", err
+		}
+		if stored, err := uc.er.Get(ctx, &Event{CalendarID: c.ID, GoogleID: event.GoogleID}); err == nil {
+			if err := uc.er.Delete(ctx, stored); err != nil {
+				return "", err
+			}
+		}
+		return fmt.Sprintf("Removed created event %q", change.NewEvent.Summary), nil
+	case UPDATED:
+		prev := change.PrevEvent
+		current, err := uc.gr.GetCalendarEvent(ctx, token, &Event{GoogleID: prev.GoogleID}, c.GoogleID)
+		if err != nil {
+			return "", err
+		}
+		current.Summary = prev.Summary
+		current.Location = prev.Location
+		current.StartTime = prev.StartTime
+		current.EndTime = prev.EndTime
+		current.Description = prev.Description
+		current.Attendees = prev.Attendees
+		current.Recurrence = prev.Recurrence
+		if _, err := uc.gr.UpdateCalendarEvent(ctx, token, current, c.GoogleID); err != nil {
+			return "", err
+		}
+		prev.ID = change.EventID
+		prev.CalendarID = c.ID
+		if _, err := uc.er.Update(ctx, &prev); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Restored event %q", prev.Summary), nil
+	case DELETED:
+		prev := change.PrevEvent
+		// the event is recreated as it was with the recurrence, the time zone and the call link,
+		// only the IDs are new, a deleted occurrence comes back as a single event
+		recreated := prev
+		recreated.ID = uuid.Nil
+		recreated.CalendarID = uuid.Nil
+		recreated.GoogleID = ""
+		recreated.RecurringEventID = ""
+		recreated.OriginalStartTime = time.Time{}
+		e, err := uc.gr.CreateCalendarEvent(ctx, token, &recreated, c.GoogleID)
+		if err != nil {
+			return "", err
+		}
+		// the deleted event keeps its row, the recreated event is stored as a new one
+		e.ID = uuid.Nil
+		e.CalendarID = c.ID
+		if _, err := uc.er.Create(ctx, e); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Recreated deleted event %q", prev.Summary), nil
+	default:
+		return "", fmt.Errorf("unknown change type: %s", change.ChangeType)
+	}
+}
@@ -0,0 +1,167 @@
+package biz
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/google/uuid"
+	pb "github.com/kdimtricp/aical/api/chat/v1"
+	"github.com/kdimtricp/aical/internal/conf"
+	"github.com/kdimtricp/aical/pkg/openai"
+	"strings"
+	"time"
+)
+
+//goland:noinspection GoSnakeCaseUsage
+const (
+	USAGE_KIND_CHAT = "chat"
+	USAGE_KIND_CRON = "cron"
+)
+
+// Usage is the token usage of one model request made for the user
+type Usage struct {
+	ID               uuid.UUID
+	UserID           uuid.UUID
+	Kind             string
+	Model            string
+	PromptTokens     int64
+	CompletionTokens int64
+	TotalTokens      int64
+	CreatedAt        time.Time
+}
+
+// UsageSummary is the token usage summed by request kind and model
+type UsageSummary struct {
+	Kind             string
+	Model            string
+	PromptTokens     int64
+	CompletionTokens int64
+	TotalTokens      int64
+}
+
+// UsageReport is the token usage of the user for the current day and month
+type UsageReport struct {
+	DailyTokens   int64
+	MonthlyTokens int64
+	DailyQuota    int64
+	MonthlyQuota  int64
+	// Summaries is the usage of the current month by request kind and model
+	Summaries []*UsageSummary
+}
+
+// String is the string representation of the UsageReport struct.
+func (r *UsageReport) String() string {
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "Today: %s tokens\n", quotaString(r.DailyTokens, r.DailyQuota))
+	_, _ = fmt.Fprintf(&b, "This month: %s tokens", quotaString(r.MonthlyTokens, r.MonthlyQuota))
+	for _, s := range r.Summaries {
+		_, _ = fmt.Fprintf(&b, "\n%s, %s: %d prompt + %d completion", s.Kind, s.Model, s.PromptTokens, s.CompletionTokens)
+	}
+	return b.String()
+}
+
+func quotaString(used int64, quota int64) string {
+	if quota <= 0 {
+		return fmt.Sprintf("%d", used)
+	}
+	return fmt.Sprintf("%d of %d", used, quota)
+}
+
+type UsageRepo interface {
+	Create(ctx context.Context, usage *Usage) error
+	Total(ctx context.Context, userID uuid.UUID, since time.Time) (int64, error)
+	Summary(ctx context.Context, userID uuid.UUID, since time.Time) ([]*UsageSummary, error)
+}
+
+type UsageUseCase struct {
+	db           UsageRepo
+	log          *log.Helper
+	dailyQuota   int64
+	monthlyQuota int64
+}
+
+func NewUsageUseCase(cfg *conf.OpenAI, repo UsageRepo, logger log.Logger) *UsageUseCase {
+	return &UsageUseCase{
+		db:           repo,
+		log:          log.NewHelper(logger),
+		dailyQuota:   cfg.GetQuota().GetDailyTokens(),
+		monthlyQuota: cfg.GetQuota().GetMonthlyTokens(),
+	}
+}
+
+// usagePeriods returns the start of the current day and month
+func usagePeriods(now time.Time) (time.Time, time.Time) {
+	year, month, day := now.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, now.Location()),
+		time.Date(year, month, 1, 0, 0, 0, 0, now.Location())
+}
+
+// Record saves the token usage of the agent run, runs without usage are skipped
+func (uc *UsageUseCase) Record(ctx context.Context, userID uuid.UUID, kind string, result *openai.RunResult) {
+	if result == nil || result.Usage.TotalTokens == 0 {
+		return
+	}
+	uc.log.Debugf("record %s usage for user %s: %d tokens", kind, userID, result.Usage.TotalTokens)
+	err := uc.db.Create(ctx, &Usage{
+		UserID:           userID,
+		Kind:             kind,
+		Model:            result.Model,
+		PromptTokens:     int64(result.Usage.PromptTokens),
+		CompletionTokens: int64(result.Usage.CompletionTokens),
+		TotalTokens:      int64(result.Usage.TotalTokens),
+	})
+	if err != nil {
+		uc.log.Errorf("record usage for user %s: %v", userID, err)
+	}
+}
+
+// CheckQuota returns an error if the user has spent the daily or monthly token quota
+func (uc *UsageUseCase) CheckQuota(ctx context.Context, userID uuid.UUID) error {
+	if uc.dailyQuota <= 0 && uc.monthlyQuota <= 0 {
+		return nil
+	}
+	dayStart, monthStart := usagePeriods(time.Now())
+	if uc.dailyQuota > 0 {
+		total, err := uc.db.Total(ctx, userID, dayStart)
+		if err != nil {
+			return err
+		}
+		if total >= uc.dailyQuota {
+			return pb.ErrorUsageQuotaExceeded("You have used the daily limit of %d tokens, please come back tomorrow.", uc.dailyQuota)
+		}
+	}
+	if uc.monthlyQuota > 0 {
+		total, err := uc.db.Total(ctx, userID, monthStart)
+		if err != nil {
+			return err
+		}
+		if total >= uc.monthlyQuota {
+			return pb.ErrorUsageQuotaExceeded("You have used the monthly limit of %d tokens.", uc.monthlyQuota)
+		}
+	}
+	return nil
+}
+
+// Report returns the token usage of the user for the current day and month
+func (uc *UsageUseCase) Report(ctx context.Context, userID uuid.UUID) (*UsageReport, error) {
+	uc.log.Debugf("usage report for user %s", userID)
+	dayStart, monthStart := usagePeriods(time.Now())
+	daily, err := uc.db.Total(ctx, userID, dayStart)
+	if err != nil {
+		return nil, err
+	}
+	summaries, err := uc.db.Summary(ctx, userID, monthStart)
+	if err != nil {
+		return nil, err
+	}
+	report := &UsageReport{
+		DailyTokens:  daily,
+		DailyQuota:   uc.dailyQuota,
+		MonthlyQuota: uc.monthlyQuota,
+		Summaries:    summaries,
+	}
+	for _, s := range summaries {
+		report.MonthlyTokens += s.TotalTokens
+	}
+	return report, nil
+}
@@ -4,8 +4,15 @@ import (
 	"context"
 	"github.com/go-kratos/kratos/v2/log"
 	"github.com/google/uuid"
+	pb "github.com/kdimtricp/aical/api/chat/v1"
+	"strconv"
+	"strings"
+	"time"
 )
 
+//goland:noinspection GoSnakeCaseUsage
+const QUIET_HOURS_OFF = "off"
+
 type User struct {
 	ID           uuid.UUID `json:"id"`
 	GoogleID     string    `json:"google_id"`
@@ -13,12 +20,80 @@ type User struct {
 	Name         string    `json:"name"`
 	Email        string    `json:"email"`
 	RefreshToken string    `json:"refresh_token"`
+	// ReadOnly disables the tools changing the calendars
+	ReadOnly      bool     `json:"read_only"`
+	DisabledTools []string `json:"disabled_tools"`
+	// Timezone is the IANA time zone of the user, e.g. Asia/Tokyo
+	Timezone string `json:"timezone"`
+	// ConflictPolicy is block, warn or allow, see ConflictPolicy
+	ConflictPolicy string `json:"conflict_policy"`
+	// Autopilot lets the assistant propose events after the calendar changes of the user
+	Autopilot bool `json:"autopilot"`
+	// AutopilotCalendarID is the Google ID of the calendar the confirmed proposals are created in
+	AutopilotCalendarID string `json:"autopilot_calendar_id"`
+	// AutopilotPlannedAt is the time of the last planning, the next one looks at the changes made after it
+	AutopilotPlannedAt time.Time `json:"autopilot_planned_at"`
+	// ChangesMuted stops the notifications about the changes others make to the events of the user
+	ChangesMuted bool `json:"changes_muted"`
+	// MutedCalendars are the Google IDs of the calendars left out of the change notifications
+	MutedCalendars []string `json:"muted_calendars"`
+	// QuietHoursStart and QuietHoursEnd are the hours of the day the change notifications wait,
+	// the hours are in the user time zone and equal hours mean no quiet hours
+	QuietHoursStart int `json:"quiet_hours_start"`
+	QuietHoursEnd   int `json:"quiet_hours_end"`
+}
+
+// Location returns the time zone of the user, the server time zone if the user has none
+func (u *User) Location() *time.Location {
+	if u.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(u.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// InQuietHours reports if the time is within the quiet hours of the user
+func (u *User) InQuietHours(t time.Time) bool {
+	if u.QuietHoursStart == u.QuietHoursEnd {
+		return false
+	}
+	h := t.In(u.Location()).Hour()
+	if u.QuietHoursStart < u.QuietHoursEnd {
+		return h >= u.QuietHoursStart && h < u.QuietHoursEnd
+	}
+	// the quiet hours span midnight
+	return h >= u.QuietHoursStart || h < u.QuietHoursEnd
+}
+
+// CalendarMuted reports if the calendar with the Google ID is left out of the change notifications
+func (u *User) CalendarMuted(googleID string) bool {
+	for _, id := range u.MutedCalendars {
+		if id == googleID {
+			return true
+		}
+	}
+	return false
+}
+
+// userLocation returns the time zone of the user in the context
+func userLocation(ctx context.Context) *time.Location {
+	if user := GetUser(ctx); user != nil {
+		return user.Location()
+	}
+	return time.Local
 }
 
 type UserRepo interface {
 	Create(ctx context.Context, user *User) error
 	Get(ctx context.Context, user *User) (*User, error)
 	List(ctx context.Context) ([]*User, error)
+	Update(ctx context.Context, user *User) error
+	// SetAutopilotState stores only the autopilot calendar and planning time of the user,
+	// so a planning run does not overwrite the settings changed meanwhile
+	SetAutopilotState(ctx context.Context, id uuid.UUID, calendarID string, plannedAt time.Time) error
 }
 
 type UserUseCase struct {
@@ -45,6 +120,68 @@ func (uc *UserUseCase) Get(ctx context.Context, user *User) (*User, error) {
 	return uc.db.Get(ctx, user)
 }
 
+// Update updates user in database
+func (uc *UserUseCase) Update(ctx context.Context, user *User) error {
+	uc.log.Debugf("update user: %v", user.ID)
+	return uc.db.Update(ctx, user)
+}
+
+// SetTimezone validates and stores the time zone of the user
+func (uc *UserUseCase) SetTimezone(ctx context.Context, user *User, timezone string) error {
+	uc.log.Debugf("set timezone for user %s: %s", user.ID, timezone)
+	loc, err := time.LoadLocation(timezone)
+	if err != nil || timezone == "" {
+		return pb.ErrorInvalidTimezone("unknown timezone %q, use an IANA name like Europe/Berlin", timezone)
+	}
+	user.Timezone = loc.String()
+	return uc.db.Update(ctx, user)
+}
+
+// SetConflictPolicy validates and stores the conflict policy of the user
+func (uc *UserUseCase) SetConflictPolicy(ctx context.Context, user *User, name string) error {
+	uc.log.Debugf("set conflict policy for user %s: %s", user.ID, name)
+	policy, ok := ParseConflictPolicy(name)
+	if !ok {
+		return errInvalidConflictPolicy(name)
+	}
+	user.ConflictPolicy = string(policy)
+	return uc.db.Update(ctx, user)
+}
+
+// SetAutopilot turns the autopilot of the user on or off, a turned on autopilot starts with the recent changes
+func (uc *UserUseCase) SetAutopilot(ctx context.Context, user *User, enabled bool) error {
+	uc.log.Debugf("set autopilot for user %s: %t", user.ID, enabled)
+	if enabled && !user.Autopilot {
+		user.AutopilotPlannedAt = time.Time{}
+	}
+	user.Autopilot = enabled
+	return uc.db.Update(ctx, user)
+}
+
+// SetChangeNotifications turns the notifications about the changes of the events on or off
+func (uc *UserUseCase) SetChangeNotifications(ctx context.Context, user *User, enabled bool) error {
+	uc.log.Debugf("set change notifications for user %s: %t", user.ID, enabled)
+	user.ChangesMuted = !enabled
+	return uc.db.Update(ctx, user)
+}
+
+// SetQuietHours validates and stores the quiet hours of the user given as start-end hours, e.g. 22-7, or off
+func (uc *UserUseCase) SetQuietHours(ctx context.Context, user *User, spec string) error {
+	uc.log.Debugf("set quiet hours for user %s: %s", user.ID, spec)
+	if spec == QUIET_HOURS_OFF {
+		user.QuietHoursStart, user.QuietHoursEnd = 0, 0
+		return uc.db.Update(ctx, user)
+	}
+	start, end, ok := strings.Cut(spec, "-")
+	startHour, startErr := strconv.Atoi(strings.TrimSpace(start))
+	endHour, endErr := strconv.Atoi(strings.TrimSpace(end))
+	if !ok || startErr != nil || endErr != nil || startHour < 0 || startHour > 23 || endHour < 0 || endHour > 23 {
+		return pb.ErrorInvalidQuietHours("invalid quiet hours %q, use the start and end hours like 22-7, or %s", spec, QUIET_HOURS_OFF)
+	}
+	user.QuietHoursStart, user.QuietHoursEnd = startHour, endHour
+	return uc.db.Update(ctx, user)
+}
+
 func (uc *UserUseCase) List(ctx context.Context) ([]*User, error) {
 	uc.log.Debugf("list users")
 	return uc.db.List(ctx)
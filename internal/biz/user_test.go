@@ -0,0 +1,39 @@
+package biz
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUserInQuietHours(t *testing.T) {
+	tests := []struct {
+		name     string
+		timezone string
+		start    int
+		end      int
+		hour     int
+		want     bool
+	}{
+		{"off", "UTC", 0, 0, 3, false},
+		{"inside", "UTC", 13, 15, 14, true},
+		{"at the start", "UTC", 13, 15, 13, true},
+		{"at the end", "UTC", 13, 15, 15, false},
+		{"before", "UTC", 13, 15, 12, false},
+		{"over midnight late", "UTC", 22, 7, 23, true},
+		{"over midnight early", "UTC", 22, 7, 6, true},
+		{"over midnight day", "UTC", 22, 7, 12, false},
+		{"over midnight at the end", "UTC", 22, 7, 7, false},
+		// 20:00 UTC is 23:00 and 05:00 UTC is 08:00 in Moscow
+		{"user time zone", "Europe/Moscow", 22, 7, 20, true},
+		{"user time zone day", "Europe/Moscow", 22, 7, 5, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &User{Timezone: tt.timezone, QuietHoursStart: tt.start, QuietHoursEnd: tt.end}
+			now := time.Date(2024, 1, 2, tt.hour, 30, 0, 0, time.UTC)
+			if got := user.InQuietHours(now); got != tt.want {
+				t.Errorf("InQuietHours(%s) = %t, want %t", now.Format("15:04"), got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package biz
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/google/uuid"
+	"time"
+)
+
+//goland:noinspection GoSnakeCaseUsage
+const (
+	// WATCH_STATE_SYNC is sent once when the channel is created, there is nothing to sync yet
+	WATCH_STATE_SYNC = "sync"
+	// WATCH_STATE_EXISTS is sent when the events of the calendar change
+	WATCH_STATE_EXISTS = "exists"
+	// WATCH_STATE_NOT_EXISTS is sent when the watched resource is deleted
+	WATCH_STATE_NOT_EXISTS = "not_exists"
+
+	WATCH_TOKEN_BYTES = 32
+)
+
+// ErrInvalidWatchChannel is returned for notifications of unknown channels or with a wrong token
+var ErrInvalidWatchChannel = errors.New("invalid watch channel")
+
+// WatchChannel is a Google push notification channel of the events of a calendar
+type WatchChannel struct {
+	ID         uuid.UUID
+	CalendarID uuid.UUID
+	// ResourceID is the Google ID of the watched resource, it is needed to stop the channel
+	ResourceID string
+	// Token is sent back by Google with every notification to prove the notification is genuine
+	Token      string
+	Expiration time.Time
+}
+
+type WatchChannelRepo interface {
+	Create(ctx context.Context, channel *WatchChannel) error
+	Get(ctx context.Context, id uuid.UUID) (*WatchChannel, error)
+	Delete(ctx context.Context, id uuid.UUID) error
+	ListCalendarChannels(ctx context.Context, calendarID uuid.UUID) ([]*WatchChannel, error)
+}
+
+type WatchUseCase struct {
+	db  WatchChannelRepo
+	gr  GoogleRepo
+	log *log.Helper
+}
+
+func NewWatchUseCase(repo WatchChannelRepo, gr GoogleRepo, logger log.Logger) *WatchUseCase {
+	return &WatchUseCase{
+		db:  repo,
+		gr:  gr,
+		log: log.NewHelper(log.With(logger, "caller", "biz.watch.usecase")),
+	}
+}
+
+func newWatchToken() (string, error) {
+	b := make([]byte, WATCH_TOKEN_BYTES)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Watch registers a new channel for the events of the calendar, the notifications are sent to the address
+func (uc *WatchUseCase) Watch(ctx context.Context, calendar *Calendar, address string, ttl time.Duration) (*WatchChannel, error) {
+	uc.log.Debugf("watch use case: watch calendar %s", calendar.ID)
+	token := GetToken(ctx)
+	if token == nil {
+		return nil, errTokenNotFound
+	}
+	channelToken, err := newWatchToken()
+	if err != nil {
+		return nil, err
+	}
+	channel, err := uc.gr.WatchCalendarEvents(ctx, token, calendar.GoogleID, &WatchChannel{
+		ID:         uuid.New(),
+		CalendarID: calendar.ID,
+		Token:      channelToken,
+		Expiration: time.Now().Add(ttl),
+	}, address)
+	if err != nil {
+		return nil, err
+	}
+	if err := uc.db.Create(ctx, channel); err != nil {
+		// the channel would notify with a token nobody knows, stop it right away
+		if err := uc.gr.StopWatchChannel(ctx, token, channel); err != nil {
+			uc.log.Errorf("watch use case: stop unsaved channel %s: %v", channel.ID, err)
+		}
+		return nil, err
+	}
+	uc.log.Debugf("watch use case: channel %s of calendar %s expires at %s", channel.ID, calendar.ID, channel.Expiration)
+	return channel, nil
+}
+
+// Stop stops the channel in Google and forgets it
+func (uc *WatchUseCase) Stop(ctx context.Context, channel *WatchChannel) error {
+	uc.log.Debugf("watch use case: stop channel %s", channel.ID)
+	token := GetToken(ctx)
+	if token == nil {
+		return errTokenNotFound
+	}
+	// an expired channel is already gone in Google
+	if channel.Expiration.After(time.Now()) {
+		if err := uc.gr.StopWatchChannel(ctx, token, channel); err != nil {
+			return err
+		}
+	}
+	return uc.db.Delete(ctx, channel.ID)
+}
+
+// StopCalendar stops all the channels of the calendar
+func (uc *WatchUseCase) StopCalendar(ctx context.Context, calendar *Calendar) error {
+	channels, err := uc.db.ListCalendarChannels(ctx, calendar.ID)
+	if err != nil {
+		return err
+	}
+	for _, channel := range channels {
+		if err := uc.Stop(ctx, channel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Renew makes sure the calendar has a channel living longer than renewBefore.
+// The new channel is registered before the old ones are stopped, so no notification is lost in between.
+func (uc *WatchUseCase) Renew(ctx context.Context, calendar *Calendar, address string, ttl, renewBefore time.Duration) error {
+	channels, err := uc.db.ListCalendarChannels(ctx, calendar.ID)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(renewBefore)
+	var expiring []*WatchChannel
+	for _, channel := range channels {
+		if channel.Expiration.After(deadline) {
+			return nil
+		}
+		expiring = append(expiring, channel)
+	}
+	if _, err := uc.Watch(ctx, calendar, address, ttl); err != nil {
+		return err
+	}
+	for _, channel := range expiring {
+		if err := uc.Stop(ctx, channel); err != nil {
+			uc.log.Errorf("watch use case: stop expiring channel %s: %v", channel.ID, err)
+		}
+	}
+	return nil
+}
+
+// Verify returns the channel of the notification when the token matches
+func (uc *WatchUseCase) Verify(ctx context.Context, channelID string, channelToken string) (*WatchChannel, error) {
+	id, err := uuid.Parse(channelID)
+	if err != nil {
+		return nil, ErrInvalidWatchChannel
+	}
+	channel, err := uc.db.Get(ctx, id)
+	if err != nil {
+		return nil, ErrInvalidWatchChannel
+	}
+	if subtle.ConstantTimeCompare([]byte(channel.Token), []byte(channelToken)) != 1 {
+		return nil, ErrInvalidWatchChannel
+	}
+	return channel, nil
+}
@@ -0,0 +1,152 @@
+package biz
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+)
+
+// fakeWatchChannelRepo keeps the watch channels in memory
+type fakeWatchChannelRepo struct {
+	channels map[uuid.UUID]*WatchChannel
+}
+
+func newFakeWatchChannelRepo(channels ...*WatchChannel) *fakeWatchChannelRepo {
+	r := &fakeWatchChannelRepo{channels: make(map[uuid.UUID]*WatchChannel)}
+	for _, c := range channels {
+		r.channels[c.ID] = c
+	}
+	return r
+}
+
+func (r *fakeWatchChannelRepo) Create(_ context.Context, channel *WatchChannel) error {
+	r.channels[channel.ID] = channel
+	return nil
+}
+
+func (r *fakeWatchChannelRepo) Get(_ context.Context, id uuid.UUID) (*WatchChannel, error) {
+	c, ok := r.channels[id]
+	if !ok {
+		return nil, errors.New("record not found")
+	}
+	return c, nil
+}
+
+func (r *fakeWatchChannelRepo) Delete(_ context.Context, id uuid.UUID) error {
+	delete(r.channels, id)
+	return nil
+}
+
+func (r *fakeWatchChannelRepo) ListCalendarChannels(_ context.Context, calendarID uuid.UUID) ([]*WatchChannel, error) {
+	var channels []*WatchChannel
+	for _, c := range r.channels {
+		if c.CalendarID == calendarID {
+			channels = append(channels, c)
+		}
+	}
+	return channels, nil
+}
+
+// fakeWatchGoogleRepo implements the watch calls of the GoogleRepo, the other calls panic
+type fakeWatchGoogleRepo struct {
+	GoogleRepo
+	watched []uuid.UUID
+	stopped []uuid.UUID
+}
+
+func (r *fakeWatchGoogleRepo) WatchCalendarEvents(_ context.Context, _ *oauth2.Token, _ string, channel *WatchChannel, _ string) (*WatchChannel, error) {
+	r.watched = append(r.watched, channel.ID)
+	c := *channel
+	c.ResourceID = "resource"
+	return &c, nil
+}
+
+func (r *fakeWatchGoogleRepo) StopWatchChannel(_ context.Context, _ *oauth2.Token, channel *WatchChannel) error {
+	r.stopped = append(r.stopped, channel.ID)
+	return nil
+}
+
+func TestWatchUseCaseVerify(t *testing.T) {
+	channel := &WatchChannel{ID: uuid.New(), CalendarID: uuid.New(), Token: "secret"}
+	uc := NewWatchUseCase(newFakeWatchChannelRepo(channel), &fakeWatchGoogleRepo{}, log.DefaultLogger)
+	tests := []struct {
+		name      string
+		channelID string
+		token     string
+		err       error
+	}{
+		{"valid", channel.ID.String(), "secret", nil},
+		{"wrong token", channel.ID.String(), "guess", ErrInvalidWatchChannel},
+		{"no token", channel.ID.String(), "", ErrInvalidWatchChannel},
+		{"unknown channel", uuid.NewString(), "secret", ErrInvalidWatchChannel},
+		{"invalid channel ID", "stub", "secret", ErrInvalidWatchChannel},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := uc.Verify(context.Background(), tt.channelID, tt.token)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+			if err == nil && got.ID != channel.ID {
+				t.Errorf("channel = %s, want %s", got.ID, channel.ID)
+			}
+		})
+	}
+}
+
+func TestWatchUseCaseRenew(t *testing.T) {
+	const (
+		ttl         = 7 * 24 * time.Hour
+		renewBefore = 24 * time.Hour
+	)
+	calendar := &Calendar{ID: uuid.New(), GoogleID: "primary"}
+	tests := []struct {
+		name string
+		// expirations of the existing channels from now
+		expirations []time.Duration
+		watch       bool
+		stopped     int
+	}{
+		{"no channel", nil, true, 0},
+		{"living channel", []time.Duration{3 * 24 * time.Hour}, false, 0},
+		{"expiring channel", []time.Duration{time.Hour}, true, 1},
+		{"expired channel", []time.Duration{-time.Hour}, true, 0},
+		{"expiring and living channels", []time.Duration{time.Hour, 3 * 24 * time.Hour}, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var channels []*WatchChannel
+			for _, e := range tt.expirations {
+				channels = append(channels, &WatchChannel{ID: uuid.New(), CalendarID: calendar.ID, Expiration: time.Now().Add(e)})
+			}
+			repo := newFakeWatchChannelRepo(channels...)
+			gr := &fakeWatchGoogleRepo{}
+			uc := NewWatchUseCase(repo, gr, log.DefaultLogger)
+			ctx := SetToken(context.Background(), &oauth2.Token{AccessToken: "token"})
+			if err := uc.Renew(ctx, calendar, "https://example.com/webhook", ttl, renewBefore); err != nil {
+				t.Fatalf("Renew: %v", err)
+			}
+			if watched := len(gr.watched) == 1; watched != tt.watch {
+				t.Errorf("registered %d channels, want a new channel: %t", len(gr.watched), tt.watch)
+			}
+			if len(gr.stopped) != tt.stopped {
+				t.Errorf("stopped %d channels in Google, want %d", len(gr.stopped), tt.stopped)
+			}
+			left, _ := repo.ListCalendarChannels(ctx, calendar.ID)
+			for _, c := range left {
+				// a living channel leaves the expiring ones to expire by themselves
+				if tt.watch && !c.Expiration.After(time.Now().Add(renewBefore)) {
+					t.Errorf("expiring channel %s is kept", c.ID)
+				}
+			}
+			if len(left) == 0 {
+				t.Error("the calendar has no channel after the renewal")
+			}
+		})
+	}
+}
@@ -14,6 +14,7 @@ type calendar struct {
 	UserID        uuid.UUID
 	GoogleID      string
 	Summary       string
+	SyncToken     string
 	Events        []*Event
 	EventsHistory []*eventHistory
 }
@@ -24,6 +25,8 @@ func (c *calendar) biz() *biz.Calendar {
 		GoogleID: c.GoogleID,
 		Summary:  c.Summary,
 		UserID:   c.UserID,
+
+		SyncToken: c.SyncToken,
 	}
 }
 
@@ -34,6 +37,8 @@ func marshalCalendar(bc *biz.Calendar) *calendar {
 		GoogleID: bc.GoogleID,
 		Summary:  bc.Summary,
 		UserID:   bc.UserID,
+
+		SyncToken: bc.SyncToken,
 	}
 }
 
@@ -96,3 +101,9 @@ func (r *calendarRepo) List(_ context.Context, userID uuid.UUID) ([]*biz.Calenda
 	}
 	return cs.biz(), nil
 }
+
+func (r *calendarRepo) SetSyncToken(_ context.Context, id uuid.UUID, syncToken string) error {
+	r.log.Debugf("Set sync token of calendar: %v", id)
+	// the column is updated by name, so an empty token is written too
+	return r.data.db.Model(&calendar{}).Where("id = ?", id).Update("sync_token", syncToken).Error
+}
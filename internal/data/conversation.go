@@ -0,0 +1,136 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/google/uuid"
+	"github.com/kdimtricp/aical/internal/biz"
+	"github.com/kdimtricp/aical/internal/conf"
+	"github.com/kdimtricp/aical/pkg/openai"
+	"gorm.io/gorm"
+	"time"
+)
+
+//goland:noinspection GoSnakeCaseUsage
+const (
+	CONVERSATION_KEY_PREFIX         = "conversation:"
+	DEFAULT_CONVERSATION_TTL        = 24 * time.Hour
+	DEFAULT_CONVERSATION_LENGTH     = 50
+	CONVERSATION_ARCHIVE_BATCH_SIZE = 100
+)
+
+// conversationMessage is an archived chat message
+type conversationMessage struct {
+	gorm.Model
+	ID         uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID     uuid.UUID `gorm:"index"`
+	Role       string
+	Content    string
+	Name       string
+	ToolCalls  string
+	ToolCallID string
+}
+
+// marshalConversationMessage returns archived message from openai chat message
+func marshalConversationMessage(userID uuid.UUID, message openai.ChatCompletionMessage) (*conversationMessage, error) {
+	cm := &conversationMessage{
+		UserID:     userID,
+		Role:       message.Role,
+		Content:    message.Content,
+		Name:       message.Name,
+		ToolCallID: message.ToolCallID,
+	}
+	if len(message.ToolCalls) > 0 {
+		tc, err := json.Marshal(message.ToolCalls)
+		if err != nil {
+			return nil, err
+		}
+		cm.ToolCalls = string(tc)
+	}
+	return cm, nil
+}
+
+type conversationRepo struct {
+	data      *Data
+	log       *log.Helper
+	ttl       time.Duration
+	maxLength int64
+}
+
+func NewConversationRepo(data *Data, c *conf.OpenAI, logger log.Logger) biz.ConversationRepo {
+	r := &conversationRepo{
+		data:      data,
+		log:       log.NewHelper(logger),
+		ttl:       DEFAULT_CONVERSATION_TTL,
+		maxLength: DEFAULT_CONVERSATION_LENGTH,
+	}
+	if c.GetHistory().GetTtl() != nil {
+		r.ttl = c.GetHistory().GetTtl().AsDuration()
+	}
+	if c.GetHistory().GetMaxMessages() > 0 {
+		r.maxLength = int64(c.GetHistory().GetMaxMessages())
+	}
+	return r
+}
+
+func conversationKey(userID uuid.UUID) string {
+	return fmt.Sprintf("%s%s", CONVERSATION_KEY_PREFIX, userID)
+}
+
+// Load returns the recent conversation with the user from cache
+func (r *conversationRepo) Load(_ context.Context, userID uuid.UUID) ([]openai.ChatCompletionMessage, error) {
+	r.log.Debugf("Load conversation: %s", userID)
+	values, err := r.data.cache.LRange(conversationKey(userID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]openai.ChatCompletionMessage, 0, len(values))
+	for _, value := range values {
+		var message openai.ChatCompletionMessage
+		if err := json.Unmarshal([]byte(value), &message); err != nil {
+			r.log.Errorf("Load conversation: skip malformed message: %v", err)
+			continue
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// Append adds messages to the cached conversation and archives them in database
+func (r *conversationRepo) Append(_ context.Context, userID uuid.UUID, messages []openai.ChatCompletionMessage) error {
+	r.log.Debugf("Append conversation: %s, %d messages", userID, len(messages))
+	if len(messages) == 0 {
+		return nil
+	}
+	values := make([]interface{}, len(messages))
+	archive := make([]*conversationMessage, len(messages))
+	for i, message := range messages {
+		value, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+		values[i] = string(value)
+		cm, err := marshalConversationMessage(userID, message)
+		if err != nil {
+			return err
+		}
+		archive[i] = cm
+	}
+	key := conversationKey(userID)
+	pipe := r.data.cache.TxPipeline()
+	pipe.RPush(key, values...)
+	pipe.LTrim(key, -r.maxLength, -1)
+	pipe.Expire(key, r.ttl)
+	if _, err := pipe.Exec(); err != nil {
+		return err
+	}
+	return r.data.db.CreateInBatches(archive, CONVERSATION_ARCHIVE_BATCH_SIZE).Error
+}
+
+// Reset removes the cached conversation, archived messages are kept
+func (r *conversationRepo) Reset(_ context.Context, userID uuid.UUID) error {
+	r.log.Debugf("Reset conversation: %s", userID)
+	return r.data.cache.Del(conversationKey(userID)).Err()
+}
@@ -25,6 +25,14 @@ var ProviderSet = wire.NewSet(
 	NewEventRepo,
 	NewEventHistoryRepo,
 	NewGoogleRepo,
+	NewConversationRepo,
+	NewLLMProvider,
+	NewUsageRepo,
+	NewPendingActionRepo,
+	NewWatchChannelRepo,
+	NewLockRepo,
+	NewTGBot,
+	NewNotifier,
 )
 
 // Data .
@@ -66,6 +74,9 @@ func NewDB(c *conf.Data) (db *gorm.DB, err error) {
 		&calendar{},
 		&Event{},
 		&eventHistory{},
+		&conversationMessage{},
+		&tokenUsage{},
+		&watchChannel{},
 	}
 	for _, table := range tables {
 		if err := db.AutoMigrate(table); err != nil {
@@ -21,7 +21,16 @@ type Event struct {
 	EndTime    time.Time
 	IsUsed     bool
 	IsAllDay   bool
-	History    []*eventHistory
+	TimeZone   string
+	// Recurrence is set on series, RecurringEventID and OriginalStartTime on occurrences
+	Recurrence        []string `gorm:"serializer:json"`
+	RecurringEventID  string   `gorm:"index"`
+	OriginalStartTime time.Time
+	Description       string
+	Organizer         string
+	Attendees         []*biz.Attendee `gorm:"serializer:json"`
+	ConferenceURL     string
+	History           []*eventHistory
 }
 
 func (e *Event) biz() *biz.Event {
@@ -36,6 +45,15 @@ func (e *Event) biz() *biz.Event {
 		StartTime:  e.StartTime,
 		EndTime:    e.EndTime,
 		IsAllDay:   e.IsAllDay,
+		TimeZone:   e.TimeZone,
+
+		Recurrence:        e.Recurrence,
+		RecurringEventID:  e.RecurringEventID,
+		OriginalStartTime: e.OriginalStartTime,
+		Description:       e.Description,
+		Organizer:         e.Organizer,
+		Attendees:         e.Attendees,
+		ConferenceURL:     e.ConferenceURL,
 	}
 }
 
@@ -49,6 +67,15 @@ func marshalEvent(event *biz.Event) *Event {
 		StartTime:  event.StartTime,
 		EndTime:    event.EndTime,
 		IsAllDay:   event.IsAllDay,
+		TimeZone:   event.TimeZone,
+
+		Recurrence:        event.Recurrence,
+		RecurringEventID:  event.RecurringEventID,
+		OriginalStartTime: event.OriginalStartTime,
+		Description:       event.Description,
+		Organizer:         event.Organizer,
+		Attendees:         event.Attendees,
+		ConferenceURL:     event.ConferenceURL,
 	}
 }
 
@@ -74,7 +101,7 @@ func NewEventRepo(data *Data, logger log.Logger) biz.EventRepo {
 	}
 }
 
-func (r *eventRepo) Create(_ context.Context, event *biz.Event) (*biz.Event, error) {
+func (r *eventRepo) Create(ctx context.Context, event *biz.Event) (*biz.Event, error) {
 	r.log.Debugf("CreateAll Event: %v", event)
 	e := marshalEvent(event)
 	tx := r.data.db.Begin()
@@ -86,6 +113,7 @@ func (r *eventRepo) Create(_ context.Context, event *biz.Event) (*biz.Event, err
 		EventID:    e.ID,
 		CalendarID: e.CalendarID,
 		ChangeType: biz.CREATED,
+		Initiator:  biz.GetChangeInitiator(ctx),
 		ChangeTime: time.Now(),
 		NewEvent:   *event,
 	}).Error; err != nil {
@@ -108,7 +136,7 @@ func (r *eventRepo) Get(_ context.Context, event *biz.Event) (*biz.Event, error)
 	return e.biz(), nil
 }
 
-func (r *eventRepo) Update(_ context.Context, event *biz.Event) (*biz.Event, error) {
+func (r *eventRepo) Update(ctx context.Context, event *biz.Event) (*biz.Event, error) {
 	r.log.Debugf("Update Event: %v", event)
 	e := marshalEvent(event)
 	pe := &Event{}
@@ -126,6 +154,7 @@ func (r *eventRepo) Update(_ context.Context, event *biz.Event) (*biz.Event, err
 		EventID:    e.ID,
 		CalendarID: e.CalendarID,
 		ChangeType: biz.UPDATED,
+		Initiator:  biz.GetChangeInitiator(ctx),
 		ChangeTime: time.Now(),
 		PrevEvent:  *bpe,
 		NewEvent:   *event,
@@ -140,7 +169,7 @@ func (r *eventRepo) Update(_ context.Context, event *biz.Event) (*biz.Event, err
 	return e.biz(), nil
 }
 
-func (r *eventRepo) Delete(_ context.Context, event *biz.Event) error {
+func (r *eventRepo) Delete(ctx context.Context, event *biz.Event) error {
 	r.log.Debugf("Delete Event: %v", event)
 	e := marshalEvent(event)
 	tx := r.data.db.Begin()
@@ -152,6 +181,7 @@ func (r *eventRepo) Delete(_ context.Context, event *biz.Event) error {
 		EventID:    e.ID,
 		CalendarID: e.CalendarID,
 		ChangeType: biz.DELETED,
+		Initiator:  biz.GetChangeInitiator(ctx),
 		ChangeTime: time.Now(),
 		PrevEvent:  *event,
 	}).Error; err != nil {
@@ -173,3 +203,15 @@ func (r *eventRepo) List(_ context.Context, calendarID uuid.UUID) ([]*biz.Event,
 	}
 	return events.biz(), nil
 }
+
+func (r *eventRepo) ListBetween(_ context.Context, calendarID uuid.UUID, start, end time.Time) ([]*biz.Event, error) {
+	r.log.Debugf("List events between %s and %s: %v", start, end, calendarID)
+	var events events
+	if err := r.data.db.
+		Where("calendar_id = ? AND start_time < ? AND end_time > ?", calendarID, end, start).
+		Order("start_time").
+		Find(&events).Error; err != nil {
+		return nil, err
+	}
+	return events.biz(), nil
+}
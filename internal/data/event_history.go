@@ -14,10 +14,13 @@ type eventHistory struct {
 	ID         uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
 	EventID    uuid.UUID
 	CalendarID uuid.UUID
-	ChangeType biz.ChangeTypeEnum // Тип изменения: CREATED, UPDATED, DELETED
-	ChangeTime time.Time          // Время изменения
-	PrevEvent  biz.Event          `gorm:"embedded;embeddedPrefix:prev_"`
-	NewEvent   biz.Event          `gorm:"embedded;embeddedPrefix:new_"`
+	ChangeType biz.ChangeTypeEnum      // Тип изменения: CREATED, UPDATED, DELETED
+	ChangeTime time.Time               // Время изменения
+	PrevEvent  biz.Event               `gorm:"embedded;embeddedPrefix:prev_"`
+	NewEvent   biz.Event               `gorm:"embedded;embeddedPrefix:new_"`
+	Initiator  biz.ChangeInitiatorEnum `gorm:"index"`
+	Reverted   bool
+	Delivered  bool
 }
 
 func (eh *eventHistory) biz() *biz.EventHistory {
@@ -29,6 +32,9 @@ func (eh *eventHistory) biz() *biz.EventHistory {
 		ChangeTime: eh.ChangeTime,
 		PrevEvent:  eh.PrevEvent,
 		NewEvent:   eh.NewEvent,
+		Initiator:  eh.Initiator,
+		Reverted:   eh.Reverted,
+		Delivered:  eh.Delivered,
 	}
 }
 
@@ -61,3 +67,74 @@ func (r *eventHistoryRepo) DeleteCalendarEventHistory(_ context.Context, calenda
 	log.Debugf("Delete Event history: %v", calendarID)
 	return r.data.db.Where("calendar_id = ?", calendarID).Delete(&eventHistory{}).Error
 }
+
+func (r *eventHistoryRepo) ListUndoable(_ context.Context, userID uuid.UUID, limit int) ([]*biz.EventHistory, error) {
+	r.log.Debugf("List undoable Event history: %v", userID)
+	var eventHistories []*eventHistory
+	var bizEventHistories []*biz.EventHistory
+	if err := r.data.db.
+		Where("calendar_id IN (?)", r.data.db.Model(&calendar{}).Select("id").Where("user_id = ?", userID)).
+		Where("initiator = ? AND reverted = ?", biz.ASSISTANT, false).
+		Order("change_time DESC").
+		Limit(limit).
+		Find(&eventHistories).Error; err != nil {
+		return nil, err
+	}
+	for _, eventHistory := range eventHistories {
+		bizEventHistories = append(bizEventHistories, eventHistory.biz())
+	}
+	return bizEventHistories, nil
+}
+
+func (r *eventHistoryRepo) MarkReverted(_ context.Context, id uuid.UUID) error {
+	r.log.Debugf("Mark Event history reverted: %v", id)
+	return r.data.db.Model(&eventHistory{}).Where("id = ?", id).Update("reverted", true).Error
+}
+
+func (r *eventHistoryRepo) ListUserChanges(_ context.Context, userID uuid.UUID, initiator biz.ChangeInitiatorEnum, since time.Time) ([]*biz.EventHistory, error) {
+	r.log.Debugf("List user Event history: %v since %v", userID, since)
+	var eventHistories []*eventHistory
+	var bizEventHistories []*biz.EventHistory
+	if err := r.data.db.
+		Where("calendar_id IN (?)", r.data.db.Model(&calendar{}).Select("id").Where("user_id = ?", userID)).
+		Where("initiator = ? AND change_time > ?", initiator, since).
+		Order("change_time").
+		Find(&eventHistories).Error; err != nil {
+		return nil, err
+	}
+	for _, eventHistory := range eventHistories {
+		bizEventHistories = append(bizEventHistories, eventHistory.biz())
+	}
+	return bizEventHistories, nil
+}
+
+func (r *eventHistoryRepo) ListUndelivered(_ context.Context, userID uuid.UUID, since time.Time) ([]*biz.EventHistory, error) {
+	r.log.Debugf("List undelivered Event history: %v since %v", userID, since)
+	var eventHistories []*eventHistory
+	var bizEventHistories []*biz.EventHistory
+	if err := r.data.db.
+		Where("calendar_id IN (?)", r.data.db.Model(&calendar{}).Select("id").Where("user_id = ?", userID)).
+		Where("initiator = ? AND delivered = ? AND change_time > ?", biz.SYNC, false, since).
+		Order("change_time").
+		Find(&eventHistories).Error; err != nil {
+		return nil, err
+	}
+	for _, eventHistory := range eventHistories {
+		bizEventHistories = append(bizEventHistories, eventHistory.biz())
+	}
+	return bizEventHistories, nil
+}
+
+func (r *eventHistoryRepo) MarkDelivered(_ context.Context, ids []uuid.UUID) error {
+	r.log.Debugf("Mark Event history delivered: %v", ids)
+	if len(ids) == 0 {
+		return nil
+	}
+	return r.data.db.Model(&eventHistory{}).Where("id IN ?", ids).Update("delivered", true).Error
+}
+
+func (r *eventHistoryRepo) DeleteBefore(_ context.Context, before time.Time) (int64, error) {
+	r.log.Debugf("Delete Event history before: %v", before)
+	tx := r.data.db.Unscoped().Where("change_time < ?", before).Delete(&eventHistory{})
+	return tx.RowsAffected, tx.Error
+}
@@ -2,20 +2,43 @@ package data
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/go-kratos/kratos/v2/log"
+	"github.com/google/uuid"
 	"github.com/kdimtricp/aical/internal/biz"
 	"github.com/kdimtricp/aical/internal/conf"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	calendarAPI "google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
 	oauth2API "google.golang.org/api/oauth2/v2"
 	"google.golang.org/api/option"
+	peopleAPI "google.golang.org/api/people/v1"
+	"net/http"
+	"strings"
 	"time"
 )
 
+//goland:noinspection GoSnakeCaseUsage
+const (
+	GOOGLE_MEET_SOLUTION = "hangoutsMeet"
+	// GOOGLE_MEET_URL is the prefix of the Meet links, the rest of the link is the conference ID
+	GOOGLE_MEET_URL = "https://meet.google.com/"
+	// GOOGLE_DATE_LAYOUT is the date format of the all-day events
+	GOOGLE_DATE_LAYOUT = "2006-01-02"
+	// GOOGLE_CONFERENCE_DATA_VERSION lets the requests create conferences
+	GOOGLE_CONFERENCE_DATA_VERSION = 1
+	GOOGLE_EVENT_CANCELLED         = "cancelled"
+	// GOOGLE_SYNC_PAGE_SIZE is the maximum number of events Google returns in one page
+	GOOGLE_SYNC_PAGE_SIZE = 2500
+	GOOGLE_WATCH_TYPE     = "web_hook"
+)
+
 // googleRepo .
 type googleRepo struct {
 	config *oauth2.Config
+	log    *log.Helper
 }
 
 func NewGoogleRepo(c *conf.Google, logger log.Logger) (biz.GoogleRepo, func(), error) {
@@ -30,11 +53,14 @@ func NewGoogleRepo(c *conf.Google, logger log.Logger) (biz.GoogleRepo, func(), e
 			Scopes: []string{
 				calendarAPI.CalendarScope,
 				calendarAPI.CalendarEventsScope,
+				peopleAPI.ContactsReadonlyScope,
+				peopleAPI.ContactsOtherReadonlyScope,
 				oauth2API.UserinfoEmailScope,
 				oauth2API.UserinfoProfileScope,
 			},
 			Endpoint: google.Endpoint,
 		},
+		log: log.NewHelper(logger),
 	}, cleanup, nil
 }
 
@@ -93,15 +119,149 @@ func (g *googleRepo) ListUserCalendars(ctx context.Context, token *oauth2.Token)
 	return calendars, nil
 }
 
+// CalendarTimezone returns the timezone setting of the user calendars
+func (g *googleRepo) CalendarTimezone(ctx context.Context, token *oauth2.Token) (string, error) {
+	client := oauth2.NewClient(ctx, g.config.TokenSource(ctx, token))
+	srv, err := calendarAPI.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return "", err
+	}
+	setting, err := srv.Settings.Get("timezone").Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	return setting.Value, nil
+}
+
+// FreeBusy queries the busy periods of the calendars
+func (g *googleRepo) FreeBusy(ctx context.Context, token *oauth2.Token, calendarIDs []string, timeMin, timeMax time.Time) (map[string][]*biz.BusyPeriod, error) {
+	client := oauth2.NewClient(ctx, g.config.TokenSource(ctx, token))
+	srv, err := calendarAPI.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*calendarAPI.FreeBusyRequestItem, len(calendarIDs))
+	for i, id := range calendarIDs {
+		items[i] = &calendarAPI.FreeBusyRequestItem{Id: id}
+	}
+	resp, err := srv.Freebusy.Query(&calendarAPI.FreeBusyRequest{
+		TimeMin: timeMin.Format(time.RFC3339),
+		TimeMax: timeMax.Format(time.RFC3339),
+		Items:   items,
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	// a calendar Google could not check is left out of the result, the others are still usable
+	busy := make(map[string][]*biz.BusyPeriod, len(resp.Calendars))
+	for id, cal := range resp.Calendars {
+		if len(cal.Errors) > 0 {
+			g.log.Warnf("free busy of calendar %s: %s", id, cal.Errors[0].Reason)
+			continue
+		}
+		busy[id] = make([]*biz.BusyPeriod, 0, len(cal.Busy))
+		for _, period := range cal.Busy {
+			start, err := time.Parse(time.RFC3339, period.Start)
+			if err != nil {
+				return nil, err
+			}
+			end, err := time.Parse(time.RFC3339, period.End)
+			if err != nil {
+				return nil, err
+			}
+			busy[id] = append(busy[id], &biz.BusyPeriod{Start: start, End: end})
+		}
+	}
+	return busy, nil
+}
+
 // marshalEvent converts a biz.Event to a calendarAPI.Event
 func marshalGoogleEvent(event *biz.Event) *calendarAPI.Event {
-	return &calendarAPI.Event{
-		Id:       event.GoogleID,
-		Summary:  event.Summary,
-		Location: event.Location,
-		Start:    &calendarAPI.EventDateTime{DateTime: event.StartTime.Format(time.RFC3339)},
-		End:      &calendarAPI.EventDateTime{DateTime: event.EndTime.Format(time.RFC3339)},
+	e := &calendarAPI.Event{
+		Id:               event.GoogleID,
+		Summary:          event.Summary,
+		Location:         event.Location,
+		Start:            marshalGoogleEventTime(event.StartTime, event.IsAllDay, event.TimeZone),
+		End:              marshalGoogleEventTime(event.EndTime, event.IsAllDay, event.TimeZone),
+		Recurrence:       event.Recurrence,
+		RecurringEventId: event.RecurringEventID,
+		Description:      event.Description,
+		Attendees:        marshalGoogleAttendees(event.Attendees),
+	}
+	if event.AddVideoCall {
+		e.ConferenceData = &calendarAPI.ConferenceData{
+			CreateRequest: &calendarAPI.CreateConferenceRequest{
+				RequestId:             uuid.NewString(),
+				ConferenceSolutionKey: &calendarAPI.ConferenceSolutionKey{Type: GOOGLE_MEET_SOLUTION},
+			},
+		}
+	}
+	if !event.OriginalStartTime.IsZero() {
+		e.OriginalStartTime = marshalGoogleEventTime(event.OriginalStartTime, event.IsAllDay, event.TimeZone)
+	}
+	return e
+}
+
+// marshalGoogleEventTime converts the event time, the all-day events have only the date.
+// The date of an all-day event is kept as it is, the other times are shown in the time zone.
+func marshalGoogleEventTime(t time.Time, allDay bool, timeZone string) *calendarAPI.EventDateTime {
+	if allDay {
+		return &calendarAPI.EventDateTime{Date: t.Format(GOOGLE_DATE_LAYOUT), TimeZone: timeZone}
+	}
+	if loc, err := time.LoadLocation(timeZone); err == nil && timeZone != "" {
+		t = t.In(loc)
 	}
+	return &calendarAPI.EventDateTime{DateTime: t.Format(time.RFC3339), TimeZone: timeZone}
+}
+
+// marshalGoogleConference returns the conference data joining the existing Meet call of the link,
+// nil for the links of the other providers, they can not be attached without their add-on
+func marshalGoogleConference(link string) *calendarAPI.ConferenceData {
+	id, ok := strings.CutPrefix(link, GOOGLE_MEET_URL)
+	if !ok || id == "" {
+		return nil
+	}
+	return &calendarAPI.ConferenceData{
+		ConferenceId:       id,
+		ConferenceSolution: &calendarAPI.ConferenceSolution{Key: &calendarAPI.ConferenceSolutionKey{Type: GOOGLE_MEET_SOLUTION}},
+		EntryPoints:        []*calendarAPI.EntryPoint{{EntryPointType: "video", Uri: link}},
+	}
+}
+
+func marshalGoogleAttendees(attendees []*biz.Attendee) []*calendarAPI.EventAttendee {
+	if attendees == nil {
+		return nil
+	}
+	result := make([]*calendarAPI.EventAttendee, len(attendees))
+	for i, a := range attendees {
+		result[i] = &calendarAPI.EventAttendee{
+			Email:          a.Email,
+			DisplayName:    a.Name,
+			ResponseStatus: a.ResponseStatus,
+			Comment:        a.Comment,
+			Optional:       a.Optional,
+		}
+	}
+	return result
+}
+
+func unmarshalGoogleAttendees(attendees []*calendarAPI.EventAttendee) []*biz.Attendee {
+	if len(attendees) == 0 {
+		return nil
+	}
+	result := make([]*biz.Attendee, len(attendees))
+	for i, a := range attendees {
+		result[i] = &biz.Attendee{
+			Email:          a.Email,
+			Name:           a.DisplayName,
+			ResponseStatus: a.ResponseStatus,
+			Comment:        a.Comment,
+			Optional:       a.Optional,
+			Organizer:      a.Organizer,
+			Self:           a.Self,
+		}
+	}
+	return result
 }
 
 // unmarshalGoogleEvent converts a calendarAPI.Event to a biz.Event
@@ -111,21 +271,51 @@ func unmarshalGoogleEvent(event *calendarAPI.Event) *biz.Event {
 	if err == nil {
 		e.UpdatedAt = updated
 	}
-	if startDate, err := time.Parse("2006-01-02", event.Start.Date); err == nil {
-		e.StartTime = startDate
-		e.IsAllDay = true
+	// the cancelled events of the incremental sync carry only the ID and the status
+	e.Cancelled = event.Status == GOOGLE_EVENT_CANCELLED
+	if event.Start != nil {
+		if startDate, err := time.Parse(GOOGLE_DATE_LAYOUT, event.Start.Date); err == nil {
+			e.StartTime = startDate
+			e.IsAllDay = true
+		}
+		if startTime, err := time.Parse(time.RFC3339, event.Start.DateTime); err == nil {
+			e.StartTime = startTime
+			e.IsAllDay = false
+		}
+		e.TimeZone = event.Start.TimeZone
 	}
-	if startTime, err := time.Parse(time.RFC3339, event.Start.DateTime); err == nil {
-		e.StartTime = startTime
-		e.IsAllDay = false
+	if event.End != nil {
+		if endDate, err := time.Parse(GOOGLE_DATE_LAYOUT, event.End.Date); err == nil {
+			e.EndTime = endDate
+			e.IsAllDay = true
+		}
+		if endTime, err := time.Parse(time.RFC3339, event.End.DateTime); err == nil {
+			e.EndTime = endTime
+			e.IsAllDay = false
+		}
 	}
-	if endDate, err := time.Parse("2006-01-02", event.End.Date); err == nil {
-		e.EndTime = endDate
-		e.IsAllDay = true
+	e.Description = event.Description
+	if event.Organizer != nil {
+		e.Organizer = event.Organizer.Email
 	}
-	if endTime, err := time.Parse(time.RFC3339, event.End.DateTime); err == nil {
-		e.EndTime = endTime
-		e.IsAllDay = false
+	e.Attendees = unmarshalGoogleAttendees(event.Attendees)
+	e.ConferenceURL = event.HangoutLink
+	if event.ConferenceData != nil {
+		for _, entry := range event.ConferenceData.EntryPoints {
+			if entry.EntryPointType == "video" {
+				e.ConferenceURL = entry.Uri
+			}
+		}
+	}
+	e.Recurrence = event.Recurrence
+	e.RecurringEventID = event.RecurringEventId
+	if event.OriginalStartTime != nil {
+		if originalDate, err := time.Parse(GOOGLE_DATE_LAYOUT, event.OriginalStartTime.Date); err == nil {
+			e.OriginalStartTime = originalDate
+		}
+		if originalTime, err := time.Parse(time.RFC3339, event.OriginalStartTime.DateTime); err == nil {
+			e.OriginalStartTime = originalTime
+		}
 	}
 	e.GoogleID = event.Id
 	e.Summary = event.Summary
@@ -173,13 +363,136 @@ func (g *googleRepo) ListCalendarEvents(ctx context.Context, token *oauth2.Token
 	return bizEvents, nil
 }
 
+// SyncCalendarEvents pages through the changes since the sync token. The recurring events are expanded into
+// occurrences, so a moved or cancelled occurrence is a change of its own.
+func (g *googleRepo) SyncCalendarEvents(ctx context.Context, token *oauth2.Token, calendarID string, syncToken string, opts *biz.GoogleListEventsOption) (*biz.EventChanges, error) {
+	client := oauth2.NewClient(ctx, g.config.TokenSource(ctx, token))
+	srv, err := calendarAPI.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, err
+	}
+	changes := &biz.EventChanges{Full: syncToken == ""}
+	pageToken := ""
+	for {
+		call := srv.Events.List(calendarID).SingleEvents(true).MaxResults(GOOGLE_SYNC_PAGE_SIZE).Context(ctx)
+		if syncToken != "" {
+			// the window of the full sync is kept by the token, Google refuses it together with the token
+			call = call.SyncToken(syncToken).ShowDeleted(true)
+		} else if opts != nil {
+			if opts.TimeMin != "" {
+				call = call.TimeMin(opts.TimeMin)
+			}
+			if opts.TimeMax != "" {
+				call = call.TimeMax(opts.TimeMax)
+			}
+		}
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		events, err := call.Do()
+		if err != nil {
+			var apiErr *googleapi.Error
+			if errors.As(err, &apiErr) && apiErr.Code == http.StatusGone {
+				return nil, biz.ErrSyncTokenExpired
+			}
+			return nil, err
+		}
+		for _, event := range events.Items {
+			e := unmarshalGoogleEvent(event)
+			if e.Cancelled && changes.Full {
+				continue
+			}
+			changes.Events = append(changes.Events, e)
+		}
+		if events.NextPageToken == "" {
+			changes.NextSyncToken = events.NextSyncToken
+			return changes, nil
+		}
+		pageToken = events.NextPageToken
+	}
+}
+
+func (g *googleRepo) WatchCalendarEvents(ctx context.Context, token *oauth2.Token, calendarID string, channel *biz.WatchChannel, address string) (*biz.WatchChannel, error) {
+	client := oauth2.NewClient(ctx, g.config.TokenSource(ctx, token))
+	srv, err := calendarAPI.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, err
+	}
+	ch, err := srv.Events.Watch(calendarID, &calendarAPI.Channel{
+		Id:         channel.ID.String(),
+		Type:       GOOGLE_WATCH_TYPE,
+		Address:    address,
+		Token:      channel.Token,
+		Expiration: channel.Expiration.UnixMilli(),
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	watched := *channel
+	watched.ResourceID = ch.ResourceId
+	// Google may shorten the requested lifetime
+	if ch.Expiration > 0 {
+		watched.Expiration = time.UnixMilli(ch.Expiration)
+	}
+	return &watched, nil
+}
+
+func (g *googleRepo) StopWatchChannel(ctx context.Context, token *oauth2.Token, channel *biz.WatchChannel) error {
+	client := oauth2.NewClient(ctx, g.config.TokenSource(ctx, token))
+	srv, err := calendarAPI.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return err
+	}
+	return srv.Channels.Stop(&calendarAPI.Channel{
+		Id:         channel.ID.String(),
+		ResourceId: channel.ResourceID,
+	}).Context(ctx).Do()
+}
+
+func (g *googleRepo) ListEventInstances(ctx context.Context, token *oauth2.Token, calendarID string, eventID string, opts *biz.GoogleListEventsOption) ([]*biz.Event, error) {
+	client := oauth2.NewClient(ctx, g.config.TokenSource(ctx, token))
+	srv, err := calendarAPI.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, err
+	}
+	call := srv.Events.Instances(calendarID, eventID).Context(ctx)
+	if opts != nil {
+		call = opts.ListEventsInstancesCallWithOpts(call)
+	}
+	instances, err := call.Do()
+	if err != nil {
+		return nil, err
+	}
+	events := make([]*biz.Event, len(instances.Items))
+	for i, instance := range instances.Items {
+		events[i] = unmarshalGoogleEvent(instance)
+	}
+	return events, nil
+}
+
 func (g *googleRepo) CreateCalendarEvent(ctx context.Context, token *oauth2.Token, event *biz.Event, calendarID string) (*biz.Event, error) {
 	client := oauth2.NewClient(ctx, g.config.TokenSource(ctx, token))
 	srv, err := calendarAPI.NewService(ctx, option.WithHTTPClient(client))
 	if err != nil {
 		return nil, err
 	}
-	e, err := srv.Events.Insert(calendarID, marshalGoogleEvent(event)).Do()
+	ge := marshalGoogleEvent(event)
+	// a recreated event joins its old call, the links Google can not attach are kept in the description
+	if !event.AddVideoCall && event.ConferenceURL != "" {
+		ge.ConferenceData = marshalGoogleConference(event.ConferenceURL)
+		if ge.ConferenceData == nil && !strings.Contains(ge.Description, event.ConferenceURL) {
+			ge.Description = strings.TrimSpace(ge.Description + "\n\nVideo call: " + event.ConferenceURL)
+		}
+	}
+	call := srv.Events.Insert(calendarID, ge).Context(ctx)
+	if event.SendUpdates != "" {
+		call = call.SendUpdates(event.SendUpdates)
+	}
+	// without the version the conference data of the request is ignored
+	if ge.ConferenceData != nil {
+		call = call.ConferenceDataVersion(GOOGLE_CONFERENCE_DATA_VERSION)
+	}
+	e, err := call.Do()
 	if err != nil {
 		return nil, err
 	}
@@ -192,7 +505,16 @@ func (g *googleRepo) UpdateCalendarEvent(ctx context.Context, token *oauth2.Toke
 	if err != nil {
 		return nil, err
 	}
-	e, err := srv.Events.Update(calendarID, event.GoogleID, marshalGoogleEvent(event)).Do()
+	call := srv.Events.Update(calendarID, event.GoogleID, marshalGoogleEvent(event)).Context(ctx)
+	if event.SendUpdates != "" {
+		call = call.SendUpdates(event.SendUpdates)
+	}
+	// without the version the conference data of the request is ignored,
+	// it is set only to add a call, so the existing conferences are kept as they are
+	if event.AddVideoCall {
+		call = call.ConferenceDataVersion(GOOGLE_CONFERENCE_DATA_VERSION)
+	}
+	e, err := call.Do()
 	if err != nil {
 		return nil, err
 	}
@@ -205,7 +527,11 @@ func (g *googleRepo) DeleteCalendarEvent(ctx context.Context, token *oauth2.Toke
 	if err != nil {
 		return err
 	}
-	return srv.Events.Delete(calendarID, event.GoogleID).Do()
+	call := srv.Events.Delete(calendarID, event.GoogleID).Context(ctx)
+	if event.SendUpdates != "" {
+		call = call.SendUpdates(event.SendUpdates)
+	}
+	return call.Do()
 }
 
 func (g *googleRepo) GetCalendarEvent(ctx context.Context, token *oauth2.Token, event *biz.Event, calendarID string) (*biz.Event, error) {
@@ -221,6 +547,85 @@ func (g *googleRepo) GetCalendarEvent(ctx context.Context, token *oauth2.Token,
 	return unmarshalGoogleEvent(e), nil
 }
 
+// RespondCalendarEvent patches the response of the attendee of the calendar owner
+func (g *googleRepo) RespondCalendarEvent(ctx context.Context, token *oauth2.Token, event *biz.Event, calendarID string, response string, comment string) (*biz.Event, error) {
+	client := oauth2.NewClient(ctx, g.config.TokenSource(ctx, token))
+	srv, err := calendarAPI.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, err
+	}
+	e, err := srv.Events.Get(calendarID, event.GoogleID).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	self := false
+	for _, a := range e.Attendees {
+		if a.Self {
+			a.ResponseStatus = response
+			a.Comment = comment
+			self = true
+		}
+	}
+	if !self {
+		return nil, fmt.Errorf("the user is not invited to event %s", event.GoogleID)
+	}
+	patched, err := srv.Events.Patch(calendarID, event.GoogleID, &calendarAPI.Event{Attendees: e.Attendees}).
+		SendUpdates(biz.SEND_UPDATES_ALL).
+		Context(ctx).
+		Do()
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalGoogleEvent(patched), nil
+}
+
+// SearchContacts searches the saved and the other contacts of the user
+func (g *googleRepo) SearchContacts(ctx context.Context, token *oauth2.Token, query string, limit int) ([]*biz.Contact, error) {
+	client := oauth2.NewClient(ctx, g.config.TokenSource(ctx, token))
+	srv, err := peopleAPI.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, err
+	}
+	saved, err := srv.People.SearchContacts().Query(query).ReadMask("names,emailAddresses").PageSize(int64(limit)).Context(ctx).Do()
+	if err != nil {
+		return nil, contactsError(err)
+	}
+	other, err := srv.OtherContacts.Search().Query(query).ReadMask("names,emailAddresses").PageSize(int64(limit)).Context(ctx).Do()
+	if err != nil {
+		return nil, contactsError(err)
+	}
+	var contacts []*biz.Contact
+	seen := make(map[string]bool)
+	for _, result := range append(saved.Results, other.Results...) {
+		person := result.Person
+		if person == nil {
+			continue
+		}
+		name := ""
+		if len(person.Names) > 0 {
+			name = person.Names[0].DisplayName
+		}
+		for _, email := range person.EmailAddresses {
+			if email.Value == "" || seen[email.Value] || len(contacts) >= limit {
+				continue
+			}
+			seen[email.Value] = true
+			contacts = append(contacts, &biz.Contact{Name: name, Email: email.Value})
+		}
+	}
+	return contacts, nil
+}
+
+// contactsError maps the 403 of a token granted without the contacts scopes to biz.ErrContactsScopeMissing
+func contactsError(err error) error {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusForbidden &&
+		(strings.Contains(apiErr.Body, "ACCESS_TOKEN_SCOPE_INSUFFICIENT") || strings.Contains(strings.ToLower(apiErr.Message), "insufficient")) {
+		return fmt.Errorf("%w: %v", biz.ErrContactsScopeMissing, err)
+	}
+	return err
+}
+
 // CreateNewCalendar creates a new calendar in google calendar
 func (g *googleRepo) CreateNewCalendar(ctx context.Context, token *oauth2.Token, calendarName string) (*biz.Calendar, error) {
 	client := oauth2.NewClient(ctx, g.config.TokenSource(ctx, token))
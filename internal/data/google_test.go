@@ -0,0 +1,64 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kdimtricp/aical/internal/biz"
+)
+
+func TestMarshalGoogleEventTimes(t *testing.T) {
+	tests := []struct {
+		name      string
+		event     *biz.Event
+		startDate string
+		start     string
+		endDate   string
+		end       string
+	}{
+		{
+			name:      "all-day",
+			event:     &biz.Event{StartTime: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), EndTime: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC), IsAllDay: true, TimeZone: "America/New_York"},
+			startDate: "2024-01-02",
+			endDate:   "2024-01-03",
+		},
+		{
+			name:  "timed in the time zone",
+			event: &biz.Event{StartTime: time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC), EndTime: time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC), TimeZone: "Europe/Berlin"},
+			start: "2024-01-02T10:00:00+01:00",
+			end:   "2024-01-02T11:00:00+01:00",
+		},
+		{
+			name:  "timed without time zone",
+			event: &biz.Event{StartTime: time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC), EndTime: time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)},
+			start: "2024-01-02T09:00:00Z",
+			end:   "2024-01-02T10:00:00Z",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := marshalGoogleEvent(tt.event)
+			if e.Start.Date != tt.startDate || e.Start.DateTime != tt.start {
+				t.Errorf("start = %q %q, want %q %q", e.Start.Date, e.Start.DateTime, tt.startDate, tt.start)
+			}
+			if e.End.Date != tt.endDate || e.End.DateTime != tt.end {
+				t.Errorf("end = %q %q, want %q %q", e.End.Date, e.End.DateTime, tt.endDate, tt.end)
+			}
+			// the event reads back as it was written
+			got := unmarshalGoogleEvent(e)
+			if got.IsAllDay != tt.event.IsAllDay || !got.StartTime.Equal(tt.event.StartTime) || !got.EndTime.Equal(tt.event.EndTime) {
+				t.Errorf("round trip = %s – %s all-day %t", got.StartTime, got.EndTime, got.IsAllDay)
+			}
+		})
+	}
+}
+
+func TestMarshalGoogleConference(t *testing.T) {
+	conference := marshalGoogleConference("https://meet.google.com/abc-defg-hij")
+	if conference == nil || conference.ConferenceId != "abc-defg-hij" || conference.ConferenceSolution.Key.Type != GOOGLE_MEET_SOLUTION {
+		t.Errorf("Meet conference = %+v", conference)
+	}
+	if conference := marshalGoogleConference("https://zoom.us/j/123"); conference != nil {
+		t.Errorf("conference of a Zoom link = %+v, want nil", conference)
+	}
+}
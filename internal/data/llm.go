@@ -0,0 +1,75 @@
+package data
+
+import (
+	"fmt"
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/kdimtricp/aical/internal/conf"
+	"github.com/kdimtricp/aical/pkg/openai"
+)
+
+//goland:noinspection GoSnakeCaseUsage
+const (
+	LLM_PROVIDER_OPENAI     = "openai"
+	LLM_PROVIDER_AZURE      = "azure"
+	LLM_PROVIDER_COMPATIBLE = "compatible"
+	LLM_PROVIDER_MOCK       = "mock"
+)
+
+// NewLLMProvider returns the chat completion provider configured in openai.api.provider.
+// The provider is shared by all use cases, so its rate limit applies to chat and cron traffic together.
+func NewLLMProvider(c *conf.OpenAI, logger log.Logger) (openai.Provider, error) {
+	api := c.GetApi()
+	log.NewHelper(logger).Infof("using llm provider: %s", api.GetProvider())
+	switch api.GetProvider() {
+	case "", LLM_PROVIDER_OPENAI:
+		return openai.NewClient(api.GetKey(), api.GetModel(), append(clientOptions(c),
+			openai.WithBaseURL(api.GetBaseUrl()),
+			openai.WithOrganization(api.GetOrganization()),
+		)...), nil
+	case LLM_PROVIDER_COMPATIBLE:
+		if api.GetBaseUrl() == "" {
+			return nil, fmt.Errorf("llm provider %s requires base url", api.GetProvider())
+		}
+		return openai.NewClient(api.GetKey(), api.GetModel(), append(clientOptions(c),
+			openai.WithBaseURL(api.GetBaseUrl()),
+		)...), nil
+	case LLM_PROVIDER_AZURE:
+		if api.GetBaseUrl() == "" {
+			return nil, fmt.Errorf("llm provider %s requires base url", api.GetProvider())
+		}
+		return openai.NewClient(api.GetKey(), api.GetModel(), append(clientOptions(c),
+			openai.WithBaseURL(api.GetBaseUrl()),
+			openai.WithAzure(api.GetApiVersion()),
+		)...), nil
+	case LLM_PROVIDER_MOCK:
+		if api.GetMockScript() == "" {
+			return openai.NewMockProvider(), nil
+		}
+		return openai.LoadMockProvider(api.GetMockScript())
+	default:
+		return nil, fmt.Errorf("unknown llm provider: %s", api.GetProvider())
+	}
+}
+
+// clientOptions returns the timeout, retry and rate limit options of the http client
+func clientOptions(c *conf.OpenAI) []openai.ClientOption {
+	var opts []openai.ClientOption
+	// without the retry block the client keeps its default retries
+	if c.GetRetry() != nil {
+		opts = append(opts, openai.WithRetry(
+			int(c.GetRetry().GetMaxRetries()),
+			c.GetRetry().GetBaseWait().AsDuration(),
+			c.GetRetry().GetMaxWait().AsDuration(),
+		))
+	}
+	if c.GetApi().GetTimeout() != nil {
+		opts = append(opts, openai.WithRequestTimeout(c.GetApi().GetTimeout().AsDuration()))
+	}
+	if c.GetRateLimit().GetRequestsPerMinute() > 0 {
+		opts = append(opts, openai.WithLimiter(openai.NewLimiter(
+			int(c.GetRateLimit().GetRequestsPerMinute()),
+			int(c.GetRateLimit().GetBurst()),
+		)))
+	}
+	return opts
+}
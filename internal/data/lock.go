@@ -0,0 +1,62 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/go-redis/redis"
+	"github.com/kdimtricp/aical/internal/biz"
+	"time"
+)
+
+//goland:noinspection GoSnakeCaseUsage
+const (
+	LOCK_KEY_PREFIX = "lock:"
+)
+
+// the lease is changed only by its owner, the check and the change run atomically in Redis
+var (
+	renewLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+	releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+type lockRepo struct {
+	data *Data
+	log  *log.Helper
+}
+
+func NewLockRepo(data *Data, logger log.Logger) biz.LockRepo {
+	return &lockRepo{
+		data: data,
+		log:  log.NewHelper(logger),
+	}
+}
+
+func lockKey(name string) string {
+	return fmt.Sprintf("%s%s", LOCK_KEY_PREFIX, name)
+}
+
+func (r *lockRepo) Acquire(_ context.Context, name string, owner string, ttl time.Duration) (bool, error) {
+	return r.data.cache.SetNX(lockKey(name), owner, ttl).Result()
+}
+
+func (r *lockRepo) Renew(_ context.Context, name string, owner string, ttl time.Duration) (bool, error) {
+	n, err := renewLockScript.Run(r.data.cache, []string{lockKey(name)}, owner, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (r *lockRepo) Release(_ context.Context, name string, owner string) error {
+	r.log.Debugf("Release lock: %s", name)
+	return releaseLockScript.Run(r.data.cache, []string{lockKey(name)}, owner).Err()
+}
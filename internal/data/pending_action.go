@@ -0,0 +1,86 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/go-redis/redis"
+	"github.com/google/uuid"
+	"github.com/kdimtricp/aical/internal/biz"
+	"time"
+)
+
+//goland:noinspection GoSnakeCaseUsage
+const (
+	PENDING_ACTION_KEY_PREFIX = "pending_action:"
+)
+
+type pendingActionRepo struct {
+	data *Data
+	log  *log.Helper
+}
+
+func NewPendingActionRepo(data *Data, logger log.Logger) biz.PendingActionRepo {
+	return &pendingActionRepo{
+		data: data,
+		log:  log.NewHelper(logger),
+	}
+}
+
+func pendingActionKey(id uuid.UUID) string {
+	return fmt.Sprintf("%s%s", PENDING_ACTION_KEY_PREFIX, id)
+}
+
+// Create stores the action in cache until it expires
+func (r *pendingActionRepo) Create(_ context.Context, action *biz.PendingAction) error {
+	if action.ID == uuid.Nil {
+		action.ID = uuid.New()
+	}
+	r.log.Debugf("Create pending action: %s %s", action.ID, action.Function)
+	value, err := json.Marshal(action)
+	if err != nil {
+		return err
+	}
+	return r.data.cache.Set(pendingActionKey(action.ID), value, time.Until(action.ExpiresAt)).Err()
+}
+
+func (r *pendingActionRepo) Get(_ context.Context, id uuid.UUID) (*biz.PendingAction, error) {
+	r.log.Debugf("Get pending action: %s", id)
+	value, err := r.data.cache.Get(pendingActionKey(id)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalPendingAction(value)
+}
+
+// Take gets and deletes the action in one transaction, so an action is executed at most once
+func (r *pendingActionRepo) Take(_ context.Context, id uuid.UUID) (*biz.PendingAction, error) {
+	r.log.Debugf("Take pending action: %s", id)
+	key := pendingActionKey(id)
+	pipe := r.data.cache.TxPipeline()
+	get := pipe.Get(key)
+	pipe.Del(key)
+	if _, err := pipe.Exec(); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	value, err := get.Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalPendingAction(value)
+}
+
+func unmarshalPendingAction(value []byte) (*biz.PendingAction, error) {
+	var action biz.PendingAction
+	if err := json.Unmarshal(value, &action); err != nil {
+		return nil, err
+	}
+	return &action, nil
+}
@@ -0,0 +1,57 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-kratos/kratos/v2/log"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/kdimtricp/aical/internal/biz"
+	"github.com/kdimtricp/aical/internal/conf"
+	"strconv"
+)
+
+// NewTGBot connects the Telegram bot, it is shared by the chat server and the notifications
+func NewTGBot(c *conf.Server) (*tgbotapi.BotAPI, error) {
+	bot, err := tgbotapi.NewBotAPI(c.Tg.Token)
+	if err != nil {
+		return nil, err
+	}
+	bot.Debug = false
+	return bot, nil
+}
+
+type tgNotifier struct {
+	bot *tgbotapi.BotAPI
+	log *log.Helper
+}
+
+func NewNotifier(bot *tgbotapi.BotAPI, logger log.Logger) biz.Notifier {
+	return &tgNotifier{
+		bot: bot,
+		log: log.NewHelper(logger),
+	}
+}
+
+// Notify sends the notification to the private chat of the user, its ID is the Telegram ID of the user.
+// Every action is sent as its own message with the Confirm and Cancel buttons the chat server answers.
+func (n *tgNotifier) Notify(_ context.Context, user *biz.User, notification *biz.Notification) error {
+	n.log.Debugf("Notify user: %s", user.ID)
+	chatID, err := strconv.ParseInt(user.TGID, 10, 64)
+	if err != nil {
+		return err
+	}
+	if _, err := n.bot.Send(tgbotapi.NewMessage(chatID, notification.Text)); err != nil {
+		return err
+	}
+	for _, action := range notification.Actions {
+		msg := tgbotapi.NewMessage(chatID, action.Preview)
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Confirm", fmt.Sprintf("%s:%s", biz.PENDING_ACTION_CONFIRM, action.ID)),
+			tgbotapi.NewInlineKeyboardButtonData("Cancel", fmt.Sprintf("%s:%s", biz.PENDING_ACTION_CANCEL, action.ID)),
+		))
+		if _, err := n.bot.Send(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package data
+
+import (
+	"context"
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/google/uuid"
+	"github.com/kdimtricp/aical/internal/biz"
+	"gorm.io/gorm"
+	"time"
+)
+
+// tokenUsage is the token usage of one model request
+type tokenUsage struct {
+	gorm.Model
+	ID               uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID           uuid.UUID `gorm:"index"`
+	Kind             string
+	LLMModel         string `gorm:"column:model"`
+	PromptTokens     int64
+	CompletionTokens int64
+	TotalTokens      int64
+}
+
+func marshalTokenUsage(usage *biz.Usage) *tokenUsage {
+	return &tokenUsage{
+		UserID:           usage.UserID,
+		Kind:             usage.Kind,
+		LLMModel:         usage.Model,
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		TotalTokens:      usage.TotalTokens,
+	}
+}
+
+type usageRepo struct {
+	data *Data
+	log  *log.Helper
+}
+
+func NewUsageRepo(data *Data, logger log.Logger) biz.UsageRepo {
+	return &usageRepo{
+		data: data,
+		log:  log.NewHelper(logger),
+	}
+}
+
+func (r *usageRepo) Create(_ context.Context, usage *biz.Usage) error {
+	r.log.Debugf("Create usage: %v", usage)
+	tu := marshalTokenUsage(usage)
+	if err := r.data.db.Create(tu).Error; err != nil {
+		return err
+	}
+	usage.ID = tu.ID
+	usage.CreatedAt = tu.CreatedAt
+	return nil
+}
+
+// Total returns the tokens used by the user since the time
+func (r *usageRepo) Total(_ context.Context, userID uuid.UUID, since time.Time) (int64, error) {
+	r.log.Debugf("Total usage: %s since %s", userID, since)
+	var total int64
+	err := r.data.db.Model(&tokenUsage{}).
+		Select("COALESCE(SUM(total_tokens), 0)").
+		Where("user_id = ? AND created_at >= ?", userID, since).
+		Scan(&total).Error
+	return total, err
+}
+
+// Summary returns the tokens used by the user since the time grouped by request kind and model
+func (r *usageRepo) Summary(_ context.Context, userID uuid.UUID, since time.Time) ([]*biz.UsageSummary, error) {
+	r.log.Debugf("Summary usage: %s since %s", userID, since)
+	var summaries []*biz.UsageSummary
+	err := r.data.db.Model(&tokenUsage{}).
+		Select("kind, model, SUM(prompt_tokens) AS prompt_tokens, SUM(completion_tokens) AS completion_tokens, SUM(total_tokens) AS total_tokens").
+		Where("user_id = ? AND created_at >= ?", userID, since).
+		Group("kind, model").
+		Order("kind, model").
+		Scan(&summaries).Error
+	return summaries, err
+}
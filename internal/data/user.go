@@ -6,41 +6,75 @@ import (
 	"github.com/google/uuid"
 	"github.com/kdimtricp/aical/internal/biz"
 	"gorm.io/gorm"
+	"time"
 )
 
 //goland:noinspection GoUnnecessarilyExportedIdentifiers
 type User struct {
 	gorm.Model
-	ID           uuid.UUID `gorm:"column:id;primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
-	GoogleID     string
-	TGID         string
-	Name         string
-	Email        string
-	RefreshToken string
-	Calendars    []*calendar
+	ID                  uuid.UUID `gorm:"column:id;primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	GoogleID            string
+	TGID                string
+	Name                string
+	Email               string
+	RefreshToken        string
+	ReadOnly            bool
+	DisabledTools       []string `gorm:"serializer:json"`
+	Timezone            string
+	ConflictPolicy      string
+	Autopilot           bool
+	AutopilotCalendarID string
+	AutopilotPlannedAt  time.Time
+	ChangesMuted        bool
+	MutedCalendars      []string `gorm:"serializer:json"`
+	QuietHoursStart     int
+	QuietHoursEnd       int
+	Calendars           []*calendar
 }
 
 // biz returns biz user.
 func (u *User) biz() *biz.User {
 	return &biz.User{
-		ID:           u.ID,
-		GoogleID:     u.GoogleID,
-		TGID:         u.TGID,
-		Name:         u.Name,
-		Email:        u.Email,
-		RefreshToken: u.RefreshToken,
+		ID:                  u.ID,
+		GoogleID:            u.GoogleID,
+		TGID:                u.TGID,
+		Name:                u.Name,
+		Email:               u.Email,
+		RefreshToken:        u.RefreshToken,
+		ReadOnly:            u.ReadOnly,
+		DisabledTools:       u.DisabledTools,
+		Timezone:            u.Timezone,
+		ConflictPolicy:      u.ConflictPolicy,
+		Autopilot:           u.Autopilot,
+		AutopilotCalendarID: u.AutopilotCalendarID,
+		AutopilotPlannedAt:  u.AutopilotPlannedAt,
+		ChangesMuted:        u.ChangesMuted,
+		MutedCalendars:      u.MutedCalendars,
+		QuietHoursStart:     u.QuietHoursStart,
+		QuietHoursEnd:       u.QuietHoursEnd,
 	}
 }
 
 // parseUser fills user from biz user.
 func parseUser(bu *biz.User) *User {
 	return &User{
-		ID:           bu.ID,
-		GoogleID:     bu.GoogleID,
-		TGID:         bu.TGID,
-		Name:         bu.Name,
-		Email:        bu.Email,
-		RefreshToken: bu.RefreshToken,
+		ID:                  bu.ID,
+		GoogleID:            bu.GoogleID,
+		TGID:                bu.TGID,
+		Name:                bu.Name,
+		Email:               bu.Email,
+		RefreshToken:        bu.RefreshToken,
+		ReadOnly:            bu.ReadOnly,
+		DisabledTools:       bu.DisabledTools,
+		Timezone:            bu.Timezone,
+		ConflictPolicy:      bu.ConflictPolicy,
+		Autopilot:           bu.Autopilot,
+		AutopilotCalendarID: bu.AutopilotCalendarID,
+		AutopilotPlannedAt:  bu.AutopilotPlannedAt,
+		ChangesMuted:        bu.ChangesMuted,
+		MutedCalendars:      bu.MutedCalendars,
+		QuietHoursStart:     bu.QuietHoursStart,
+		QuietHoursEnd:       bu.QuietHoursEnd,
 	}
 }
 
@@ -95,3 +129,21 @@ func (r *UserRepo) List(_ context.Context) ([]*biz.User, error) {
 	}
 	return us.biz(), nil
 }
+
+// Update saves all user fields, including the zero ones
+func (r *UserRepo) Update(_ context.Context, user *biz.User) error {
+	r.log.Debugf("update u: %v", user.ID)
+	u := parseUser(user)
+	return r.data.db.Model(&User{}).Where("id = ?", user.ID).
+		Select("*").Omit("id", "created_at", "deleted_at", "Calendars").
+		Updates(u).Error
+}
+
+// SetAutopilotState updates only the autopilot columns of the user
+func (r *UserRepo) SetAutopilotState(_ context.Context, id uuid.UUID, calendarID string, plannedAt time.Time) error {
+	r.log.Debugf("set autopilot state u: %v", id)
+	return r.data.db.Model(&User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"autopilot_calendar_id": calendarID,
+		"autopilot_planned_at":  plannedAt,
+	}).Error
+}
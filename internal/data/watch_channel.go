@@ -0,0 +1,83 @@
+package data
+
+import (
+	"context"
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/google/uuid"
+	"github.com/kdimtricp/aical/internal/biz"
+	"gorm.io/gorm"
+	"time"
+)
+
+type watchChannel struct {
+	gorm.Model
+	ID         uuid.UUID `gorm:"type:uuid;primary_key"`
+	CalendarID uuid.UUID `gorm:"index"`
+	ResourceID string
+	Token      string
+	Expiration time.Time
+}
+
+func (c *watchChannel) biz() *biz.WatchChannel {
+	return &biz.WatchChannel{
+		ID:         c.ID,
+		CalendarID: c.CalendarID,
+		ResourceID: c.ResourceID,
+		Token:      c.Token,
+		Expiration: c.Expiration,
+	}
+}
+
+func marshalWatchChannel(bc *biz.WatchChannel) *watchChannel {
+	return &watchChannel{
+		ID:         bc.ID,
+		CalendarID: bc.CalendarID,
+		ResourceID: bc.ResourceID,
+		Token:      bc.Token,
+		Expiration: bc.Expiration,
+	}
+}
+
+type watchChannelRepo struct {
+	data *Data
+	log  *log.Helper
+}
+
+func NewWatchChannelRepo(data *Data, logger log.Logger) biz.WatchChannelRepo {
+	return &watchChannelRepo{
+		data: data,
+		log:  log.NewHelper(logger),
+	}
+}
+
+func (r *watchChannelRepo) Create(_ context.Context, channel *biz.WatchChannel) error {
+	r.log.Debugf("Create watch channel: %s", channel.ID)
+	return r.data.db.Create(marshalWatchChannel(channel)).Error
+}
+
+func (r *watchChannelRepo) Get(_ context.Context, id uuid.UUID) (*biz.WatchChannel, error) {
+	r.log.Debugf("Get watch channel: %s", id)
+	var c watchChannel
+	if err := r.data.db.Where("id = ?", id).First(&c).Error; err != nil {
+		return nil, err
+	}
+	return c.biz(), nil
+}
+
+func (r *watchChannelRepo) Delete(_ context.Context, id uuid.UUID) error {
+	r.log.Debugf("Delete watch channel: %s", id)
+	return r.data.db.Unscoped().Where("id = ?", id).Delete(&watchChannel{}).Error
+}
+
+func (r *watchChannelRepo) ListCalendarChannels(_ context.Context, calendarID uuid.UUID) ([]*biz.WatchChannel, error) {
+	r.log.Debugf("List watch channels of calendar: %s", calendarID)
+	var cs []*watchChannel
+	if err := r.data.db.Where("calendar_id = ?", calendarID).Order("expiration").Find(&cs).Error; err != nil {
+		return nil, err
+	}
+	channels := make([]*biz.WatchChannel, len(cs))
+	for i, c := range cs {
+		channels[i] = c.biz()
+	}
+	return channels, nil
+}
@@ -2,7 +2,6 @@ package server
 
 import (
 	"context"
-	"errors"
 	"github.com/go-kratos/kratos/v2/log"
 	"github.com/kdimtricp/aical/internal/conf"
 	"github.com/kdimtricp/aical/internal/service"
@@ -15,25 +14,20 @@ type CronServer struct {
 	log *log.Helper
 }
 
-func NewCronServer(c *conf.Cron, logger log.Logger, srvs *service.CronService) (*CronServer, error) {
-	srvs.Init()
+func NewCronServer(c *conf.Cron, logger log.Logger, _ *service.CronService, jobs *service.JobRegistry) (*CronServer, error) {
 	s := &CronServer{
 		c:   c,
 		crn: cron.New(),
 		log: log.NewHelper(log.With(logger, "module", "server/cron")),
 	}
-	for _, job := range c.Jobs {
-		j, ok := service.Jobs[job.Name]
-		if !ok {
-			s.log.Errorf("cron job: %s not found", job)
-			return nil, errors.New("cron job not found")
-		}
-		if id, err := s.crn.AddFunc(job.Schedule, j); err != nil {
-			s.log.Errorf("cron job: %s add failed: %v", job, err)
-			return nil, err
-		} else {
-			s.log.Debugf("cron job: %s added, id: %d", job, id)
-		}
+	scheduled, err := jobs.Scheduled()
+	if err != nil {
+		s.log.Errorf("cron jobs: %v", err)
+		return nil, err
+	}
+	for _, job := range scheduled {
+		id := s.crn.Schedule(job.Schedule, cron.FuncJob(job.Run))
+		s.log.Debugf("cron job: %s added, id: %d", job.Name, id)
 	}
 	return s, nil
 }
@@ -5,11 +5,17 @@ import (
 	"github.com/go-kratos/kratos/v2/middleware/logging"
 	"github.com/go-kratos/kratos/v2/middleware/recovery"
 	"github.com/go-kratos/kratos/v2/transport/grpc"
+	adminpb "github.com/kdimtricp/aical/api/admin/v1"
+	chatpb "github.com/kdimtricp/aical/api/chat/v1"
 	"github.com/kdimtricp/aical/internal/conf"
+	"github.com/kdimtricp/aical/internal/service"
 )
 
 // NewGRPCServer new a gRPC server.
-func NewGRPCServer(c *conf.Server, logger log.Logger) *grpc.Server {
+func NewGRPCServer(c *conf.Server, logger log.Logger,
+	chat *service.ChatService,
+	admin *service.AdminService,
+) *grpc.Server {
 	var opts = []grpc.ServerOption{
 		grpc.Middleware(
 			logging.Server(logger),
@@ -26,5 +32,7 @@ func NewGRPCServer(c *conf.Server, logger log.Logger) *grpc.Server {
 		opts = append(opts, grpc.Timeout(c.Grpc.Timeout.AsDuration()))
 	}
 	srv := grpc.NewServer(opts...)
+	chatpb.RegisterChatServer(srv, chat)
+	adminpb.RegisterAdminServiceServer(srv, admin)
 	return srv
 }
@@ -1,14 +1,17 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"github.com/go-kratos/kratos/v2/log"
 	"github.com/go-kratos/kratos/v2/middleware/logging"
 	"github.com/go-kratos/kratos/v2/middleware/recovery"
 	"github.com/go-kratos/kratos/v2/transport/http"
+	adminpb "github.com/kdimtricp/aical/api/admin/v1"
 	authpb "github.com/kdimtricp/aical/api/auth/v1"
 	chatpb "github.com/kdimtricp/aical/api/chat/v1"
 	userpb "github.com/kdimtricp/aical/api/user/v1"
+	"github.com/kdimtricp/aical/internal/biz"
 	"github.com/kdimtricp/aical/internal/conf"
 	"github.com/kdimtricp/aical/internal/service"
 	shttp "net/http"
@@ -19,6 +22,8 @@ func NewHTTPServer(c *conf.Server, logger log.Logger,
 	auth *service.AuthService,
 	user *service.UserService,
 	chat *service.ChatService,
+	cron *service.CronService,
+	admin *service.AdminService,
 ) *http.Server {
 	var opts = []http.ServerOption{
 		http.Middleware(
@@ -40,6 +45,8 @@ func NewHTTPServer(c *conf.Server, logger log.Logger,
 	chatpb.RegisterChatHTTPServer(srv, chat)
 	authpb.RegisterAuthServiceHTTPServer(srv, auth)
 	userpb.RegisterUserServiceHTTPServer(srv, user)
+	adminpb.RegisterAdminServiceHTTPServer(srv, admin)
+	srv.HandleFunc(WATCH_WEBHOOK_PATH, watchWebhook(cron))
 	srv.HandleFunc("/", func(w shttp.ResponseWriter, r *shttp.Request) {
 		shttp.Redirect(w, r, "login", shttp.StatusTemporaryRedirect)
 	})
@@ -52,6 +59,35 @@ const GG_CALENDAR_URL = "https://calendar.google.com/calendar/u/0/r"
 //goland:noinspection ALL
 const USER_URL_PATH = "/user"
 
+// WATCH_WEBHOOK_PATH receives the Google Calendar push notifications
+//
+//goland:noinspection ALL
+const WATCH_WEBHOOK_PATH = "/webhook/google/calendar"
+
+// watchWebhook acknowledges the push notifications of the watched calendars.
+// The notification has no body, the channel and the change are sent in the headers.
+func watchWebhook(cron *service.CronService) shttp.HandlerFunc {
+	return func(w shttp.ResponseWriter, r *shttp.Request) {
+		if r.Method != shttp.MethodPost {
+			w.WriteHeader(shttp.StatusMethodNotAllowed)
+			return
+		}
+		err := cron.HandleWatchNotification(r.Context(),
+			r.Header.Get("X-Goog-Channel-ID"),
+			r.Header.Get("X-Goog-Channel-Token"),
+			r.Header.Get("X-Goog-Resource-State"),
+		)
+		switch {
+		case errors.Is(err, biz.ErrInvalidWatchChannel):
+			w.WriteHeader(shttp.StatusForbidden)
+		case err != nil:
+			w.WriteHeader(shttp.StatusInternalServerError)
+		default:
+			w.WriteHeader(shttp.StatusOK)
+		}
+	}
+}
+
 // responseFunc redirects State request to url generated from oauth2config
 // and Callback request to root url.
 //
@@ -3,11 +3,26 @@ package server
 import (
 	"context"
 	"fmt"
+	"github.com/go-kratos/kratos/v2/errors"
 	"github.com/go-kratos/kratos/v2/log"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-	"github.com/kdimtricp/aical/internal/conf"
+	chatpb "github.com/kdimtricp/aical/api/chat/v1"
+	"github.com/kdimtricp/aical/internal/biz"
 	"github.com/kdimtricp/aical/internal/service"
+	"strconv"
 	"strings"
+	"time"
+)
+
+//goland:noinspection ALL
+const (
+	TG_PLACEHOLDER   = "…"
+	TG_FAILED_ANSWER = "Sorry, I could not answer that."
+	// TG_EDIT_INTERVAL limits how often a streamed answer is edited, Telegram throttles frequent edits
+	TG_EDIT_INTERVAL = time.Second
+
+	TG_BUTTON_CONFIRM = biz.PENDING_ACTION_CONFIRM
+	TG_BUTTON_CANCEL  = biz.PENDING_ACTION_CANCEL
 )
 
 type TGServer struct {
@@ -17,19 +32,13 @@ type TGServer struct {
 	chat *service.ChatService
 }
 
-func NewTGServer(c *conf.Server, logger log.Logger, _ *service.TGService, auth *service.AuthService, chat *service.ChatService) (*TGServer, error) {
-	bot, err := tgbotapi.NewBotAPI(c.Tg.Token)
-	if err != nil {
-		return nil, err
-	}
-	bot.Debug = false
-
+func NewTGServer(logger log.Logger, bot *tgbotapi.BotAPI, _ *service.TGService, auth *service.AuthService, chat *service.ChatService) *TGServer {
 	return &TGServer{
 		log:  log.NewHelper(log.With(logger, "module", "server/tgs")),
 		bot:  bot,
 		auth: auth,
 		chat: chat,
-	}, nil
+	}
 }
 
 func (s *TGServer) Start(ctx context.Context) error {
@@ -66,44 +75,196 @@ func (s *TGServer) handleUpdate(ctx context.Context, update tgbotapi.Update) {
 
 	// Handle button clicks
 	case update.CallbackQuery != nil:
-		s.handleButton(update.CallbackQuery)
+		s.handleButton(ctx, update.CallbackQuery)
 		break
 	}
 }
 
 func (s *TGServer) handleMessage(ctx context.Context, message *tgbotapi.Message) {
 	s.log.Infof("Message: %s", message.Text)
-	if strings.HasPrefix(message.Text, "/login") {
-		url, err := s.auth.AuthWithID(ctx, message.From.ID)
-		if err != nil {
-			s.log.Errorf("tg user auth error: %s", err.Error())
+	if message.IsCommand() {
+		if err := s.handleCommand(ctx, message); err != nil {
+			s.log.Errorf("handling tg command %s error: %s", message.Command(), err.Error())
 		}
-		if _, err := s.bot.Send(tgbotapi.NewMessage(message.Chat.ID, url)); err != nil {
-			s.log.Errorf("sending url for user login  error: %s", err.Error())
+		return
+	}
+	reply, err := s.bot.Send(tgbotapi.NewMessage(message.Chat.ID, TG_PLACEHOLDER))
+	if err != nil {
+		s.log.Errorf("sending tg chat placeholder error: %s,", err.Error())
+		return
+	}
+	var (
+		text     strings.Builder
+		shown    string
+		lastEdit time.Time
+	)
+	// edit replaces the reply text, Telegram rejects edits that do not change the text
+	edit := func(content string) {
+		if content == "" || content == shown {
+			return
 		}
-	} else {
-		answer, err := s.chat.TGChat(ctx, fmt.Sprintf("%d", message.From.ID), message.Text)
-		if err != nil {
-			s.log.Errorf("getting tg chat answer error: %s", err.Error())
+		if _, err := s.bot.Send(tgbotapi.NewEditMessageText(message.Chat.ID, reply.MessageID, content)); err != nil {
+			s.log.Errorf("editing tg chat answer error: %s,", err.Error())
+			return
 		}
-		if _, err := s.bot.Send(tgbotapi.NewMessage(message.Chat.ID, answer)); err != nil {
-			s.log.Errorf("sending tg chat answer error: %s,", err.Error())
+		shown = content
+		lastEdit = time.Now()
+	}
+	var answer string
+	chatReply, err := s.chat.TGChatStream(ctx, fmt.Sprintf("%d", message.From.ID), message.Text, func(delta string) {
+		text.WriteString(delta)
+		if time.Since(lastEdit) >= TG_EDIT_INTERVAL {
+			edit(text.String())
 		}
+	})
+	if err != nil {
+		s.log.Errorf("getting tg chat answer error: %s", err.Error())
+		answer = userErrorMessage(err)
+	} else {
+		answer = chatReply.Answer
+	}
+	if answer == "" {
+		answer = TG_FAILED_ANSWER
+	}
+	edit(answer)
+	if chatReply != nil {
+		s.sendPendingActions(message.Chat.ID, chatReply.PendingActions)
+	}
+}
+
+// sendPendingActions sends the preview of every pending action with Confirm and Cancel buttons
+func (s *TGServer) sendPendingActions(chatID int64, actions []*biz.PendingAction) {
+	for _, action := range actions {
+		msg := tgbotapi.NewMessage(chatID, action.Preview)
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Confirm", fmt.Sprintf("%s:%s", TG_BUTTON_CONFIRM, action.ID)),
+			tgbotapi.NewInlineKeyboardButtonData("Cancel", fmt.Sprintf("%s:%s", TG_BUTTON_CANCEL, action.ID)),
+		))
+		if _, err := s.bot.Send(msg); err != nil {
+			s.log.Errorf("sending tg pending action error: %s", err.Error())
+		}
+	}
+}
+
+// userErrorMessage returns the message of chat API errors, other errors are not shown to the user
+func userErrorMessage(err error) string {
+	e := errors.FromError(err)
+	if _, ok := chatpb.ErrorReason_value[e.Reason]; ok {
+		return e.Message
 	}
+	return TG_FAILED_ANSWER
 }
 
-func (s *TGServer) handleButton(callback *tgbotapi.CallbackQuery) {
+func (s *TGServer) handleButton(ctx context.Context, callback *tgbotapi.CallbackQuery) {
 	s.log.Infof("Button: %s", callback.Data)
+	button, actionID, _ := strings.Cut(callback.Data, ":")
+	tgID := fmt.Sprintf("%d", callback.From.ID)
+	var (
+		preview string
+		status  string
+		err     error
+	)
+	switch button {
+	case TG_BUTTON_CONFIRM:
+		preview, err = s.chat.TGConfirmAction(ctx, tgID, actionID)
+		status = "Confirmed"
+	case TG_BUTTON_CANCEL:
+		preview, err = s.chat.TGRejectAction(ctx, tgID, actionID)
+		status = "Cancelled"
+	default:
+		s.log.Infof("Unknown button: %s", callback.Data)
+		return
+	}
+	if err != nil {
+		s.log.Errorf("handling tg button %s error: %s", callback.Data, err.Error())
+		status = userErrorMessage(err)
+	}
+	if _, err := s.bot.Request(tgbotapi.NewCallback(callback.ID, status)); err != nil {
+		s.log.Errorf("answering tg callback error: %s", err.Error())
+	}
+	if callback.Message == nil {
+		return
+	}
+	// replace the buttons with the outcome, so the action can not be pressed again
+	text := status
+	if preview != "" {
+		text = fmt.Sprintf("%s: %s", status, preview)
+	} else if callback.Message.Text != "" {
+		text = fmt.Sprintf("%s\n\n%s", callback.Message.Text, status)
+	}
+	if _, err := s.bot.Send(tgbotapi.NewEditMessageText(callback.Message.Chat.ID, callback.Message.MessageID, text)); err != nil {
+		s.log.Errorf("editing tg pending action error: %s", err.Error())
+	}
 }
 
-func (s *TGServer) handleCommand(command string) error {
+func (s *TGServer) handleCommand(ctx context.Context, message *tgbotapi.Message) error {
 	var err error
+	var reply string
 
-	switch command {
-	case "/login":
-		break
+	switch message.Command() {
+	case "login":
+		reply, err = s.auth.AuthWithID(ctx, message.From.ID)
+	case "reset":
+		reply = "Conversation has been reset."
+		if err = s.chat.TGReset(ctx, fmt.Sprintf("%d", message.From.ID)); err != nil {
+			reply = "Failed to reset conversation."
+		}
+	case "usage":
+		if reply, err = s.chat.TGUsage(ctx, fmt.Sprintf("%d", message.From.ID)); err != nil {
+			reply = "Failed to get usage."
+		}
+	case "undo":
+		// /undo [n] reverts the n latest changes of the assistant
+		count := 1
+		if args := strings.TrimSpace(message.CommandArguments()); args != "" {
+			if count, err = strconv.Atoi(args); err != nil {
+				reply = "Usage: /undo [number of changes]"
+				break
+			}
+		}
+		if reply, err = s.chat.TGUndo(ctx, fmt.Sprintf("%d", message.From.ID), count); err != nil {
+			reply = userErrorMessage(err)
+		}
+	case "timezone":
+		// /timezone shows the timezone, /timezone Asia/Tokyo changes it
+		timezone := strings.TrimSpace(message.CommandArguments())
+		if reply, err = s.chat.TGTimezone(ctx, fmt.Sprintf("%d", message.From.ID), timezone); err != nil {
+			reply = userErrorMessage(err)
+		}
+	case "conflicts":
+		// /conflicts shows the policy for overlapping events, /conflicts block|warn|allow changes it
+		policy := strings.TrimSpace(message.CommandArguments())
+		if reply, err = s.chat.TGConflictPolicy(ctx, fmt.Sprintf("%d", message.From.ID), policy); err != nil {
+			reply = userErrorMessage(err)
+		}
+	case "autopilot":
+		// /autopilot shows the state, /autopilot on|off changes it
+		mode := strings.TrimSpace(message.CommandArguments())
+		if mode != "" && mode != "on" && mode != "off" {
+			reply = "Usage: /autopilot [on|off]"
+			break
+		}
+		if reply, err = s.chat.TGAutopilot(ctx, fmt.Sprintf("%d", message.From.ID), mode); err != nil {
+			reply = userErrorMessage(err)
+		}
+	case "changes":
+		// /changes shows the change notifications, /changes on|off, quiet 22-7|off and mute|unmute <calendar> change them
+		setting, value, _ := strings.Cut(strings.TrimSpace(message.CommandArguments()), " ")
+		value = strings.TrimSpace(value)
+		toggle := setting == "" || setting == "on" || setting == "off"
+		if !toggle && (value == "" || setting != "quiet" && setting != "mute" && setting != "unmute") {
+			reply = "Usage: /changes [on|off], /changes quiet <start-end hours>|off, /changes mute|unmute <calendar name>"
+			break
+		}
+		if reply, err = s.chat.TGChangeNotifications(ctx, fmt.Sprintf("%d", message.From.ID), setting, value); err != nil {
+			reply = userErrorMessage(err)
+		}
 	default:
-		s.log.Infof("Unknown command: %s", command)
+		s.log.Infof("Unknown command: %s", message.Command())
+		return nil
+	}
+	if _, err := s.bot.Send(tgbotapi.NewMessage(message.Chat.ID, reply)); err != nil {
+		s.log.Errorf("sending tg command reply error: %s", err.Error())
 	}
 	return err
 }
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/go-kratos/kratos/v2/transport"
+	"github.com/kdimtricp/aical/internal/conf"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"strings"
+
+	pb "github.com/kdimtricp/aical/api/admin/v1"
+)
+
+//goland:noinspection ALL
+const ADMIN_TOKEN_PREFIX = "Bearer "
+
+type AdminService struct {
+	c    *conf.Server
+	cron *CronService
+	jobs *JobRegistry
+	log  *log.Helper
+	pb.UnimplementedAdminServiceServer
+}
+
+func NewAdminService(c *conf.Server, cron *CronService, jobs *JobRegistry, logger log.Logger) *AdminService {
+	return &AdminService{
+		c:    c,
+		cron: cron,
+		jobs: jobs,
+		log:  log.NewHelper(log.With(logger, "module", "service/admin")),
+	}
+}
+
+// authorize checks the bearer token of the request, the API is closed when no token is configured
+func (s *AdminService) authorize(ctx context.Context) error {
+	token := s.c.GetAdmin().GetToken()
+	tr, ok := transport.FromServerContext(ctx)
+	if token == "" || !ok {
+		return pb.ErrorAdminUnauthorized("admin API is disabled")
+	}
+	got, found := strings.CutPrefix(tr.RequestHeader().Get("Authorization"), ADMIN_TOKEN_PREFIX)
+	if !found || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+		return pb.ErrorAdminUnauthorized("invalid admin token")
+	}
+	return nil
+}
+
+func (s *AdminService) GetSyncReport(ctx context.Context, _ *pb.GetSyncReportRequest) (*pb.GetSyncReportResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	report := s.cron.SyncReport()
+	if report == nil {
+		return nil, pb.ErrorSyncReportNotFound("the sync loop has not finished yet")
+	}
+	r := &pb.GetSyncReportResponse{
+		StartedAt:  timestamppb.New(report.StartedAt),
+		FinishedAt: timestamppb.New(report.FinishedAt),
+		Succeeded:  int32(report.Succeeded),
+		Failed:     int32(report.Failed),
+		Skipped:    int32(report.Skipped),
+		Users:      make([]*pb.UserSyncResult, len(report.Users)),
+	}
+	for i, u := range report.Users {
+		r.Users[i] = &pb.UserSyncResult{
+			UserId:     u.UserID.String(),
+			Status:     u.Status,
+			Error:      u.Error,
+			Failures:   int32(u.Failures),
+			DurationMs: u.Duration.Milliseconds(),
+		}
+		if !u.NextAttempt.IsZero() {
+			r.Users[i].NextAttempt = timestamppb.New(u.NextAttempt)
+		}
+	}
+	return r, nil
+}
+
+func (s *AdminService) ListJobs(ctx context.Context, _ *pb.ListJobsRequest) (*pb.ListJobsResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	statuses := s.jobs.Jobs()
+	r := &pb.ListJobsResponse{Jobs: make([]*pb.Job, len(statuses))}
+	for i, status := range statuses {
+		r.Jobs[i] = jobStatus(status)
+	}
+	return r, nil
+}
+
+func (s *AdminService) TriggerJob(ctx context.Context, req *pb.TriggerJobRequest) (*pb.Job, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	s.log.Infof("TriggerJob request: %s", req.Name)
+	status, err := s.jobs.Trigger(req.Name)
+	switch {
+	case errors.Is(err, ErrJobNotFound):
+		return nil, pb.ErrorJobNotFound("job %q is not registered", req.Name)
+	case errors.Is(err, ErrJobRunning):
+		return nil, pb.ErrorJobRunning("job %q is running", req.Name)
+	case err != nil:
+		return nil, err
+	}
+	return jobStatus(status), nil
+}
+
+func jobStatus(status *JobStatus) *pb.Job {
+	job := &pb.Job{
+		Name:       status.Name,
+		Schedule:   status.Schedule,
+		Enabled:    status.Enabled,
+		Running:    status.Running,
+		LastStatus: status.LastStatus,
+		LastError:  status.LastError,
+		Runs:       status.Runs,
+	}
+	if !status.LastStart.IsZero() {
+		job.LastStart = timestamppb.New(status.LastStart)
+	}
+	if !status.LastEnd.IsZero() {
+		job.LastEnd = timestamppb.New(status.LastEnd)
+	}
+	return job
+}
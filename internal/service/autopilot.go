@@ -0,0 +1,29 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/kdimtricp/aical/internal/biz"
+)
+
+// autopilot plans around the calendar changes of the users who turned the autopilot on
+// and sends them the proposed events to confirm
+func (s *CronService) autopilot(ctx context.Context) error {
+	return s.notifyUsers(ctx, JOB_AUTOPILOT, func(user *biz.User) (*biz.Notification, error) {
+		if !user.Autopilot || user.ReadOnly {
+			return nil, nil
+		}
+		token, err := s.guc.TokenSource(ctx, user.RefreshToken)
+		if err != nil {
+			return nil, fmt.Errorf("get token: %w", err)
+		}
+		actions, err := s.apuc.Plan(biz.SetUser(biz.SetToken(ctx, token), user), user)
+		if err != nil || len(actions) == 0 {
+			return nil, err
+		}
+		return &biz.Notification{
+			Text:    fmt.Sprintf("Autopilot planned %d event(s) after your recent calendar changes. Confirm the ones to add to the %q calendar:", len(actions), biz.AUTOPILOT_CALENDAR_NAME),
+			Actions: actions,
+		}, nil
+	})
+}
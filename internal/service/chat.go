@@ -2,26 +2,34 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"github.com/go-kratos/kratos/v2/log"
+	"github.com/google/uuid"
 	"github.com/kdimtricp/aical/internal/biz"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"strings"
 
 	pb "github.com/kdimtricp/aical/api/chat/v1"
 )
 
 type ChatService struct {
-	uc  *biz.ChatUseCase
-	guc *biz.GoogleUseCase
-	uuc *biz.UserUseCase
-	log *log.Helper
+	uc   *biz.ChatUseCase
+	ucu  *biz.UndoUseCase
+	guc  *biz.GoogleUseCase
+	uuc  *biz.UserUseCase
+	cnuc *biz.ChangeNotificationUseCase
+	log  *log.Helper
 	pb.UnimplementedChatServer
 }
 
-func NewChatService(uc *biz.ChatUseCase, guc *biz.GoogleUseCase, uuc *biz.UserUseCase, logger log.Logger) *ChatService {
+func NewChatService(uc *biz.ChatUseCase, ucu *biz.UndoUseCase, guc *biz.GoogleUseCase, uuc *biz.UserUseCase, cnuc *biz.ChangeNotificationUseCase, logger log.Logger) *ChatService {
 	return &ChatService{
-		uc:  uc,
-		guc: guc,
-		uuc: uuc,
-		log: log.NewHelper(logger),
+		uc:   uc,
+		ucu:  ucu,
+		guc:  guc,
+		uuc:  uuc,
+		cnuc: cnuc,
+		log:  log.NewHelper(logger),
 	}
 }
 
@@ -37,29 +45,359 @@ func (s *ChatService) UserChat(ctx context.Context, req *pb.UserChatRequest) (*p
 		return nil, err
 	}
 	ctx = biz.SetToken(ctx, token)
-	answer, err := s.uc.UserChat(ctx, req.Question)
+	reply, err := s.uc.UserChat(ctx, user, req.Question)
 	if err != nil {
 		return nil, err
 	}
-	r := &pb.UserChatResponse{Answer: answer}
+	r := &pb.UserChatResponse{
+		Answer:         reply.Answer,
+		PendingActions: pendingActions(reply.PendingActions),
+	}
 	s.log.Debugf("UserChat reply: %v", r)
 	return r, nil
 }
 
-func (s *ChatService) TGChat(ctx context.Context, tguserID string, message string) (string, error) {
+func (s *ChatService) UserChatStream(req *pb.UserChatRequest, stream pb.Chat_UserChatStreamServer) error {
+	s.log.Debugf("UserChatStream request: %v", req)
+	ctx := stream.Context()
+	user, err := s.uuc.GetUserByID(ctx, req.UserId)
+	if err != nil {
+		return err
+	}
+	token, err := s.guc.TokenSource(ctx, user.RefreshToken)
+	if err != nil {
+		s.log.Errorf("chat stream: get token failed: %v", err)
+		return err
+	}
+	ctx = biz.SetToken(ctx, token)
+	reply, err := s.uc.UserChatStream(ctx, user, req.Question, func(delta string) {
+		if err := stream.Send(&pb.UserChatStreamResponse{Delta: delta}); err != nil {
+			s.log.Errorf("chat stream: send delta failed: %v", err)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return stream.Send(&pb.UserChatStreamResponse{
+		Answer:         reply.Answer,
+		Done:           true,
+		PendingActions: pendingActions(reply.PendingActions),
+	})
+}
+
+func (s *ChatService) TGChatStream(ctx context.Context, tguserID string, message string, handler func(delta string)) (*biz.ChatReply, error) {
+	user, err := s.uuc.GetUserByTGID(ctx, tguserID)
+	if err != nil {
+		return nil, err
+	}
+	token, err := s.guc.TokenSource(ctx, user.RefreshToken)
+	if err != nil {
+		s.log.Errorf("tg chat stream: get token failed: %v", err)
+		return nil, err
+	}
+	ctx = biz.SetToken(ctx, token)
+	return s.uc.UserChatStream(ctx, user, message, handler)
+}
+
+func (s *ChatService) ResetChat(ctx context.Context, req *pb.ResetChatRequest) (*pb.ResetChatResponse, error) {
+	s.log.Debugf("ResetChat request: %v", req)
+	user, err := s.uuc.GetUserByID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.uc.ResetConversation(ctx, user); err != nil {
+		return nil, err
+	}
+	return &pb.ResetChatResponse{}, nil
+}
+
+func (s *ChatService) TGReset(ctx context.Context, tguserID string) error {
+	user, err := s.uuc.GetUserByTGID(ctx, tguserID)
+	if err != nil {
+		return err
+	}
+	return s.uc.ResetConversation(ctx, user)
+}
+
+func (s *ChatService) GetUsage(ctx context.Context, req *pb.GetUsageRequest) (*pb.GetUsageResponse, error) {
+	s.log.Debugf("GetUsage request: %v", req)
+	user, err := s.uuc.GetUserByID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	report, err := s.uc.Usage(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	r := &pb.GetUsageResponse{
+		DailyTokens:   report.DailyTokens,
+		MonthlyTokens: report.MonthlyTokens,
+		DailyQuota:    report.DailyQuota,
+		MonthlyQuota:  report.MonthlyQuota,
+		Summaries:     make([]*pb.UsageSummary, len(report.Summaries)),
+	}
+	for i, summary := range report.Summaries {
+		r.Summaries[i] = &pb.UsageSummary{
+			Kind:             summary.Kind,
+			Model:            summary.Model,
+			PromptTokens:     summary.PromptTokens,
+			CompletionTokens: summary.CompletionTokens,
+			TotalTokens:      summary.TotalTokens,
+		}
+	}
+	return r, nil
+}
+
+func (s *ChatService) TGUsage(ctx context.Context, tguserID string) (string, error) {
+	user, err := s.uuc.GetUserByTGID(ctx, tguserID)
+	if err != nil {
+		return "", err
+	}
+	report, err := s.uc.Usage(ctx, user)
+	if err != nil {
+		return "", err
+	}
+	return report.String(), nil
+}
+
+func (s *ChatService) ListTools(ctx context.Context, req *pb.ListToolsRequest) (*pb.ListToolsResponse, error) {
+	s.log.Debugf("ListTools request: %v", req)
+	user, err := s.uuc.GetUserByID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	return toolsResponse(user, s.uc.ListTools(ctx, user)), nil
+}
+
+func (s *ChatService) UpdateTools(ctx context.Context, req *pb.UpdateToolsRequest) (*pb.ListToolsResponse, error) {
+	s.log.Debugf("UpdateTools request: %v", req)
+	user, err := s.uuc.GetUserByID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	states, err := s.uc.UpdateTools(ctx, user, req.ReadOnly, req.DisabledTools, req.ConflictPolicy)
+	if err != nil {
+		return nil, err
+	}
+	return toolsResponse(user, states), nil
+}
+
+func toolsResponse(user *biz.User, states []*biz.ToolState) *pb.ListToolsResponse {
+	policy, _ := biz.ParseConflictPolicy(user.ConflictPolicy)
+	r := &pb.ListToolsResponse{
+		ReadOnly:       user.ReadOnly,
+		Tools:          make([]*pb.Tool, len(states)),
+		ConflictPolicy: string(policy),
+	}
+	for i, state := range states {
+		r.Tools[i] = &pb.Tool{
+			Name:        state.Name,
+			Description: state.Description,
+			ReadOnly:    state.ReadOnly,
+			Enabled:     state.Enabled,
+			Confirm:     state.Confirm,
+		}
+	}
+	return r
+}
+
+func pendingActions(actions []*biz.PendingAction) []*pb.PendingAction {
+	r := make([]*pb.PendingAction, len(actions))
+	for i, action := range actions {
+		r[i] = &pb.PendingAction{
+			Id:        action.ID.String(),
+			Function:  action.Function,
+			Preview:   action.Preview,
+			ExpiresAt: timestamppb.New(action.ExpiresAt),
+		}
+	}
+	return r
+}
+
+func (s *ChatService) ConfirmAction(ctx context.Context, req *pb.PendingActionRequest) (*pb.PendingActionResponse, error) {
+	s.log.Debugf("ConfirmAction request: %v", req)
+	user, err := s.uuc.GetUserByID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	preview, err := s.confirmAction(ctx, user, req.ActionId)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.PendingActionResponse{Preview: preview}, nil
+}
+
+func (s *ChatService) RejectAction(ctx context.Context, req *pb.PendingActionRequest) (*pb.PendingActionResponse, error) {
+	s.log.Debugf("RejectAction request: %v", req)
+	user, err := s.uuc.GetUserByID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	actionID, err := uuid.Parse(req.ActionId)
+	if err != nil {
+		return nil, err
+	}
+	preview, err := s.uc.RejectAction(ctx, user, actionID)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.PendingActionResponse{Preview: preview}, nil
+}
+
+func (s *ChatService) TGConfirmAction(ctx context.Context, tguserID string, actionID string) (string, error) {
 	user, err := s.uuc.GetUserByTGID(ctx, tguserID)
 	if err != nil {
 		return "", err
 	}
+	return s.confirmAction(ctx, user, actionID)
+}
+
+func (s *ChatService) TGRejectAction(ctx context.Context, tguserID string, actionID string) (string, error) {
+	user, err := s.uuc.GetUserByTGID(ctx, tguserID)
+	if err != nil {
+		return "", err
+	}
+	id, err := uuid.Parse(actionID)
+	if err != nil {
+		return "", err
+	}
+	return s.uc.RejectAction(ctx, user, id)
+}
+
+// confirmAction executes the pending action with the google token of the user
+func (s *ChatService) confirmAction(ctx context.Context, user *biz.User, actionID string) (string, error) {
+	id, err := uuid.Parse(actionID)
+	if err != nil {
+		return "", err
+	}
 	token, err := s.guc.TokenSource(ctx, user.RefreshToken)
 	if err != nil {
-		s.log.Errorf("cron job:sync loop: get token failed: %v", err)
+		s.log.Errorf("confirm action: get token failed: %v", err)
 		return "", err
 	}
-	ctx = biz.SetToken(ctx, token)
-	answer, err := s.uc.UserChat(ctx, message)
+	return s.uc.ConfirmAction(biz.SetToken(ctx, token), user, id)
+}
+
+func (s *ChatService) UndoLastChange(ctx context.Context, req *pb.UndoLastChangeRequest) (*pb.UndoLastChangeResponse, error) {
+	s.log.Debugf("UndoLastChange request: %v", req)
+	user, err := s.uuc.GetUserByID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	reverted, err := s.undo(ctx, user, int(req.Count))
+	if err != nil {
+		return nil, err
+	}
+	return &pb.UndoLastChangeResponse{Reverted: reverted}, nil
+}
+
+func (s *ChatService) TGUndo(ctx context.Context, tguserID string, count int) (string, error) {
+	user, err := s.uuc.GetUserByTGID(ctx, tguserID)
+	if err != nil {
+		return "", err
+	}
+	reverted, err := s.undo(ctx, user, count)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Undone:\n%s", strings.Join(reverted, "\n")), nil
+}
+
+// undo reverts the latest assistant changes with the google token of the user
+func (s *ChatService) undo(ctx context.Context, user *biz.User, count int) ([]string, error) {
+	token, err := s.guc.TokenSource(ctx, user.RefreshToken)
+	if err != nil {
+		s.log.Errorf("undo: get token failed: %v", err)
+		return nil, err
+	}
+	return s.ucu.UndoLastChanges(biz.SetToken(ctx, token), user, count)
+}
+
+// TGTimezone sets the time zone of the user if it is given and returns the current one
+func (s *ChatService) TGTimezone(ctx context.Context, tguserID string, timezone string) (string, error) {
+	user, err := s.uuc.GetUserByTGID(ctx, tguserID)
+	if err != nil {
+		return "", err
+	}
+	if timezone != "" {
+		if err := s.uuc.SetTimezone(ctx, user, timezone); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("Your timezone is %s.", user.Location()), nil
+}
+
+// TGConflictPolicy sets the conflict policy of the user if it is given and returns the current one
+func (s *ChatService) TGConflictPolicy(ctx context.Context, tguserID string, policy string) (string, error) {
+	user, err := s.uuc.GetUserByTGID(ctx, tguserID)
+	if err != nil {
+		return "", err
+	}
+	if policy != "" {
+		if err := s.uuc.SetConflictPolicy(ctx, user, policy); err != nil {
+			return "", err
+		}
+	}
+	current, _ := biz.ParseConflictPolicy(user.ConflictPolicy)
+	return fmt.Sprintf("Overlapping events: %s.", current), nil
+}
+
+// TGAutopilot turns the autopilot of the user on or off if the mode is given and returns the current state
+func (s *ChatService) TGAutopilot(ctx context.Context, tguserID string, mode string) (string, error) {
+	user, err := s.uuc.GetUserByTGID(ctx, tguserID)
+	if err != nil {
+		return "", err
+	}
+	if mode != "" {
+		if err := s.uuc.SetAutopilot(ctx, user, mode == "on"); err != nil {
+			return "", err
+		}
+	}
+	if !user.Autopilot {
+		return "Autopilot is off.", nil
+	}
+	if user.ReadOnly {
+		return "Autopilot is on, but it plans nothing while your calendars are read-only.", nil
+	}
+	return fmt.Sprintf("Autopilot is on. After your calendar changes I propose events for the %q calendar, "+
+		"they are added only when you confirm them.", biz.AUTOPILOT_CALENDAR_NAME), nil
+}
+
+// TGChangeNotifications applies the setting of the change notifications and returns the current ones.
+// The setting is on, off, quiet with the hours, or mute and unmute with the calendar name, no setting shows them.
+func (s *ChatService) TGChangeNotifications(ctx context.Context, tguserID string, setting string, value string) (string, error) {
+	user, err := s.uuc.GetUserByTGID(ctx, tguserID)
+	if err != nil {
+		return "", err
+	}
+	switch setting {
+	case "on", "off":
+		err = s.uuc.SetChangeNotifications(ctx, user, setting == "on")
+	case "quiet":
+		err = s.uuc.SetQuietHours(ctx, user, value)
+	case "mute", "unmute":
+		_, err = s.cnuc.MuteCalendar(ctx, user, value, setting == "mute")
+	}
 	if err != nil {
 		return "", err
 	}
-	return answer, nil
+	if user.ChangesMuted {
+		return "Change notifications are off.", nil
+	}
+	lines := []string{"Change notifications are on: new invitations, moved and cancelled meetings."}
+	if user.QuietHoursStart != user.QuietHoursEnd {
+		lines = append(lines, fmt.Sprintf("Quiet hours: %d:00–%d:00.", user.QuietHoursStart, user.QuietHoursEnd))
+	}
+	muted, err := s.cnuc.MutedCalendars(ctx, user)
+	if err != nil {
+		return "", err
+	}
+	if len(muted) > 0 {
+		names := make([]string, len(muted))
+		for i, c := range muted {
+			names[i] = c.Summary
+		}
+		lines = append(lines, fmt.Sprintf("Muted calendars: %s.", strings.Join(names, ", ")))
+	}
+	return strings.Join(lines, "\n"), nil
 }
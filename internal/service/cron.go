@@ -2,110 +2,255 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/go-kratos/kratos/v2/log"
 	"github.com/kdimtricp/aical/internal/biz"
 	"github.com/kdimtricp/aical/internal/conf"
+	"sync"
 	"time"
 )
 
 type CronService struct {
 	c        *conf.Cron
+	g        *conf.Google
 	log      *log.Helper
 	uuc      *biz.UserUseCase
 	cuc      *biz.CalendarUseCase
 	euc      *biz.EventUseCase
 	ehuc     *biz.EventHistoryUseCase
 	guc      *biz.GoogleUseCase
-	aiuc     *biz.OpenAIUseCase
-	lastSync time.Time
+	apuc     *biz.AutopilotUseCase
+	wuc      *biz.WatchUseCase
+	luc      *biz.LockUseCase
+	nuc      *biz.NotificationUseCase
+	cnuc     *biz.ChangeNotificationUseCase
+	backoff  *syncBackoff
+	reportMu sync.RWMutex
+	report   *SyncReport
 }
 
-var Jobs = map[string]func(){}
-
 //goland:noinspection ALL
 const (
 	SYNC_LOOP_TIMEOUT = 10 * time.Minute
+
+	DEFAULT_SYNC_CONCURRENCY  = 4
+	DEFAULT_SYNC_USER_TIMEOUT = 2 * time.Minute
+	DEFAULT_SYNC_BACKOFF_BASE = 5 * time.Minute
+	DEFAULT_SYNC_BACKOFF_MAX  = 6 * time.Hour
+	// USER_SYNC_LOCK_TTL is the lease of the sync of a user, it is renewed while the sync runs
+	USER_SYNC_LOCK_TTL = 30 * time.Second
+
+	JOB_SYNC_LOOP            = "syncLoop"
+	JOB_RENEW_WATCH_CHANNELS = "renewWatchChannels"
+	JOB_DAILY_DIGEST         = "dailyDigest"
+	JOB_DISPATCH_REMINDERS   = "dispatchReminders"
+	JOB_PRUNE_HISTORY        = "pruneHistory"
+	JOB_CHECK_TOKENS         = "checkTokens"
+	JOB_AUTOPILOT            = "autopilot"
+
+	// DEFAULT_HISTORY_RETENTION is how long the changes of the events are kept when the pruneHistory job sets no retention
+	DEFAULT_HISTORY_RETENTION = 90 * 24 * time.Hour
+	// TOKEN_ALERT_INTERVAL is how often a user with a revoked Google access is asked to log in again
+	TOKEN_ALERT_INTERVAL = 24 * time.Hour
 )
 
 func NewCronService(
 	c *conf.Cron,
+	g *conf.Google,
 	logger log.Logger,
 	uuc *biz.UserUseCase,
 	cuc *biz.CalendarUseCase,
 	euc *biz.EventUseCase,
 	ehuc *biz.EventHistoryUseCase,
 	guc *biz.GoogleUseCase,
-	aiuc *biz.OpenAIUseCase,
+	apuc *biz.AutopilotUseCase,
+	wuc *biz.WatchUseCase,
+	luc *biz.LockUseCase,
+	nuc *biz.NotificationUseCase,
+	cnuc *biz.ChangeNotificationUseCase,
+	jobs *JobRegistry,
 ) *CronService {
-	return &CronService{
+	backoffBase, backoffMax := DEFAULT_SYNC_BACKOFF_BASE, DEFAULT_SYNC_BACKOFF_MAX
+	if sc := c.GetSync(); sc != nil {
+		if sc.BackoffBase != nil {
+			backoffBase = sc.BackoffBase.AsDuration()
+		}
+		if sc.BackoffMax != nil {
+			backoffMax = sc.BackoffMax.AsDuration()
+		}
+	}
+	s := &CronService{
 		c:    c,
+		g:    g,
 		log:  log.NewHelper(log.With(logger, "module", "service/cron")),
 		uuc:  uuc,
 		cuc:  cuc,
 		euc:  euc,
 		ehuc: ehuc,
 		guc:  guc,
-		aiuc: aiuc,
-	}
-}
+		apuc: apuc,
+		wuc:  wuc,
+		luc:  luc,
+		nuc:  nuc,
+		cnuc: cnuc,
 
-// Init initializes the cron service.
-func (s *CronService) Init() {
-	if len(s.c.Jobs) == 0 {
-		return
+		backoff: newSyncBackoff(backoffBase, backoffMax),
 	}
-	Jobs[s.c.Jobs[0].Name] = s.syncLoop
+	jobs.Register(JOB_SYNC_LOOP, s.syncLoop)
+	jobs.Register(JOB_RENEW_WATCH_CHANNELS, s.renewWatchChannels)
+	jobs.Register(JOB_DAILY_DIGEST, s.dailyDigest)
+	jobs.Register(JOB_DISPATCH_REMINDERS, s.dispatchReminders)
+	jobs.Register(JOB_PRUNE_HISTORY, s.pruneHistory)
+	jobs.Register(JOB_CHECK_TOKENS, s.checkTokens)
+	jobs.Register(JOB_AUTOPILOT, s.autopilot)
+	return s
 }
 
-// syncLoop .
-func (s *CronService) syncLoop() {
+// syncLoop syncs the users in parallel. A failed user does not stop the others,
+// it is skipped by the next runs until its backoff passes.
+func (s *CronService) syncLoop(ctx context.Context) error {
 	syncStart := time.Now()
 	s.log.Debugf("cron job:sync loop: start at %s", syncStart.Format(time.RFC3339))
-	defer func() {
-		s.lastSync = time.Now()
-		s.log.Debugf("cron job:sync loop: end at %s, duration: %s", s.lastSync.Format(time.RFC3339), time.Since(syncStart))
-	}()
 
-	ctx, cancel := context.WithTimeout(context.Background(), SYNC_LOOP_TIMEOUT)
+	ctx, cancel := context.WithTimeout(ctx, SYNC_LOOP_TIMEOUT)
 	defer cancel()
 
 	// List users from database
 	users, err := s.uuc.List(ctx)
 	if err != nil {
 		s.log.Errorf("cron job:sync loop: list users failed: %v", err)
-		return
+		return err
 	}
-	for _, user := range users {
-		token, err := s.guc.TokenSource(ctx, user.RefreshToken)
-		if err != nil {
-			s.log.Errorf("cron job:sync loop: get token failed: %v", err)
-			return
-		}
-		ctx = biz.SetToken(ctx, token)
-		if err := s.syncUserCalendars(ctx, user); err != nil {
-			s.log.Errorf("cron job:sync loop: sync user events failed: %v", err)
-			return
-		}
-		calendars, err := s.cuc.ListUserCalendars(ctx, user.ID)
-		if err != nil {
-			s.log.Errorf("cron job:sync loop: list calendars failed: %v", err)
-			return
-		}
-		for _, calendar := range calendars {
-			if err := s.syncCalendarEvents(ctx, calendar); err != nil {
-				s.log.Errorf("cron job:sync loop: sync calendar events failed: %v", err)
-				return
+	results := make([]*UserSyncResult, len(users))
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < s.syncConcurrency(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				results[i] = s.syncUserWithBackoff(ctx, users[i])
 			}
-			/*
-				if err := s.GenerateCalendarEvents(ctx, calendar); err != nil {
-					s.log.Errorf("cron job:sync loop: generate calendar events failed: %v", err)
-					return
-				}
-			*/
+		}()
+	}
+	for i := range users {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+
+	report := newSyncReport(syncStart, results)
+	s.reportMu.Lock()
+	s.report = report
+	s.reportMu.Unlock()
+	s.log.Infof("cron job:sync loop: end at %s, duration: %s, succeeded: %d, failed: %d, skipped: %d",
+		report.FinishedAt.Format(time.RFC3339), report.FinishedAt.Sub(syncStart), report.Succeeded, report.Failed, report.Skipped)
+	if report.Failed > 0 {
+		return fmt.Errorf("%d of %d users failed to sync", report.Failed, len(users))
+	}
+	return nil
+}
+
+// SyncReport returns the report of the last run of the sync loop, nil before the first run ends
+func (s *CronService) SyncReport() *SyncReport {
+	s.reportMu.RLock()
+	defer s.reportMu.RUnlock()
+	return s.report
+}
+
+func (s *CronService) syncConcurrency() int {
+	if n := s.c.GetSync().GetConcurrency(); n > 0 {
+		return int(n)
+	}
+	return DEFAULT_SYNC_CONCURRENCY
+}
+
+// historyRetention returns the retention of the pruneHistory job configuration,
+// a zero retention would delete the whole history, it falls back to the default
+func (s *CronService) historyRetention() time.Duration {
+	for _, jc := range s.c.GetJobs() {
+		if jc.GetName() == JOB_PRUNE_HISTORY && jc.GetRetention().AsDuration() > 0 {
+			return jc.GetRetention().AsDuration()
+		}
+	}
+	return DEFAULT_HISTORY_RETENTION
+}
+
+func (s *CronService) syncUserTimeout() time.Duration {
+	if timeout := s.c.GetSync().GetUserTimeout(); timeout != nil {
+		return timeout.AsDuration()
+	}
+	return DEFAULT_SYNC_USER_TIMEOUT
+}
+
+// syncUserWithBackoff syncs the user unless it waits for the backoff of the previous failures.
+// The users left when the sync loop runs out of time are skipped, their backoff is not touched.
+func (s *CronService) syncUserWithBackoff(ctx context.Context, user *biz.User) *UserSyncResult {
+	result := &UserSyncResult{UserID: user.ID}
+	if err := ctx.Err(); err != nil {
+		result.Status, result.Error = SYNC_STATUS_SKIPPED, fmt.Sprintf("the sync loop stopped: %v", err)
+		return result
+	}
+	if failures, next := s.backoff.wait(user.ID); !next.IsZero() {
+		result.Status, result.Failures, result.NextAttempt = SYNC_STATUS_SKIPPED, failures, next
+		return result
+	}
+	start := time.Now()
+	userCtx, cancel := context.WithTimeout(ctx, s.syncUserTimeout())
+	defer cancel()
+	// a webhook sync of the user is running, it lists the same changes
+	lock, err := s.luc.TryLock(userCtx, biz.UserSyncLockName(user.ID), USER_SYNC_LOCK_TTL)
+	if errors.Is(err, biz.ErrLockHeld) {
+		result.Status, result.Error = SYNC_STATUS_SKIPPED, "the user is being synced by another process"
+		return result
+	}
+	if err == nil {
+		err = s.syncUser(lock.Context(), user)
+		lock.Unlock()
+	}
+	result.Duration = time.Since(start)
+	if err != nil {
+		s.log.Errorf("cron job:sync loop: sync user %s failed: %v", user.ID, err)
+		result.Status, result.Error = SYNC_STATUS_FAILED, err.Error()
+		result.Failures, result.NextAttempt = s.backoff.fail(user.ID)
+		return result
+	}
+	s.backoff.succeed(user.ID)
+	result.Status = SYNC_STATUS_SUCCEEDED
+	return result
+}
+
+// syncUser syncs the calendars and the events of the user, a failed calendar does not stop the others
+func (s *CronService) syncUser(ctx context.Context, user *biz.User) error {
+	token, err := s.guc.TokenSource(ctx, user.RefreshToken)
+	if err != nil {
+		return fmt.Errorf("get token: %w", err)
+	}
+	ctx = biz.SetUser(biz.SetToken(ctx, token), user)
+	s.syncUserTimezone(ctx, user)
+	if err := s.syncUserCalendars(ctx, user); err != nil {
+		return err
+	}
+	calendars, err := s.cuc.ListUserCalendars(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("list calendars: %w", err)
+	}
+	var errs []error
+	for _, calendar := range calendars {
+		if err := s.syncCalendarEvents(ctx, calendar); err != nil {
+			errs = append(errs, fmt.Errorf("calendar %s: %w", calendar.ID, err))
 		}
 	}
-	return
+	s.notifyChanges(ctx, user)
+	return errors.Join(errs...)
+}
+
+// notifyChanges sends the user the changes others made to the synced events, a failed notification is retried by the next sync
+func (s *CronService) notifyChanges(ctx context.Context, user *biz.User) {
+	if err := s.cnuc.NotifyChanges(ctx, user); err != nil {
+		s.log.Errorf("cron job:sync loop: notify changes of user %s failed: %v", user.ID, err)
+	}
 }
 
 func (s *CronService) syncUserCalendars(ctx context.Context, user *biz.User) error {
@@ -122,10 +267,16 @@ func (s *CronService) syncUserCalendars(ctx context.Context, user *biz.User) err
 		s.log.Errorf("cron job:sync loop: list user calendars failed: %v", err)
 		return err
 	}
-	if err := s.cuc.Sync(ctx, user.ID, calendars); err != nil {
+	deleted, err := s.cuc.Sync(ctx, user.ID, calendars)
+	if err != nil {
 		s.log.Errorf("cron job:sync loop: sync calendars failed: %v", err)
 		return err
 	}
+	for _, calendar := range deleted {
+		if err := s.wuc.StopCalendar(ctx, calendar); err != nil {
+			s.log.Errorf("cron job:sync loop: stop watch channels of calendar %s failed: %v", calendar.ID, err)
+		}
+	}
 	return nil
 }
 
@@ -137,35 +288,100 @@ func (s *CronService) syncCalendarEvents(ctx context.Context, calendar *biz.Cale
 		s.log.Errorf("cron job:sync loop: token not found in context")
 		return fmt.Errorf("token not found in context")
 	}
-	events, err := s.guc.ListCalendarEvents(ctx, token, calendar.GoogleID, &biz.GoogleListEventsOption{
-		TimeMin: time.Now().AddDate(0, 0, -int(time.Now().Weekday())+1).Format(time.RFC3339), // this week
-		TimeMax: time.Now().AddDate(0, 0, 14-int(time.Now().Weekday())).Format(time.RFC3339), // next week
-	})
+	windowStart, windowEnd := syncWindow(ctx)
+	window := &biz.GoogleListEventsOption{
+		TimeMin: windowStart.Format(time.RFC3339),
+		TimeMax: windowEnd.Format(time.RFC3339),
+	}
+	changes, err := s.guc.SyncCalendarEvents(ctx, token, calendar.GoogleID, calendar.SyncToken, window)
+	if errors.Is(err, biz.ErrSyncTokenExpired) {
+		s.log.Infof("cron job:sync loop: sync token of calendar %s expired, full sync", calendar.ID)
+		changes, err = s.guc.SyncCalendarEvents(ctx, token, calendar.GoogleID, "", window)
+	}
 	if err != nil {
 		s.log.Errorf("cron job:sync loop: list calendar events failed: %v", err)
 		return err
 	}
-	if err := s.euc.Sync(ctx, calendar.ID, events); err != nil {
+	applyStart := time.Now()
+	if changes.Full {
+		err = s.euc.Sync(ctx, calendar.ID, changes.Events)
+	} else {
+		err = s.euc.ApplyChanges(ctx, calendar.ID, changes.Events, windowStart, windowEnd)
+	}
+	if err != nil {
 		s.log.Errorf("cron job:sync loop: sync events failed: %v", err)
 		return err
 	}
+	if changes.Full {
+		if err := s.cnuc.SkipCalendarChanges(ctx, calendar, applyStart); err != nil {
+			s.log.Errorf("cron job:sync loop: skip changes of calendar %s failed: %v", calendar.ID, err)
+		}
+	}
+	if err := s.cuc.SetSyncToken(ctx, calendar, changes.NextSyncToken); err != nil {
+		s.log.Errorf("cron job:sync loop: set sync token failed: %v", err)
+		return err
+	}
 	return nil
 }
 
-func (s *CronService) generateCalendarEvents(ctx context.Context, calendar *biz.Calendar) error {
-	s.log.Debugf("cron job:sync loop: generate events for calendar: %v", calendar)
-	// Get token from user refresh token
+// syncWindow is the range of the full sync, the incremental syncs list the changes of all events
+// and leave out the new ones outside it. It starts on the Monday of this week in the time zone
+// of the user in the context and ends with the next week.
+func syncWindow(ctx context.Context) (time.Time, time.Time) {
+	loc := time.Local
+	if user := biz.GetUser(ctx); user != nil {
+		loc = user.Location()
+	}
+	weekStart := biz.WeekStart(time.Now().In(loc))
+	return weekStart, weekStart.AddDate(0, 0, 14)
+}
+
+// syncUserTimezone fills the time zone of the users created before it was stored
+func (s *CronService) syncUserTimezone(ctx context.Context, user *biz.User) {
+	if user.Timezone != "" {
+		return
+	}
+	timezone, err := s.guc.CalendarTimezone(ctx, biz.GetToken(ctx))
+	if err != nil {
+		s.log.Errorf("cron job:sync loop: get calendar timezone failed: %v", err)
+		return
+	}
+	if err := s.uuc.SetTimezone(ctx, user, timezone); err != nil {
+		s.log.Errorf("cron job:sync loop: set timezone failed: %v", err)
+	}
+}
 
-	// Get events from database
-	events, err := s.euc.List(ctx, calendar.ID)
+// pruneHistory deletes the changes of the events older than the retention
+func (s *CronService) pruneHistory(ctx context.Context) error {
+	n, err := s.ehuc.Prune(ctx, s.historyRetention())
 	if err != nil {
-		s.log.Errorf("cron job:sync loop: list events failed: %v", err)
 		return err
 	}
-	// Generate events
-	if err := s.aiuc.GenerateCalendarEvents(ctx, calendar, events); err != nil {
-		s.log.Errorf("cron job:sync loop: generate events failed: %v", err)
+	s.log.Infof("cron job:prune history: %d changes deleted", n)
+	return nil
+}
+
+// checkTokens asks the users with a revoked Google access to log in again, once a day
+func (s *CronService) checkTokens(ctx context.Context) error {
+	users, err := s.uuc.List(ctx)
+	if err != nil {
 		return err
 	}
+	revoked := 0
+	for _, user := range users {
+		if _, err := s.guc.TokenSource(ctx, user.RefreshToken); err == nil {
+			continue
+		}
+		revoked++
+		if first, err := s.luc.Once(ctx, fmt.Sprintf("token-alert:%s", user.ID), TOKEN_ALERT_INTERVAL); err != nil || !first {
+			continue
+		}
+		if err := s.nuc.Notify(ctx, user, &biz.Notification{
+			Text: "I can not access your Google Calendar anymore. Please /login again.",
+		}); err != nil && !errors.Is(err, biz.ErrUserNotReachable) {
+			s.log.Errorf("cron job:check tokens: notify user %s failed: %v", user.ID, err)
+		}
+	}
+	s.log.Infof("cron job:check tokens: %d of %d users have no Google access", revoked, len(users))
 	return nil
 }
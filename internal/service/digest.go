@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kdimtricp/aical/internal/biz"
+	"sort"
+	"strings"
+	"time"
+)
+
+//goland:noinspection ALL
+const (
+	// DIGEST_HOUR is the hour of the day in the user time zone the agenda is sent at
+	DIGEST_HOUR = 7
+	// REMINDER_LEAD is how long before the start of an event the reminder is sent
+	REMINDER_LEAD = 15 * time.Minute
+)
+
+// userEvents returns the timed and all-day events of all the user calendars overlapping the interval sorted by start
+func (s *CronService) userEvents(ctx context.Context, user *biz.User, start, end time.Time) ([]*biz.Event, error) {
+	calendars, err := s.cuc.ListUserCalendars(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	var events []*biz.Event
+	for _, calendar := range calendars {
+		calendarEvents, err := s.euc.ListBetween(ctx, calendar.ID, start, end)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, calendarEvents...)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].StartTime.Before(events[j].StartTime)
+	})
+	return events, nil
+}
+
+// notifyUsers sends the notification built for each user that has a messenger,
+// a nil notification means there is nothing to tell the user
+func (s *CronService) notifyUsers(ctx context.Context, job string, build func(user *biz.User) (*biz.Notification, error)) error {
+	users, err := s.uuc.List(ctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, user := range users {
+		if user.TGID == "" {
+			continue
+		}
+		notification, err := build(user)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("user %s: %w", user.ID, err))
+			continue
+		}
+		if notification == nil {
+			continue
+		}
+		if err := s.nuc.Notify(ctx, user, notification); err != nil && !errors.Is(err, biz.ErrUserNotReachable) {
+			s.log.Errorf("cron job:%s: notify user %s failed: %v", job, user.ID, err)
+			errs = append(errs, fmt.Errorf("user %s: %w", user.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// dailyDigest sends the agenda of the day to the users whose morning has come
+func (s *CronService) dailyDigest(ctx context.Context) error {
+	return s.notifyUsers(ctx, JOB_DAILY_DIGEST, func(user *biz.User) (*biz.Notification, error) {
+		loc := user.Location()
+		now := time.Now().In(loc)
+		if now.Hour() != DIGEST_HOUR {
+			return nil, nil
+		}
+		y, m, d := now.Date()
+		midnight := time.Date(y, m, d, 0, 0, 0, 0, loc)
+		if first, err := s.luc.Once(ctx, fmt.Sprintf("digest:%s:%s", user.ID, midnight.Format(time.DateOnly)), 25*time.Hour); err != nil || !first {
+			return nil, err
+		}
+		events, err := s.userEvents(ctx, user, midnight, midnight.AddDate(0, 0, 1))
+		if err != nil {
+			return nil, err
+		}
+		if len(events) == 0 {
+			return nil, nil
+		}
+		lines := []string{fmt.Sprintf("Your agenda for %s:", now.Format("Monday, January 2"))}
+		for _, e := range events {
+			if e.IsAllDay {
+				lines = append(lines, fmt.Sprintf("all day  %s", e.Summary))
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("%s–%s  %s", e.StartTime.In(loc).Format("15:04"), e.EndTime.In(loc).Format("15:04"), e.Summary))
+		}
+		return &biz.Notification{Text: strings.Join(lines, "\n")}, nil
+	})
+}
+
+// dispatchReminders reminds the users of the events starting soon, every event start is reminded once
+func (s *CronService) dispatchReminders(ctx context.Context) error {
+	return s.notifyUsers(ctx, JOB_DISPATCH_REMINDERS, func(user *biz.User) (*biz.Notification, error) {
+		now := time.Now()
+		events, err := s.userEvents(ctx, user, now, now.Add(REMINDER_LEAD))
+		if err != nil {
+			return nil, err
+		}
+		var lines []string
+		for _, e := range events {
+			if e.IsAllDay || !e.StartTime.After(now) || e.StartTime.After(now.Add(REMINDER_LEAD)) {
+				continue
+			}
+			key := fmt.Sprintf("reminder:%s:%d", e.ID, e.StartTime.Unix())
+			if first, err := s.luc.Once(ctx, key, REMINDER_LEAD+time.Hour); err != nil || !first {
+				continue
+			}
+			line := fmt.Sprintf("%s  %s", e.StartTime.In(user.Location()).Format("15:04"), e.Summary)
+			if e.Location != "" {
+				line = fmt.Sprintf("%s (%s)", line, e.Location)
+			}
+			if e.ConferenceURL != "" {
+				line = fmt.Sprintf("%s\njoin: %s", line, e.ConferenceURL)
+			}
+			lines = append(lines, line)
+		}
+		if len(lines) == 0 {
+			return nil, nil
+		}
+		return &biz.Notification{Text: "Starting soon:\n" + strings.Join(lines, "\n")}, nil
+	})
+}
@@ -0,0 +1,243 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/kdimtricp/aical/internal/biz"
+	"github.com/kdimtricp/aical/internal/conf"
+	"github.com/robfig/cron/v3"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//goland:noinspection ALL
+const (
+	JOB_STATUS_SUCCEEDED = "succeeded"
+	JOB_STATUS_FAILED    = "failed"
+	// JOB_STATUS_SKIPPED runs found the job running in another replica
+	JOB_STATUS_SKIPPED = "skipped"
+
+	// JOB_LOCK_TTL is the lease of a running job, it is renewed while the job runs
+	JOB_LOCK_TTL = time.Minute
+	// JOB_LOCK_MARGIN frees the lock of a finished job this long before the next run
+	JOB_LOCK_MARGIN = 5 * time.Second
+)
+
+var (
+	// ErrJobNotFound is returned for the names no service registered
+	ErrJobNotFound = errors.New("job not found")
+	// ErrJobRunning is returned when the job is still running in this replica
+	ErrJobRunning = errors.New("job is running")
+)
+
+// JobStatus is the state of a job and the outcome of its last run
+type JobStatus struct {
+	Name       string
+	Schedule   string
+	Enabled    bool
+	Running    bool
+	LastStart  time.Time
+	LastEnd    time.Time
+	LastStatus string
+	LastError  string
+	Runs       int64
+}
+
+type job struct {
+	name     string
+	run      func(ctx context.Context) error
+	spec     string
+	schedule cron.Schedule
+	disabled bool
+	jitter   time.Duration
+	running  atomic.Bool
+
+	mu     sync.Mutex
+	status JobStatus
+}
+
+// ScheduledJob is a configured job the cron server runs on its schedule
+type ScheduledJob struct {
+	Name     string
+	Schedule cron.Schedule
+	Run      func()
+}
+
+// JobRegistry holds the named jobs of the services. A job runs once at a time in all the replicas,
+// the run is skipped when the job is still running.
+type JobRegistry struct {
+	c    *conf.Cron
+	luc  *biz.LockUseCase
+	log  *log.Helper
+	mu   sync.RWMutex
+	jobs map[string]*job
+}
+
+func NewJobRegistry(c *conf.Cron, luc *biz.LockUseCase, logger log.Logger) *JobRegistry {
+	return &JobRegistry{
+		c:    c,
+		luc:  luc,
+		log:  log.NewHelper(log.With(logger, "module", "service/jobs")),
+		jobs: make(map[string]*job),
+	}
+}
+
+// Register adds the job under the name, the configuration of the name decides when it runs
+func (r *JobRegistry) Register(name string, run func(ctx context.Context) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.jobs[name] = &job{name: name, run: run}
+}
+
+func (r *JobRegistry) get(name string) (*job, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	j, ok := r.jobs[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrJobNotFound, name)
+	}
+	return j, nil
+}
+
+// Scheduled applies the configuration to the registered jobs and returns the enabled ones.
+// A configured name without a registered job is an error.
+func (r *JobRegistry) Scheduled() ([]*ScheduledJob, error) {
+	var scheduled []*ScheduledJob
+	for _, jc := range r.c.GetJobs() {
+		j, err := r.get(jc.Name)
+		if err != nil {
+			return nil, err
+		}
+		schedule, err := cron.ParseStandard(jc.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("job %s: %w", jc.Name, err)
+		}
+		j.spec, j.schedule, j.disabled = jc.Schedule, schedule, jc.Disabled
+		if jc.Jitter != nil {
+			j.jitter = jc.Jitter.AsDuration()
+		}
+		if j.disabled {
+			r.log.Infof("job %s is disabled", j.name)
+			continue
+		}
+		scheduled = append(scheduled, &ScheduledJob{
+			Name:     j.name,
+			Schedule: schedule,
+			Run:      func() { r.scheduledRun(j) },
+		})
+	}
+	return scheduled, nil
+}
+
+// scheduledRun runs the job on its schedule. The lock of the schedule is kept after the run until shortly
+// before the next one, so a replica with a late clock does not run the same tick again.
+func (r *JobRegistry) scheduledRun(j *job) {
+	if !j.running.CompareAndSwap(false, true) {
+		r.log.Infof("job %s: still running, the run is skipped", j.name)
+		return
+	}
+	if j.jitter > 0 {
+		time.Sleep(time.Duration(rand.Int63n(int64(j.jitter))))
+	}
+	tick, err := r.luc.TryLock(context.Background(), biz.JobLockName(j.name), JOB_LOCK_TTL)
+	if errors.Is(err, biz.ErrLockHeld) {
+		r.log.Debugf("job %s: the tick runs in another replica", j.name)
+		j.running.Store(false)
+		return
+	}
+	if err != nil {
+		r.log.Errorf("job %s: lock failed: %v", j.name, err)
+		j.running.Store(false)
+		return
+	}
+	defer func() {
+		tick.Keep(time.Until(j.schedule.Next(time.Now())) - JOB_LOCK_MARGIN)
+	}()
+	r.execute(j)
+}
+
+// Trigger starts the job in the background
+func (r *JobRegistry) Trigger(name string) (*JobStatus, error) {
+	j, err := r.get(name)
+	if err != nil {
+		return nil, err
+	}
+	if !j.running.CompareAndSwap(false, true) {
+		return nil, ErrJobRunning
+	}
+	r.log.Infof("job %s: triggered", j.name)
+	go r.execute(j)
+	return r.jobStatus(j), nil
+}
+
+// execute runs the job marked as running under its lock and records the outcome
+func (r *JobRegistry) execute(j *job) {
+	defer j.running.Store(false)
+	start := time.Now()
+	lock, err := r.luc.TryLock(context.Background(), biz.JobRunLockName(j.name), JOB_LOCK_TTL)
+	if errors.Is(err, biz.ErrLockHeld) {
+		r.log.Debugf("job %s: runs in another replica", j.name)
+		r.record(j, start, JOB_STATUS_SKIPPED, nil)
+		return
+	}
+	if err != nil {
+		r.log.Errorf("job %s: lock failed: %v", j.name, err)
+		r.record(j, start, JOB_STATUS_FAILED, err)
+		return
+	}
+	defer lock.Unlock()
+	r.setStart(j, start)
+	if err := j.run(lock.Context()); err != nil {
+		r.log.Errorf("job %s: failed: %v", j.name, err)
+		r.record(j, start, JOB_STATUS_FAILED, err)
+		return
+	}
+	r.record(j, start, JOB_STATUS_SUCCEEDED, nil)
+}
+
+func (r *JobRegistry) setStart(j *job, start time.Time) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.LastStart = start
+}
+
+func (r *JobRegistry) record(j *job, start time.Time, status string, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.LastStart, j.status.LastEnd, j.status.LastStatus = start, time.Now(), status
+	j.status.LastError = ""
+	if err != nil {
+		j.status.LastError = err.Error()
+	}
+	j.status.Runs++
+}
+
+func (r *JobRegistry) jobStatus(j *job) *JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	status := j.status
+	status.Name = j.name
+	status.Schedule = j.spec
+	status.Enabled = j.spec != "" && !j.disabled
+	status.Running = j.running.Load()
+	return &status
+}
+
+// Jobs returns the status of all the registered jobs sorted by name
+func (r *JobRegistry) Jobs() []*JobStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	statuses := make([]*JobStatus, 0, len(r.jobs))
+	for _, j := range r.jobs {
+		statuses = append(statuses, r.jobStatus(j))
+	}
+	sort.Slice(statuses, func(i, k int) bool {
+		return statuses[i].Name < statuses[k].Name
+	})
+	return statuses
+}
@@ -11,4 +11,6 @@ var ProviderSet = wire.NewSet(
 	NewCronService,
 	NewChatService,
 	NewTGService,
+	NewAdminService,
+	NewJobRegistry,
 )
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"github.com/google/uuid"
+	"sync"
+	"time"
+)
+
+//goland:noinspection ALL
+const (
+	SYNC_STATUS_SUCCEEDED = "succeeded"
+	SYNC_STATUS_FAILED    = "failed"
+	// SYNC_STATUS_SKIPPED users failed recently and wait for the backoff to pass
+	SYNC_STATUS_SKIPPED = "skipped"
+)
+
+// UserSyncResult is the outcome of the sync of one user
+type UserSyncResult struct {
+	UserID      uuid.UUID
+	Status      string
+	Error       string
+	Failures    int
+	NextAttempt time.Time
+	Duration    time.Duration
+}
+
+// SyncReport summarizes a run of the sync loop
+type SyncReport struct {
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Succeeded  int
+	Failed     int
+	Skipped    int
+	Users      []*UserSyncResult
+}
+
+func newSyncReport(start time.Time, results []*UserSyncResult) *SyncReport {
+	report := &SyncReport{StartedAt: start, FinishedAt: time.Now()}
+	for _, r := range results {
+		if r == nil {
+			continue
+		}
+		switch r.Status {
+		case SYNC_STATUS_SUCCEEDED:
+			report.Succeeded++
+		case SYNC_STATUS_FAILED:
+			report.Failed++
+		case SYNC_STATUS_SKIPPED:
+			report.Skipped++
+		}
+		report.Users = append(report.Users, r)
+	}
+	return report
+}
+
+type backoffState struct {
+	failures int
+	next     time.Time
+}
+
+// syncBackoff keeps the users that failed to sync away from the next runs,
+// the delay doubles with every failure in a row
+type syncBackoff struct {
+	mu    sync.Mutex
+	base  time.Duration
+	max   time.Duration
+	users map[uuid.UUID]*backoffState
+}
+
+func newSyncBackoff(base, max time.Duration) *syncBackoff {
+	return &syncBackoff{
+		base:  base,
+		max:   max,
+		users: make(map[uuid.UUID]*backoffState),
+	}
+}
+
+// wait returns the failures in a row and the time of the next attempt, zero when the user may sync now
+func (b *syncBackoff) wait(id uuid.UUID) (int, time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state, ok := b.users[id]
+	if !ok || !time.Now().Before(state.next) {
+		return 0, time.Time{}
+	}
+	return state.failures, state.next
+}
+
+// fail records the failure and returns the failures in a row and the time of the next attempt
+func (b *syncBackoff) fail(id uuid.UUID) (int, time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state, ok := b.users[id]
+	if !ok {
+		state = &backoffState{}
+		b.users[id] = state
+	}
+	state.failures++
+	delay := b.base
+	for i := 1; i < state.failures && delay < b.max; i++ {
+		delay *= 2
+	}
+	if delay > b.max {
+		delay = b.max
+	}
+	state.next = time.Now().Add(delay)
+	return state.failures, state.next
+}
+
+func (b *syncBackoff) succeed(id uuid.UUID) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.users, id)
+}
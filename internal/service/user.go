@@ -42,12 +42,17 @@ func (s *UserService) CreateUser(ctx context.Context, req *pb.CreateUserRequest)
 	if err != nil {
 		return nil, err
 	}
+	timezone, err := s.gg.CalendarTimezone(ctx, token)
+	if err != nil {
+		s.log.Errorf("create user: get calendar timezone failed: %v", err)
+	}
 	if err := s.uc.Create(ctx, &biz.User{
 		GoogleID:     ui.GoogleID,
 		TGID:         req.Tgid,
 		Name:         ui.Name,
 		Email:        ui.Email,
 		RefreshToken: token.RefreshToken,
+		Timezone:     timezone,
 	}); err != nil {
 		return nil, err
 	}
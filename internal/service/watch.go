@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"github.com/kdimtricp/aical/internal/biz"
+	"time"
+)
+
+//goland:noinspection ALL
+const (
+	WATCH_SYNC_TIMEOUT           = time.Minute
+	DEFAULT_WATCH_TTL            = 7 * 24 * time.Hour
+	DEFAULT_WATCH_RENEW_BEFORE   = 24 * time.Hour
+	RENEW_WATCH_CHANNELS_TIMEOUT = 10 * time.Minute
+)
+
+// webhook returns the address and the lifetime of the watch channels, the address is empty when the push is disabled
+func (s *CronService) webhook() (address string, ttl, renewBefore time.Duration) {
+	webhook := s.g.GetWebhook()
+	ttl, renewBefore = DEFAULT_WATCH_TTL, DEFAULT_WATCH_RENEW_BEFORE
+	if webhook.GetTtl() != nil {
+		ttl = webhook.GetTtl().AsDuration()
+	}
+	if webhook.GetRenewBefore() != nil {
+		renewBefore = webhook.GetRenewBefore().AsDuration()
+	}
+	return webhook.GetAddress(), ttl, renewBefore
+}
+
+// renewWatchChannels registers the channels of the calendars not watched yet and replaces the expiring ones
+func (s *CronService) renewWatchChannels(ctx context.Context) error {
+	address, ttl, renewBefore := s.webhook()
+	if address == "" {
+		return nil
+	}
+	s.log.Debugf("cron job:renew watch channels: start")
+	ctx, cancel := context.WithTimeout(ctx, RENEW_WATCH_CHANNELS_TIMEOUT)
+	defer cancel()
+
+	users, err := s.uuc.List(ctx)
+	if err != nil {
+		s.log.Errorf("cron job:renew watch channels: list users failed: %v", err)
+		return err
+	}
+	for _, user := range users {
+		token, err := s.guc.TokenSource(ctx, user.RefreshToken)
+		if err != nil {
+			s.log.Errorf("cron job:renew watch channels: get token of user %s failed: %v", user.ID, err)
+			continue
+		}
+		userCtx := biz.SetUser(biz.SetToken(ctx, token), user)
+		calendars, err := s.cuc.ListUserCalendars(userCtx, user.ID)
+		if err != nil {
+			s.log.Errorf("cron job:renew watch channels: list calendars of user %s failed: %v", user.ID, err)
+			continue
+		}
+		for _, calendar := range calendars {
+			if err := s.wuc.Renew(userCtx, calendar, address, ttl, renewBefore); err != nil {
+				s.log.Errorf("cron job:renew watch channels: renew calendar %s failed: %v", calendar.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// HandleWatchNotification verifies the Google push notification and syncs the changed calendar.
+// The sync runs in the background, Google expects the notification to be acknowledged right away.
+func (s *CronService) HandleWatchNotification(ctx context.Context, channelID, channelToken, resourceState string) error {
+	channel, err := s.wuc.Verify(ctx, channelID, channelToken)
+	if err != nil {
+		s.log.Warnf("watch notification: channel %q rejected: %v", channelID, err)
+		return err
+	}
+	s.log.Debugf("watch notification: channel %s, state %s", channel.ID, resourceState)
+	if resourceState == biz.WATCH_STATE_SYNC {
+		return nil
+	}
+	go s.syncWatchedCalendar(channel)
+	return nil
+}
+
+// syncWatchedCalendar runs the incremental sync of the calendar of the channel
+func (s *CronService) syncWatchedCalendar(channel *biz.WatchChannel) {
+	ctx, cancel := context.WithTimeout(context.Background(), WATCH_SYNC_TIMEOUT)
+	defer cancel()
+
+	calendar, err := s.cuc.Get(ctx, channel.CalendarID)
+	if err != nil {
+		s.log.Errorf("watch notification: get calendar %s failed: %v", channel.CalendarID, err)
+		return
+	}
+	user, err := s.uuc.GetUserByID(ctx, calendar.UserID.String())
+	if err != nil {
+		s.log.Errorf("watch notification: get user %s failed: %v", calendar.UserID, err)
+		return
+	}
+	token, err := s.guc.TokenSource(ctx, user.RefreshToken)
+	if err != nil {
+		s.log.Errorf("watch notification: get token failed: %v", err)
+		return
+	}
+	// the notification may come while the cron syncs the user, the changes after its listing are synced here
+	lock, err := s.luc.Lock(ctx, biz.UserSyncLockName(user.ID), USER_SYNC_LOCK_TTL)
+	if err != nil {
+		s.log.Errorf("watch notification: lock user %s failed: %v", user.ID, err)
+		return
+	}
+	defer lock.Unlock()
+	ctx = biz.SetUser(biz.SetToken(lock.Context(), token), user)
+	if err := s.syncCalendarEvents(ctx, calendar); err != nil {
+		s.log.Errorf("watch notification: sync calendar %s failed: %v", calendar.ID, err)
+		return
+	}
+	s.notifyChanges(ctx, user)
+}
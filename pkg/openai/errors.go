@@ -0,0 +1,116 @@
+package openai
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrRateLimited           = errors.New("openai: rate limited")
+	ErrQuotaExceeded         = errors.New("openai: quota exceeded")
+	ErrContextLengthExceeded = errors.New("openai: context length exceeded")
+	ErrAuthFailed            = errors.New("openai: authentication failed")
+	ErrServerError           = errors.New("openai: server error")
+	ErrBadRequest            = errors.New("openai: bad request")
+	// ErrStreamInterrupted is returned when the stream ends before the [DONE] message
+	ErrStreamInterrupted = errors.New("openai: stream ended before [DONE]")
+)
+
+type chatCompletionErrorResponse struct {
+	Error struct {
+		Message string      `json:"message"`
+		Type    string      `json:"type"`
+		Param   string      `json:"param"`
+		Code    interface{} `json:"code"`
+	} `json:"error"`
+}
+
+// APIError is an error response of the API, use errors.Is with the Err* variables to check its kind
+type APIError struct {
+	// StatusCode is zero for an error sent in the stream of a successful response
+	StatusCode int
+	Type       string
+	Code       string
+	Param      string
+	Message    string
+	// RetryAfter is the delay requested by the server with the Retry-After header
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("openai: stream error: %s", e.Message)
+	}
+	if e.Message == "" {
+		return fmt.Sprintf("openai: unexpected status code: %d", e.StatusCode)
+	}
+	return fmt.Sprintf("openai: status code %d: %s", e.StatusCode, e.Message)
+}
+
+// Unwrap returns the kind of the error
+func (e *APIError) Unwrap() error {
+	switch {
+	case e.Code == "context_length_exceeded":
+		return ErrContextLengthExceeded
+	case e.Code == "insufficient_quota":
+		return ErrQuotaExceeded
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden:
+		return ErrAuthFailed
+	case e.StatusCode >= http.StatusInternalServerError, e.StatusCode == 0, e.Type == "server_error":
+		return ErrServerError
+	default:
+		return ErrBadRequest
+	}
+}
+
+// retryable reports if the request may succeed when it is repeated
+func (e *APIError) retryable() bool {
+	kind := e.Unwrap()
+	return kind == ErrRateLimited || kind == ErrServerError
+}
+
+// responseError returns the error for a non-OK response
+func responseError(resp *http.Response) error {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return apiErr
+	}
+	var errorResponse chatCompletionErrorResponse
+	if err := json.Unmarshal(body, &errorResponse); err != nil {
+		apiErr.Message = strings.TrimSpace(string(body))
+		return apiErr
+	}
+	apiErr.Type = errorResponse.Error.Type
+	apiErr.Param = errorResponse.Error.Param
+	apiErr.Message = errorResponse.Error.Message
+	if errorResponse.Error.Code != nil {
+		apiErr.Code = fmt.Sprint(errorResponse.Error.Code)
+	}
+	return apiErr
+}
+
+// parseRetryAfter parses the Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
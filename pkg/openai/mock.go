@@ -0,0 +1,83 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ErrMockExhausted is returned when the mock provider has replayed all scripted responses
+var ErrMockExhausted = errors.New("mock provider: no scripted responses left")
+
+// MockProvider is an in-process Provider replaying scripted responses in order, it is meant for offline tests
+type MockProvider struct {
+	mu        sync.Mutex
+	responses []*ChatCompletionResponse
+	requests  []ChatCompletionRequest
+	next      int
+}
+
+// NewMockProvider returns a mock provider replaying the responses
+func NewMockProvider(responses ...*ChatCompletionResponse) *MockProvider {
+	return &MockProvider{
+		responses: responses,
+	}
+}
+
+// LoadMockProvider returns a mock provider replaying the responses from a JSON file with an array of chat completion responses
+func LoadMockProvider(path string) (*MockProvider, error) {
+	script, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var responses []*ChatCompletionResponse
+	if err := json.Unmarshal(script, &responses); err != nil {
+		return nil, err
+	}
+	return NewMockProvider(responses...), nil
+}
+
+// Requests returns copies of the requests received so far
+func (m *MockProvider) Requests() []ChatCompletionRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	requests := make([]ChatCompletionRequest, len(m.requests))
+	copy(requests, m.requests)
+	return requests
+}
+
+func (m *MockProvider) DoRequest(ctx context.Context, request *ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := *request
+	r.Messages = append([]ChatCompletionMessage(nil), request.Messages...)
+	m.requests = append(m.requests, r)
+	if m.next >= len(m.responses) {
+		return nil, ErrMockExhausted
+	}
+	response := m.responses[m.next]
+	m.next++
+	return response, nil
+}
+
+// DoStreamRequest replays the next response, passing its content to the handler word by word
+func (m *MockProvider) DoStreamRequest(ctx context.Context, request *ChatCompletionRequest, handler StreamHandler) (*ChatCompletionResponse, error) {
+	response, err := m.DoRequest(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	if handler != nil && len(response.Choices) > 0 {
+		for _, word := range strings.SplitAfter(response.Choices[0].Message.Content, " ") {
+			if word != "" {
+				handler(word)
+			}
+		}
+	}
+	return response, nil
+}
@@ -0,0 +1,56 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func mockResponse(content string) *ChatCompletionResponse {
+	return &ChatCompletionResponse{Choices: []ChatCompletionChoice{{Message: ChatCompletionMessage{Role: "assistant", Content: content}, FinishReason: "stop"}}}
+}
+
+func TestMockProvider(t *testing.T) {
+	provider := NewMockProvider(mockResponse("first"), mockResponse("second answer here"))
+	request := &ChatCompletionRequest{Messages: []ChatCompletionMessage{{Role: "user", Content: "hi"}}}
+
+	response, err := provider.DoRequest(context.Background(), request)
+	if err != nil {
+		t.Fatalf("DoRequest: %v", err)
+	}
+	if got := response.Choices[0].Message.Content; got != "first" {
+		t.Errorf("first content = %q, want %q", got, "first")
+	}
+	request.Messages[0].Content = "changed"
+	if got := provider.Requests()[0].Messages[0].Content; got != "hi" {
+		t.Errorf("recorded request content = %q, want %q", got, "hi")
+	}
+
+	var deltas []string
+	response, err = provider.DoStreamRequest(context.Background(), request, func(delta string) {
+		deltas = append(deltas, delta)
+	})
+	if err != nil {
+		t.Fatalf("DoStreamRequest: %v", err)
+	}
+	if want := []string{"second ", "answer ", "here"}; !reflect.DeepEqual(deltas, want) {
+		t.Errorf("deltas = %q, want %q", deltas, want)
+	}
+	if got := response.Choices[0].Message.Content; got != "second answer here" {
+		t.Errorf("second content = %q, want %q", got, "second answer here")
+	}
+
+	if _, err := provider.DoRequest(context.Background(), request); !errors.Is(err, ErrMockExhausted) {
+		t.Errorf("error = %v, want %v", err, ErrMockExhausted)
+	}
+	if got := len(provider.Requests()); got != 3 {
+		t.Errorf("recorded %d requests, want 3", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := provider.DoRequest(ctx, request); !errors.Is(err, context.Canceled) {
+		t.Errorf("error = %v, want %v", err, context.Canceled)
+	}
+}
@@ -6,73 +6,161 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
+	"time"
 )
 
+//goland:noinspection GoSnakeCaseUsage
+const (
+	DEFAULT_BASE_URL    = "https://api.openai.com/v1"
+	DEFAULT_API_VERSION = "2023-07-01-preview"
+)
+
+// Provider is a chat completion backend
+type Provider interface {
+	DoRequest(ctx context.Context, request *ChatCompletionRequest) (*ChatCompletionResponse, error)
+	DoStreamRequest(ctx context.Context, request *ChatCompletionRequest, handler StreamHandler) (*ChatCompletionResponse, error)
+}
+
+// Client is a Provider for the OpenAI API and the servers compatible with it, including Azure OpenAI
 type Client struct {
 	http.Client
-	token string
-	model string
+	token        string
+	model        string
+	baseURL      string
+	organization string
+	azure        bool
+	apiVersion   string
+	retry        retryPolicy
+	limiter      *Limiter
+	// timeout limits a single attempt, see WithRequestTimeout
+	timeout time.Duration
+}
+
+// ClientOption configures the Client
+type ClientOption func(*Client)
+
+// WithBaseURL sets the API base URL, e.g. http://localhost:11434/v1 for an Ollama server
+func WithBaseURL(baseURL string) ClientOption {
+	return func(c *Client) {
+		if baseURL != "" {
+			c.baseURL = strings.TrimRight(baseURL, "/")
+		}
+	}
 }
 
-type chatCompletionFunctionCall struct {
+// WithOrganization sets the OpenAI organization header
+func WithOrganization(organization string) ClientOption {
+	return func(c *Client) {
+		c.organization = organization
+	}
+}
+
+// WithAzure switches the client to the Azure OpenAI API, the model is used as the deployment name
+func WithAzure(apiVersion string) ClientOption {
+	return func(c *Client) {
+		c.azure = true
+		c.apiVersion = DEFAULT_API_VERSION
+		if apiVersion != "" {
+			c.apiVersion = apiVersion
+		}
+	}
+}
+
+//goland:noinspection GoSnakeCaseUsage
+const (
+	TOOL_TYPE_FUNCTION = "function"
+)
+
+// FunctionCall is the function name and JSON encoded arguments the model wants to call
+type FunctionCall struct {
 	Name      string `json:"name,omitempty"`
 	Arguments string `json:"arguments,omitempty"`
 }
 
+// ToolCall is a single tool call requested by the model, Index is only set in stream deltas
+type ToolCall struct {
+	Index    *int         `json:"index,omitempty"`
+	ID       string       `json:"id,omitempty"`
+	Type     string       `json:"type,omitempty"`
+	Function FunctionCall `json:"function"`
+}
+
 type ChatCompletionMessage struct {
-	Role         string                      `json:"role"`
-	Content      string                      `json:"content"`
-	Name         string                      `json:"name,omitempty"`
-	FunctionCall *chatCompletionFunctionCall `json:"function_call,omitempty"`
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	Name       string     `json:"name,omitempty"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
 }
 
-type chatCompletionErrorResponse struct {
-	Error struct {
-		Message string `json:"message"`
-		Type    string `json:"type"`
-		Param   string `json:"param"`
-		Code    string `json:"code"`
-	} `json:"error"`
+//goland:noinspection GoUnnecessarilyExportedIdentifiers
+type ChatCompletionChoice struct {
+	Index        int                   `json:"index"`
+	Message      ChatCompletionMessage `json:"message"`
+	FinishReason string                `json:"finish_reason"`
+}
+
+//goland:noinspection GoUnnecessarilyExportedIdentifiers
+type ChatCompletionUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
 }
 
 //goland:noinspection GoUnnecessarilyExportedIdentifiers
 type ChatCompletionResponse struct {
-	ID      string `json:"id"`
-	Object  string `json:"object"`
-	Created int64  `json:"created"`
-	Model   string `json:"model"`
-	Choices []struct {
-		Index        int                   `json:"index"`
-		Message      ChatCompletionMessage `json:"message"`
-		FinishReason string                `json:"finish_reason"`
-	} `json:"choices"`
-	Usage struct {
-		PromptTokens     int `json:"prompt_tokens"`
-		CompletionTokens int `json:"completion_tokens"`
-		TotalTokens      int `json:"total_tokens"`
-	} `json:"usage"`
+	ID      string                 `json:"id"`
+	Object  string                 `json:"object"`
+	Created int64                  `json:"created"`
+	Model   string                 `json:"model"`
+	Choices []ChatCompletionChoice `json:"choices"`
+	Usage   ChatCompletionUsage    `json:"usage"`
 }
 
-func NewClient(apiToken string, model string) *Client {
-	return &Client{
+func NewClient(apiToken string, model string, opts ...ClientOption) *Client {
+	c := &Client{
 		Client: http.Client{
 			Transport:     nil,
 			CheckRedirect: nil,
 			Jar:           nil,
 			Timeout:       0,
 		},
-		token: apiToken,
-		model: model,
+		token:   apiToken,
+		model:   model,
+		baseURL: DEFAULT_BASE_URL,
+		retry:   defaultRetryPolicy(),
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
-func (c *Client) DoRequest(ctx context.Context, request *ChatCompletionRequest) (*ChatCompletionResponse, error) {
-	request.Model = c.model
-	req, err := request.httpRequest(c.token)
+// httpRequest returns the http request for the chat completion request with the provider specific url and headers
+func (c *Client) httpRequest(request *ChatCompletionRequest) (*http.Request, error) {
+	url := fmt.Sprintf("%s/chat/completions", c.baseURL)
+	if c.azure {
+		url = fmt.Sprintf("%s/openai/deployments/%s/chat/completions?api-version=%s", c.baseURL, c.model, c.apiVersion)
+	}
+	req, err := request.httpRequest(url)
 	if err != nil {
 		return nil, err
 	}
-	resp, err := c.Do(req.WithContext(ctx))
+	if c.azure {
+		req.Header.Add("api-key", c.token)
+	} else if c.token != "" {
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", c.token))
+	}
+	if c.organization != "" {
+		req.Header.Add("OpenAI-Organization", c.organization)
+	}
+	return req, nil
+}
+
+func (c *Client) DoRequest(ctx context.Context, request *ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	request.Model = c.model
+	resp, err := c.send(ctx, request)
 	if err != nil {
 		return nil, err
 	}
@@ -81,19 +169,9 @@ func (c *Client) DoRequest(ctx context.Context, request *ChatCompletionRequest)
 		if err != nil {
 		}
 	}(resp.Body)
-	if resp.StatusCode == http.StatusOK {
-		var completionResponse ChatCompletionResponse
-		if err := json.NewDecoder(resp.Body).Decode(&completionResponse); err != nil {
-			return nil, err
-		}
-		return &completionResponse, nil
-	}
-	if resp.StatusCode == http.StatusBadRequest {
-		var errorResponse chatCompletionErrorResponse
-		if err := json.NewDecoder(resp.Body).Decode(&errorResponse); err != nil {
-			return nil, err
-		}
-		return nil, fmt.Errorf("bad request: %s", errorResponse.Error.Message)
+	var completionResponse ChatCompletionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&completionResponse); err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	return &completionResponse, nil
 }
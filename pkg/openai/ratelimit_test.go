@@ -0,0 +1,68 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimiterReserve(t *testing.T) {
+	tests := []struct {
+		name              string
+		requestsPerMinute int
+		burst             int
+		requests          int
+		// wait is the expected wait of the last request
+		wait time.Duration
+	}{
+		{"within burst", 60, 3, 3, 0},
+		{"over burst", 60, 3, 4, time.Second},
+		{"twice over burst", 60, 3, 5, 2 * time.Second},
+		{"burst below one", 120, 0, 2, 500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLimiter(tt.requestsPerMinute, tt.burst)
+			var wait time.Duration
+			for i := 0; i < tt.requests; i++ {
+				wait = l.reserve()
+			}
+			// the refill between the reservations shortens the wait a little
+			if wait > tt.wait || wait < tt.wait-50*time.Millisecond {
+				t.Errorf("wait = %s, want about %s", wait, tt.wait)
+			}
+		})
+	}
+}
+
+func TestLimiterRefill(t *testing.T) {
+	l := NewLimiter(60, 1)
+	l.reserve()
+	l.last = l.last.Add(-2 * time.Second)
+	if wait := l.reserve(); wait != 0 {
+		t.Errorf("wait after refill = %s, want 0", wait)
+	}
+	// the refill is capped by the burst
+	l.last = l.last.Add(-time.Minute)
+	l.reserve()
+	if wait := l.reserve(); wait <= 0 {
+		t.Errorf("second request after a long pause did not wait, burst is 1")
+	}
+}
+
+func TestLimiterWaitCancelled(t *testing.T) {
+	l := NewLimiter(1, 1)
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatalf("first Wait: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait = %v, want %v", err, context.DeadlineExceeded)
+	}
+	// the token of the cancelled request is returned, the next one waits for a single token
+	if wait := l.reserve(); wait > time.Minute {
+		t.Errorf("wait after a cancelled request = %s, want at most a minute", wait)
+	}
+}
@@ -14,10 +14,13 @@ type FunctionDescription struct {
 
 type function func(ctx context.Context, arguments string) string
 
+// Registry holds the functions offered to the model, the functions are listed in registration order
+// so the tools part of the prompt stays the same between requests.
 type Registry struct {
 	mu        sync.RWMutex
 	functions map[string]function
 	descs     map[string]FunctionDescription
+	order     []string
 }
 
 func NewRegistry() *Registry {
@@ -30,11 +33,46 @@ func NewRegistry() *Registry {
 func (r *Registry) Register(name string, desc FunctionDescription, function function) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	if _, exists := r.functions[name]; !exists {
+		r.order = append(r.order, name)
+	}
 	r.functions[name] = function
 	r.descs[name] = desc
 	return
 }
 
+// Subset returns a registry with the named functions in registration order, unknown names are ignored
+func (r *Registry) Subset(names ...string) *Registry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	selected := make(map[string]bool, len(names))
+	for _, name := range names {
+		selected[name] = true
+	}
+	subset := NewRegistry()
+	for _, name := range r.order {
+		if selected[name] {
+			subset.order = append(subset.order, name)
+			subset.functions[name] = r.functions[name]
+			subset.descs[name] = r.descs[name]
+		}
+	}
+	return subset
+}
+
+// Names returns the names of the functions in registration order
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, len(r.order))
+	copy(names, r.order)
+	return names
+}
+
+// Execute validates the arguments against the parameters schema of the function and calls it.
+// Invalid arguments are not passed to the function, the validation error is returned to the model instead.
 func (r *Registry) Execute(ctx context.Context, name string, arguments string) string {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -43,17 +81,54 @@ func (r *Registry) Execute(ctx context.Context, name string, arguments string) s
 	if !exists {
 		return errors.New("function not found").Error()
 	}
+	if err := ValidateArguments(r.descs[name], arguments); err != nil {
+		return err.Error()
+	}
 	return function(ctx, arguments)
 }
 
+// ExecuteToolCalls executes the tool calls concurrently and returns the tool messages with the results
+// in the order of the calls.
+func (r *Registry) ExecuteToolCalls(ctx context.Context, calls []ToolCall) []ChatCompletionMessage {
+	results := make([]ChatCompletionMessage, len(calls))
+	var wg sync.WaitGroup
+	for i, call := range calls {
+		wg.Add(1)
+		go func(i int, call ToolCall) {
+			defer wg.Done()
+			results[i] = ChatCompletionMessage{
+				Role:       "tool",
+				Content:    r.Execute(ctx, call.Function.Name, call.Function.Arguments),
+				ToolCallID: call.ID,
+			}
+		}(i, call)
+	}
+	wg.Wait()
+	return results
+}
+
+// Descriptions returns the descriptions of the functions in registration order
 func (r *Registry) Descriptions() []FunctionDescription {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	descs := make([]FunctionDescription, 0, len(r.descs))
-	for _, desc := range r.descs {
-		descs = append(descs, desc)
+	descs := make([]FunctionDescription, 0, len(r.order))
+	for _, name := range r.order {
+		descs = append(descs, r.descs[name])
 	}
 
 	return descs
 }
+
+// Tools returns the registered functions as tools
+func (r *Registry) Tools() []Tool {
+	descs := r.Descriptions()
+	tools := make([]Tool, len(descs))
+	for i, desc := range descs {
+		tools[i] = Tool{
+			Type:     TOOL_TYPE_FUNCTION,
+			Function: desc,
+		}
+	}
+	return tools
+}
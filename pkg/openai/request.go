@@ -3,52 +3,70 @@ package openai
 import (
 	"bytes"
 	"encoding/json"
-	"fmt"
 	"net/http"
 )
 
+// Tool is a tool the model may call, only functions are supported
+type Tool struct {
+	Type     string              `json:"type"`
+	Function FunctionDescription `json:"function"`
+}
+
+// ToolChoiceFunction forces the model to call the named function
+type ToolChoiceFunction struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name string `json:"name"`
+	} `json:"function"`
+}
+
 type ChatCompletionRequest struct {
-	Model            string                      `json:"model"`
-	Messages         []ChatCompletionMessage     `json:"messages"`
-	Functions        []FunctionDescription       `json:"functions,omitempty"`
-	FunctionCall     *chatCompletionFunctionCall `json:"function_call,omitempty"`
-	Temperature      float64                     `json:"temperature,omitempty"`
-	TopP             float64                     `json:"top_p,omitempty"`
-	N                int                         `json:"n,omitempty"`
-	Stream           bool                        `json:"stream,omitempty"`
-	Stop             []string                    `json:"stop,omitempty"`
-	MaxTokens        int                         `json:"max_tokens,omitempty"`
-	PresencePenalty  float64                     `json:"presence_penalty,omitempty"`
-	FrequencyPenalty float64                     `json:"frequency_penalty,omitempty"`
-	LogitBias        map[string]float64          `json:"logit_bias,omitempty"`
-	User             string                      `json:"user,omitempty"`
+	Model             string                  `json:"model"`
+	Messages          []ChatCompletionMessage `json:"messages"`
+	Tools             []Tool                  `json:"tools,omitempty"`
+	ToolChoice        interface{}             `json:"tool_choice,omitempty"`
+	ParallelToolCalls *bool                   `json:"parallel_tool_calls,omitempty"`
+	Temperature       float64                 `json:"temperature,omitempty"`
+	TopP              float64                 `json:"top_p,omitempty"`
+	N                 int                     `json:"n,omitempty"`
+	Stream            bool                    `json:"stream,omitempty"`
+	StreamOptions     *StreamOptions          `json:"stream_options,omitempty"`
+	Stop              []string                `json:"stop,omitempty"`
+	MaxTokens         int                     `json:"max_tokens,omitempty"`
+	PresencePenalty   float64                 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty  float64                 `json:"frequency_penalty,omitempty"`
+	LogitBias         map[string]float64      `json:"logit_bias,omitempty"`
+	User              string                  `json:"user,omitempty"`
 }
 
-func (r *ChatCompletionRequest) httpRequest(token string) (*http.Request, error) {
+// StreamOptions configures a streamed completion
+type StreamOptions struct {
+	// IncludeUsage asks for a last chunk with the token usage of the whole completion
+	IncludeUsage bool `json:"include_usage"`
+}
+
+func (r *ChatCompletionRequest) httpRequest(url string) (*http.Request, error) {
 	body, err := json.Marshal(r)
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(body))
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
 	req.Header.Add("Content-Type", "application/json")
 	return req, nil
 }
 
-// AddFunctionCall adds a function call to the request
-func (r *ChatCompletionRequest) AddFunctionCall(name string, arguments string, result string) {
-	r.Messages = append(r.Messages, ChatCompletionMessage{
-		Role: "assistant",
-		FunctionCall: &chatCompletionFunctionCall{
-			Name:      name,
-			Arguments: arguments,
-		}})
-	r.Messages = append(r.Messages, ChatCompletionMessage{
-		Role:    "function",
-		Content: fmt.Sprintf("result: %s", result),
-		Name:    name,
-	})
+// AddToolCalls adds the assistant message requesting tool calls and the tool results to the request
+func (r *ChatCompletionRequest) AddToolCalls(message ChatCompletionMessage, results []ChatCompletionMessage) {
+	r.Messages = append(r.Messages, message)
+	r.Messages = append(r.Messages, results...)
+}
+
+// NewToolChoiceFunction returns a tool choice forcing the model to call the named function
+func NewToolChoiceFunction(name string) *ToolChoiceFunction {
+	choice := &ToolChoiceFunction{Type: TOOL_TYPE_FUNCTION}
+	choice.Function.Name = name
+	return choice
 }
@@ -0,0 +1,142 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+//goland:noinspection GoSnakeCaseUsage
+const (
+	DEFAULT_MAX_RETRIES     = 3
+	DEFAULT_RETRY_BASE_WAIT = 500 * time.Millisecond
+	DEFAULT_RETRY_MAX_WAIT  = 30 * time.Second
+)
+
+// retryPolicy is the exponential backoff of the retried requests
+type retryPolicy struct {
+	maxRetries int
+	baseWait   time.Duration
+	maxWait    time.Duration
+}
+
+func defaultRetryPolicy() retryPolicy {
+	return retryPolicy{
+		maxRetries: DEFAULT_MAX_RETRIES,
+		baseWait:   DEFAULT_RETRY_BASE_WAIT,
+		maxWait:    DEFAULT_RETRY_MAX_WAIT,
+	}
+}
+
+// backoff returns the wait before the retry attempt, using full jitter
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	wait := p.baseWait << attempt
+	if wait <= 0 || wait > p.maxWait {
+		wait = p.maxWait
+	}
+	return time.Duration(rand.Int63n(int64(wait) + 1))
+}
+
+// WithRetry sets how many times rate limited and failed requests are retried and the backoff bounds
+func WithRetry(maxRetries int, baseWait time.Duration, maxWait time.Duration) ClientOption {
+	return func(c *Client) {
+		if maxRetries >= 0 {
+			c.retry.maxRetries = maxRetries
+		}
+		if baseWait > 0 {
+			c.retry.baseWait = baseWait
+		}
+		if maxWait > 0 {
+			c.retry.maxWait = maxWait
+		}
+	}
+}
+
+// WithRequestTimeout limits the duration of a single attempt. A completion must be read within the timeout,
+// a stream must only start within it, so long answers are not cut off.
+func WithRequestTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) {
+		c.timeout = timeout
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.ResponseHeaderTimeout = timeout
+		c.Client.Transport = transport
+	}
+}
+
+// cancelBody cancels the context of the attempt when the response body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// WithLimiter shares the rate limiter between all requests of the client
+func WithLimiter(limiter *Limiter) ClientOption {
+	return func(c *Client) {
+		c.limiter = limiter
+	}
+}
+
+// send sends the request, retrying rate limited requests, server errors and network failures with backoff.
+// The returned response has the OK status, its body must be closed by the caller.
+func (c *Client) send(ctx context.Context, request *ChatCompletionRequest) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if c.limiter != nil {
+			if err := c.limiter.Wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+		req, err := c.httpRequest(request)
+		if err != nil {
+			return nil, err
+		}
+		if request.Stream {
+			req.Header.Add("Accept", "text/event-stream")
+		}
+		attemptCtx, cancel := context.WithCancel(ctx)
+		if c.timeout > 0 && !request.Stream {
+			attemptCtx, cancel = context.WithTimeout(ctx, c.timeout)
+		}
+		resp, err := c.Do(req.WithContext(attemptCtx))
+		wait := time.Duration(0)
+		switch {
+		case err != nil:
+			cancel()
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+		case resp.StatusCode == http.StatusOK:
+			resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		default:
+			err = responseError(resp)
+			_ = resp.Body.Close()
+			cancel()
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || !apiErr.retryable() {
+				return nil, err
+			}
+			wait = apiErr.RetryAfter
+		}
+		if attempt >= c.retry.maxRetries {
+			return nil, err
+		}
+		if wait <= 0 {
+			wait = c.retry.backoff(attempt)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package openai
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "text/event-stream" {
+			time.Sleep(3 * timeout)
+			_, _ = fmt.Fprint(w, `{"choices":[{"message":{"content":"late"}}]}`)
+			return
+		}
+		// the stream starts at once and lasts longer than the timeout
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, word := range []string{"a", "b", "c"} {
+			_, _ = fmt.Fprintf(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", word)
+			w.(http.Flusher).Flush()
+			time.Sleep(timeout)
+		}
+		_, _ = fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+	c := NewClient("token", "model", WithBaseURL(server.URL), WithRequestTimeout(timeout), WithRetry(0, 0, 0))
+
+	if _, err := c.DoRequest(context.Background(), &ChatCompletionRequest{}); err == nil {
+		t.Error("DoRequest outlived the request timeout")
+	}
+	response, err := c.DoStreamRequest(context.Background(), &ChatCompletionRequest{}, nil)
+	if err != nil {
+		t.Fatalf("DoStreamRequest: %v", err)
+	}
+	if got := response.Choices[0].Message.Content; got != "abc" {
+		t.Errorf("streamed content = %q, want %q", got, "abc")
+	}
+}
@@ -0,0 +1,178 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+//goland:noinspection GoSnakeCaseUsage
+const (
+	DEFAULT_MAX_STEPS          = 10
+	DEFAULT_MAX_REPEATED_CALLS = 2
+
+	FINISH_REASON_STOP           = "stop"
+	FINISH_REASON_TOOL_CALLS     = "tool_calls"
+	FINISH_REASON_FUNCTION_CALL  = "function_call"
+	FINISH_REASON_LENGTH         = "length"
+	FINISH_REASON_CONTENT_FILTER = "content_filter"
+)
+
+var (
+	ErrMaxSteps         = errors.New("agent loop: max steps exceeded")
+	ErrLoopDetected     = errors.New("agent loop: repeated identical tool calls")
+	ErrTimeout          = errors.New("agent loop: turn deadline exceeded")
+	ErrLength           = errors.New("agent loop: completion truncated by token limit")
+	ErrContentFilter    = errors.New("agent loop: completion blocked by content filter")
+	ErrUnexpectedFinish = errors.New("agent loop: unexpected finish reason")
+	ErrEmptyCompletion  = errors.New("agent loop: completion has no choices")
+	ErrMissingToolCalls = errors.New("agent loop: tool_calls finish without tool calls")
+)
+
+// RunResult is the outcome of an agent loop run
+type RunResult struct {
+	// Answer is the final assistant message
+	Answer ChatCompletionMessage
+	// Steps is the number of completions requested
+	Steps int
+	// Usage is the token usage summed over all steps
+	Usage ChatCompletionUsage
+	// Model is the model reported by the last completion
+	Model string
+}
+
+// Runner runs the completion and tool call loop until the model stops or one of the limits is hit
+type Runner struct {
+	provider   Provider
+	registry   *Registry
+	maxSteps   int
+	maxRepeats int
+	timeout    time.Duration
+}
+
+// RunnerOption configures the Runner
+type RunnerOption func(*Runner)
+
+// WithMaxSteps limits the number of completions in one run
+func WithMaxSteps(steps int) RunnerOption {
+	return func(r *Runner) {
+		if steps > 0 {
+			r.maxSteps = steps
+		}
+	}
+}
+
+// WithMaxRepeatedCalls limits how many times the same set of tool calls may be requested in one run
+func WithMaxRepeatedCalls(repeats int) RunnerOption {
+	return func(r *Runner) {
+		if repeats > 0 {
+			r.maxRepeats = repeats
+		}
+	}
+}
+
+// WithTimeout sets the deadline of one run
+func WithTimeout(timeout time.Duration) RunnerOption {
+	return func(r *Runner) {
+		r.timeout = timeout
+	}
+}
+
+func NewRunner(provider Provider, registry *Registry, opts ...RunnerOption) *Runner {
+	r := &Runner{
+		provider:   provider,
+		registry:   registry,
+		maxSteps:   DEFAULT_MAX_STEPS,
+		maxRepeats: DEFAULT_MAX_REPEATED_CALLS,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// WithRegistry returns a copy of the runner executing the functions of the registry
+func (r *Runner) WithRegistry(registry *Registry) *Runner {
+	runner := *r
+	runner.registry = registry
+	return &runner
+}
+
+// Run sends the request and executes the requested tool calls until the model returns an answer.
+// The request messages are extended with every assistant and tool message of the run.
+// Completions are streamed to the handler if it is not nil.
+func (r *Runner) Run(ctx context.Context, request *ChatCompletionRequest, handler StreamHandler) (*RunResult, error) {
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+	result := &RunResult{}
+	calls := make(map[string]int)
+	for result.Steps < r.maxSteps {
+		result.Steps++
+		response, err := r.complete(ctx, request, handler)
+		if err != nil {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return result, fmt.Errorf("%w: %v", ErrTimeout, err)
+			}
+			return result, err
+		}
+		result.Usage.PromptTokens += response.Usage.PromptTokens
+		result.Usage.CompletionTokens += response.Usage.CompletionTokens
+		result.Usage.TotalTokens += response.Usage.TotalTokens
+		result.Model = response.Model
+		if len(response.Choices) == 0 {
+			return result, ErrEmptyCompletion
+		}
+		choice := response.Choices[0]
+		finishReason := choice.FinishReason
+		// some compatible servers finish with stop even when tools are called
+		if len(choice.Message.ToolCalls) > 0 && finishReason == FINISH_REASON_STOP {
+			finishReason = FINISH_REASON_TOOL_CALLS
+		}
+		switch finishReason {
+		case FINISH_REASON_STOP:
+			request.Messages = append(request.Messages, choice.Message)
+			result.Answer = choice.Message
+			return result, nil
+		case FINISH_REASON_TOOL_CALLS, FINISH_REASON_FUNCTION_CALL:
+			if len(choice.Message.ToolCalls) == 0 {
+				return result, ErrMissingToolCalls
+			}
+			signature := toolCallsSignature(choice.Message.ToolCalls)
+			calls[signature]++
+			if calls[signature] > r.maxRepeats {
+				return result, fmt.Errorf("%w: %s", ErrLoopDetected, signature)
+			}
+			request.AddToolCalls(choice.Message, r.registry.ExecuteToolCalls(ctx, choice.Message.ToolCalls))
+		case FINISH_REASON_LENGTH:
+			return result, ErrLength
+		case FINISH_REASON_CONTENT_FILTER:
+			return result, ErrContentFilter
+		default:
+			return result, fmt.Errorf("%w: %q", ErrUnexpectedFinish, choice.FinishReason)
+		}
+	}
+	return result, fmt.Errorf("%w: %d steps", ErrMaxSteps, r.maxSteps)
+}
+
+func (r *Runner) complete(ctx context.Context, request *ChatCompletionRequest, handler StreamHandler) (*ChatCompletionResponse, error) {
+	if handler != nil {
+		return r.provider.DoStreamRequest(ctx, request, handler)
+	}
+	return r.provider.DoRequest(ctx, request)
+}
+
+// toolCallsSignature returns a key identifying the set of tool calls regardless of their order and ids
+func toolCallsSignature(calls []ToolCall) string {
+	parts := make([]string, len(calls))
+	for i, call := range calls {
+		parts[i] = fmt.Sprintf("%s(%s)", call.Function.Name, strings.TrimSpace(call.Function.Arguments))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ";")
+}
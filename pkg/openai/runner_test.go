@@ -0,0 +1,209 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func answerResponse(content string, finishReason string) *ChatCompletionResponse {
+	return &ChatCompletionResponse{
+		Model: "mock",
+		Choices: []ChatCompletionChoice{{
+			Message:      ChatCompletionMessage{Role: "assistant", Content: content},
+			FinishReason: finishReason,
+		}},
+		Usage: ChatCompletionUsage{PromptTokens: 3, CompletionTokens: 1, TotalTokens: 4},
+	}
+}
+
+func toolCallResponse(finishReason string, arguments ...string) *ChatCompletionResponse {
+	calls := make([]ToolCall, len(arguments))
+	for i, a := range arguments {
+		calls[i] = ToolCall{ID: fmt.Sprintf("call_%d", i), Type: TOOL_TYPE_FUNCTION, Function: FunctionCall{Name: "echo", Arguments: a}}
+	}
+	response := answerResponse("", finishReason)
+	response.Choices[0].Message.ToolCalls = calls
+	return response
+}
+
+type echoArgs struct {
+	Text string `json:"text"`
+}
+
+func TestRunner(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses []*ChatCompletionResponse
+		opts      []RunnerOption
+		err       error
+		steps     int
+		answer    string
+	}{
+		{
+			name:      "answer",
+			responses: []*ChatCompletionResponse{answerResponse("hi", FINISH_REASON_STOP)},
+			steps:     1,
+			answer:    "hi",
+		},
+		{
+			name: "tool calls then answer",
+			responses: []*ChatCompletionResponse{
+				toolCallResponse(FINISH_REASON_TOOL_CALLS, `{"text":"a"}`, `{"text":"b"}`),
+				answerResponse("done", FINISH_REASON_STOP),
+			},
+			steps:  2,
+			answer: "done",
+		},
+		{
+			name: "tool calls with stop finish",
+			responses: []*ChatCompletionResponse{
+				toolCallResponse(FINISH_REASON_STOP, `{"text":"a"}`),
+				answerResponse("done", FINISH_REASON_STOP),
+			},
+			steps:  2,
+			answer: "done",
+		},
+		{
+			name: "max steps",
+			responses: []*ChatCompletionResponse{
+				toolCallResponse(FINISH_REASON_TOOL_CALLS, `{"text":"a"}`),
+				toolCallResponse(FINISH_REASON_TOOL_CALLS, `{"text":"b"}`),
+				toolCallResponse(FINISH_REASON_TOOL_CALLS, `{"text":"c"}`),
+			},
+			opts:  []RunnerOption{WithMaxSteps(2)},
+			err:   ErrMaxSteps,
+			steps: 2,
+		},
+		{
+			name: "repeated calls",
+			responses: []*ChatCompletionResponse{
+				toolCallResponse(FINISH_REASON_TOOL_CALLS, `{"text":"a"}`, `{"text":"b"}`),
+				toolCallResponse(FINISH_REASON_TOOL_CALLS, `{"text":"b"}`, `{"text":"a"}`),
+				toolCallResponse(FINISH_REASON_TOOL_CALLS, `{"text":"a"}`, `{"text":"b"}`),
+			},
+			err:   ErrLoopDetected,
+			steps: 3,
+		},
+		{
+			name:      "tool calls finish without calls",
+			responses: []*ChatCompletionResponse{answerResponse("", FINISH_REASON_TOOL_CALLS)},
+			err:       ErrMissingToolCalls,
+			steps:     1,
+		},
+		{
+			name:      "length",
+			responses: []*ChatCompletionResponse{answerResponse("cut", FINISH_REASON_LENGTH)},
+			err:       ErrLength,
+			steps:     1,
+		},
+		{
+			name:      "content filter",
+			responses: []*ChatCompletionResponse{answerResponse("", FINISH_REASON_CONTENT_FILTER)},
+			err:       ErrContentFilter,
+			steps:     1,
+		},
+		{
+			name:      "unexpected finish",
+			responses: []*ChatCompletionResponse{answerResponse("", "other")},
+			err:       ErrUnexpectedFinish,
+			steps:     1,
+		},
+		{
+			name:      "no choices",
+			responses: []*ChatCompletionResponse{{}},
+			err:       ErrEmptyCompletion,
+			steps:     1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := NewRegistry()
+			RegisterFunc(registry, "echo", "Echoes the text", func(_ context.Context, args echoArgs) (string, error) {
+				return args.Text, nil
+			})
+			provider := NewMockProvider(tt.responses...)
+			request := &ChatCompletionRequest{Messages: []ChatCompletionMessage{{Role: "user", Content: "hello"}}}
+			result, err := NewRunner(provider, registry, tt.opts...).Run(context.Background(), request, nil)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+			if result.Steps != tt.steps {
+				t.Errorf("steps = %d, want %d", result.Steps, tt.steps)
+			}
+			want := 0
+			for _, response := range tt.responses[:tt.steps] {
+				want += response.Usage.TotalTokens
+			}
+			if result.Usage.TotalTokens != want {
+				t.Errorf("total tokens = %d, want %d", result.Usage.TotalTokens, want)
+			}
+			if result.Answer.Content != tt.answer {
+				t.Errorf("answer = %q, want %q", result.Answer.Content, tt.answer)
+			}
+		})
+	}
+}
+
+func TestRunnerAddsToolResults(t *testing.T) {
+	registry := NewRegistry()
+	RegisterFunc(registry, "echo", "Echoes the text", func(_ context.Context, args echoArgs) (string, error) {
+		return args.Text, nil
+	})
+	provider := NewMockProvider(
+		toolCallResponse(FINISH_REASON_TOOL_CALLS, `{"text":"a"}`, `{}`),
+		answerResponse("done", FINISH_REASON_STOP),
+	)
+	request := &ChatCompletionRequest{Messages: []ChatCompletionMessage{{Role: "user", Content: "hello"}}}
+	if _, err := NewRunner(provider, registry).Run(context.Background(), request, nil); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	second := provider.Requests()[1].Messages
+	if len(second) != 4 {
+		t.Fatalf("second request has %d messages, want user, assistant and 2 tool results", len(second))
+	}
+	if second[2].ToolCallID != "call_0" || second[2].Content != `"a"` {
+		t.Errorf("first tool result = %+v", second[2])
+	}
+	var validationErr *ValidationError
+	if second[3].ToolCallID != "call_1" || !errors.As(ValidateArguments(registry.Descriptions()[0], `{}`), &validationErr) ||
+		second[3].Content != validationErr.Error() {
+		t.Errorf("invalid arguments result = %+v", second[3])
+	}
+	if len(request.Messages) != 5 || request.Messages[4].Content != "done" {
+		t.Errorf("run did not extend the request with the answer: %+v", request.Messages)
+	}
+}
+
+func TestRunnerTimeout(t *testing.T) {
+	registry := NewRegistry()
+	RegisterFunc(registry, "echo", "Echoes the text", func(ctx context.Context, args echoArgs) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	})
+	provider := NewMockProvider(
+		toolCallResponse(FINISH_REASON_TOOL_CALLS, `{"text":"a"}`),
+		answerResponse("late", FINISH_REASON_STOP),
+	)
+	request := &ChatCompletionRequest{}
+	_, err := NewRunner(provider, registry, WithTimeout(50*time.Millisecond)).Run(context.Background(), request, nil)
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("error = %v, want %v", err, ErrTimeout)
+	}
+}
+
+func TestRunnerStreams(t *testing.T) {
+	provider := NewMockProvider(answerResponse("hello there", FINISH_REASON_STOP))
+	var streamed string
+	result, err := NewRunner(provider, NewRegistry()).Run(context.Background(), &ChatCompletionRequest{}, func(delta string) {
+		streamed += delta
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if streamed != "hello there" || result.Answer.Content != "hello there" {
+		t.Errorf("streamed %q, answer %q", streamed, result.Answer.Content)
+	}
+}
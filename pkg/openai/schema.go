@@ -0,0 +1,285 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+//goland:noinspection GoSnakeCaseUsage
+const (
+	SCHEMA_FORMAT_DATE_TIME = "date-time"
+	SCHEMA_FORMAT_DATE      = "date"
+)
+
+// FieldError is a single argument that does not match the parameters schema
+type FieldError struct {
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned to the model when the function arguments do not match the parameters schema,
+// it is encoded as JSON so the model can correct the arguments and call the function again
+type ValidationError struct {
+	Function string       `json:"function"`
+	Errors   []FieldError `json:"errors"`
+	Hint     string       `json:"hint"`
+}
+
+func (e *ValidationError) Error() string {
+	b, err := json.Marshal(struct {
+		Error string `json:"error"`
+		*ValidationError
+	}{
+		Error:           "invalid arguments",
+		ValidationError: e,
+	})
+	if err != nil {
+		return fmt.Sprintf("invalid arguments for %s", e.Function)
+	}
+	return string(b)
+}
+
+// ValidateArguments checks the JSON encoded arguments against the parameters schema of the function.
+// The supported keywords are type, properties, required, additionalProperties, items, enum and format.
+func ValidateArguments(desc FunctionDescription, arguments string) error {
+	if desc.Parameters == nil {
+		return nil
+	}
+	if strings.TrimSpace(arguments) == "" {
+		arguments = "{}"
+	}
+	decoder := json.NewDecoder(bytes.NewBufferString(arguments))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return &ValidationError{
+			Function: desc.Name,
+			Errors:   []FieldError{{Message: fmt.Sprintf("arguments are not valid JSON: %v", err)}},
+			Hint:     "send the arguments as a JSON object",
+		}
+	}
+	errs := validateValue("", desc.Parameters, value)
+	if len(errs) == 0 {
+		return nil
+	}
+	return &ValidationError{
+		Function: desc.Name,
+		Errors:   errs,
+		Hint:     "fix the listed arguments and call the function again",
+	}
+}
+
+// validateValue returns the errors of the value at path against the schema
+func validateValue(path string, schema map[string]interface{}, value interface{}) []FieldError {
+	schemaType, _ := schema["type"].(string)
+	if value == nil {
+		if schemaType == "" || schemaType == "null" {
+			return nil
+		}
+		return []FieldError{{Field: path, Message: fmt.Sprintf("must be %s, got null", withArticle(schemaType))}}
+	}
+	switch schemaType {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return []FieldError{typeError(path, schemaType, value)}
+		}
+		return validateObject(path, schema, object)
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return []FieldError{typeError(path, schemaType, value)}
+		}
+		items, _ := schema["items"].(map[string]interface{})
+		if items == nil {
+			return nil
+		}
+		var errs []FieldError
+		for i, item := range array {
+			errs = append(errs, validateValue(fmt.Sprintf("%s[%d]", path, i), items, item)...)
+		}
+		return errs
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return []FieldError{typeError(path, schemaType, value)}
+		}
+		if err := validateFormat(schema, s); err != nil {
+			return []FieldError{{Field: path, Message: err.Error()}}
+		}
+	case "integer":
+		n, ok := value.(json.Number)
+		if !ok {
+			return []FieldError{typeError(path, schemaType, value)}
+		}
+		if _, err := n.Int64(); err != nil {
+			return []FieldError{{Field: path, Message: fmt.Sprintf("must be an integer, got %s", n)}}
+		}
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			return []FieldError{typeError(path, schemaType, value)}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []FieldError{typeError(path, schemaType, value)}
+		}
+	}
+	if enum := schemaEnum(schema); len(enum) > 0 {
+		v := fmt.Sprint(value)
+		for _, e := range enum {
+			if e == v {
+				return nil
+			}
+		}
+		return []FieldError{{Field: path, Message: fmt.Sprintf("must be one of [%s], got %q", strings.Join(enum, ", "), v)}}
+	}
+	return nil
+}
+
+// dropEmptyOptional removes the optional properties set to an empty string from the object and its nested objects,
+// the models send "" for the arguments they mean to leave out
+func dropEmptyOptional(schema map[string]interface{}, object map[string]interface{}) {
+	required := make(map[string]bool)
+	for _, name := range schemaStrings(schema["required"]) {
+		required[name] = true
+	}
+	properties, _ := schema["properties"].(map[string]interface{})
+	for name, value := range object {
+		if value == "" && !required[name] {
+			delete(object, name)
+			continue
+		}
+		property, _ := properties[name].(map[string]interface{})
+		if nested, ok := value.(map[string]interface{}); ok && property != nil {
+			dropEmptyOptional(property, nested)
+		}
+	}
+}
+
+// omitEmptyOptional returns the JSON encoded arguments without the optional properties set to an empty string,
+// the arguments that are not a JSON object are returned as they are
+func omitEmptyOptional(schema map[string]interface{}, arguments string) string {
+	decoder := json.NewDecoder(bytes.NewBufferString(arguments))
+	decoder.UseNumber()
+	var object map[string]interface{}
+	if schema == nil || decoder.Decode(&object) != nil || object == nil {
+		return arguments
+	}
+	dropEmptyOptional(schema, object)
+	b, err := json.Marshal(object)
+	if err != nil {
+		return arguments
+	}
+	return string(b)
+}
+
+// validateObject checks the required properties, the known properties and the unknown ones if they are not allowed,
+// an optional property set to an empty string is treated as absent
+func validateObject(path string, schema map[string]interface{}, object map[string]interface{}) []FieldError {
+	var errs []FieldError
+	dropEmptyOptional(schema, object)
+	properties, _ := schema["properties"].(map[string]interface{})
+	for _, name := range schemaStrings(schema["required"]) {
+		if v, ok := object[name]; !ok || v == nil {
+			errs = append(errs, FieldError{Field: joinPath(path, name), Message: "is required"})
+		}
+	}
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		property, ok := properties[name].(map[string]interface{})
+		if !ok {
+			if allowed, isBool := schema["additionalProperties"].(bool); isBool && !allowed {
+				errs = append(errs, FieldError{Field: joinPath(path, name), Message: "is not a known parameter"})
+			}
+			continue
+		}
+		if object[name] == nil {
+			continue
+		}
+		errs = append(errs, validateValue(joinPath(path, name), property, object[name])...)
+	}
+	return errs
+}
+
+// validateFormat checks the string formats used by the functions
+func validateFormat(schema map[string]interface{}, s string) error {
+	format, _ := schema["format"].(string)
+	switch format {
+	case SCHEMA_FORMAT_DATE_TIME:
+		if _, err := time.Parse(time.RFC3339, s); err != nil {
+			return fmt.Errorf("must be an RFC3339 date-time like 2006-01-02T15:04:05Z07:00, got %q", s)
+		}
+	case SCHEMA_FORMAT_DATE:
+		if _, err := time.Parse("2006-01-02", s); err != nil {
+			return fmt.Errorf("must be a date like 2006-01-02, got %q", s)
+		}
+	}
+	return nil
+}
+
+func typeError(path string, schemaType string, value interface{}) FieldError {
+	return FieldError{Field: path, Message: fmt.Sprintf("must be %s, got %s", withArticle(schemaType), jsonType(value))}
+}
+
+// jsonType returns the JSON type name of a decoded value
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	case bool:
+		return "boolean"
+	default:
+		return "null"
+	}
+}
+
+func withArticle(schemaType string) string {
+	switch schemaType {
+	case "object", "array", "integer":
+		return "an " + schemaType
+	default:
+		return "a " + schemaType
+	}
+}
+
+func joinPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// schemaEnum returns the enum values of the schema as strings
+func schemaEnum(schema map[string]interface{}) []string {
+	return schemaStrings(schema["enum"])
+}
+
+// schemaStrings returns a schema list keyword given as []string or []interface{}
+func schemaStrings(v interface{}) []string {
+	switch list := v.(type) {
+	case []string:
+		return list
+	case []interface{}:
+		strs := make([]string, len(list))
+		for i, item := range list {
+			strs[i] = fmt.Sprint(item)
+		}
+		return strs
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,136 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type schemaTestBase struct {
+	ID string `json:"id" description:"The ID."`
+}
+
+type schemaTestArgs struct {
+	schemaTestBase
+	Title    string     `json:"title" description:"The title."`
+	Start    time.Time  `json:"start"`
+	End      *time.Time `json:"end"`
+	Count    int        `json:"count,omitempty"`
+	Ratio    float64    `json:"ratio,omitempty"`
+	Private  bool       `json:"private,omitempty"`
+	Kind     string     `json:"kind,omitempty" enum:"a,b"`
+	Day      string     `json:"day,omitempty" format:"date"`
+	Guests   []string   `json:"guests,omitempty"`
+	Skipped  string     `json:"-"`
+	internal string
+}
+
+func TestSchemaOf(t *testing.T) {
+	schema := SchemaOf(schemaTestArgs{})
+	if schema["type"] != "object" || schema["additionalProperties"] != false {
+		t.Fatalf("schema = %v, want a closed object", schema)
+	}
+	if required := schema["required"]; !reflect.DeepEqual(required, []string{"id", "title", "start"}) {
+		t.Errorf("required = %v, want [id title start]", required)
+	}
+	properties := schema["properties"].(map[string]interface{})
+	tests := []struct {
+		name string
+		want map[string]interface{}
+	}{
+		{"id", map[string]interface{}{"type": "string", "description": "The ID."}},
+		{"title", map[string]interface{}{"type": "string", "description": "The title."}},
+		{"start", map[string]interface{}{"type": "string", "format": SCHEMA_FORMAT_DATE_TIME}},
+		{"end", map[string]interface{}{"type": "string", "format": SCHEMA_FORMAT_DATE_TIME}},
+		{"count", map[string]interface{}{"type": "integer"}},
+		{"ratio", map[string]interface{}{"type": "number"}},
+		{"private", map[string]interface{}{"type": "boolean"}},
+		{"kind", map[string]interface{}{"type": "string", "enum": []string{"a", "b"}}},
+		{"day", map[string]interface{}{"type": "string", "format": SCHEMA_FORMAT_DATE}},
+		{"guests", map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}}},
+	}
+	for _, tt := range tests {
+		if got := properties[tt.name]; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("property %s = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if len(properties) != len(tests) {
+		t.Errorf("got %d properties, want %d: %v", len(properties), len(tests), properties)
+	}
+}
+
+func TestValidateArguments(t *testing.T) {
+	desc := FunctionDescription{Name: "test", Parameters: SchemaOf(schemaTestArgs{})}
+	valid := `"id":"1","title":"t","start":"2024-01-02T10:00:00Z"`
+	tests := []struct {
+		name      string
+		arguments string
+		want      []FieldError
+	}{
+		{"valid", `{` + valid + `}`, nil},
+		{"valid optional", `{` + valid + `,"end":null,"count":2,"ratio":0.5,"private":true,"kind":"b","day":"2024-01-02","guests":["a@b"]}`, nil},
+		{"not json", `{`, []FieldError{{Message: "arguments are not valid JSON: unexpected EOF"}}},
+		{"empty", ``, []FieldError{
+			{Field: "id", Message: "is required"},
+			{Field: "title", Message: "is required"},
+			{Field: "start", Message: "is required"},
+		}},
+		{"null required", `{"id":null,"title":"t","start":"2024-01-02T10:00:00Z"}`, []FieldError{{Field: "id", Message: "is required"}}},
+		{"not an object", `[]`, []FieldError{{Message: "must be an object, got array"}}},
+		{"wrong type", `{` + valid + `,"count":"2"}`, []FieldError{{Field: "count", Message: "must be an integer, got string"}}},
+		{"fraction", `{` + valid + `,"count":1.5}`, []FieldError{{Field: "count", Message: "must be an integer, got 1.5"}}},
+		{"bad date-time", `{"id":"1","title":"t","start":"tomorrow"}`, []FieldError{
+			{Field: "start", Message: `must be an RFC3339 date-time like 2006-01-02T15:04:05Z07:00, got "tomorrow"`},
+		}},
+		{"bad date", `{` + valid + `,"day":"02.01.2024"}`, []FieldError{{Field: "day", Message: `must be a date like 2006-01-02, got "02.01.2024"`}}},
+		{"enum", `{` + valid + `,"kind":"c"}`, []FieldError{{Field: "kind", Message: `must be one of [a, b], got "c"`}}},
+		{"empty optional", `{` + valid + `,"end":"","kind":"","day":""}`, nil},
+		{"empty required", `{"id":"1","title":"","start":""}`, []FieldError{
+			{Field: "start", Message: `must be an RFC3339 date-time like 2006-01-02T15:04:05Z07:00, got ""`},
+		}},
+		{"array item", `{` + valid + `,"guests":["a@b",1]}`, []FieldError{{Field: "guests[1]", Message: "must be a string, got number"}}},
+		{"unknown", `{` + valid + `,"color":"red"}`, []FieldError{{Field: "color", Message: "is not a known parameter"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateArguments(desc, tt.arguments)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("ValidateArguments: %v", err)
+				}
+				return
+			}
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("error = %v, want a validation error", err)
+			}
+			if !reflect.DeepEqual(validationErr.Errors, tt.want) {
+				t.Errorf("errors = %+v, want %+v", validationErr.Errors, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateArgumentsWithoutSchema(t *testing.T) {
+	if err := ValidateArguments(FunctionDescription{Name: "test"}, `not json`); err != nil {
+		t.Errorf("ValidateArguments without parameters: %v", err)
+	}
+}
+
+func TestRegisterFuncEmptyOptional(t *testing.T) {
+	registry := NewRegistry()
+	var got schemaTestArgs
+	RegisterFunc(registry, "test", "Tests the arguments", func(_ context.Context, args schemaTestArgs) (string, error) {
+		got = args
+		return "ok", nil
+	})
+	result := registry.Execute(context.Background(), "test", `{"id":"1","title":"t","start":"2024-01-02T10:00:00Z","end":"","kind":"","day":""}`)
+	if result != `"ok"` {
+		t.Fatalf("Execute = %s, want the function called", result)
+	}
+	if got.End != nil || got.Kind != "" || got.Day != "" {
+		t.Errorf("args = %+v, want the empty optional arguments absent", got)
+	}
+}
@@ -0,0 +1,169 @@
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+var (
+	streamDataPrefix  = []byte("data:")
+	streamEventPrefix = []byte("event:")
+	streamDone        = []byte("[DONE]")
+	streamErrorEvent  = []byte("error")
+)
+
+type chatCompletionStreamChoice struct {
+	Index        int                   `json:"index"`
+	Delta        ChatCompletionMessage `json:"delta"`
+	FinishReason string                `json:"finish_reason"`
+}
+
+type chatCompletionStreamResponse struct {
+	ID      string                       `json:"id"`
+	Object  string                       `json:"object"`
+	Created int64                        `json:"created"`
+	Model   string                       `json:"model"`
+	Choices []chatCompletionStreamChoice `json:"choices"`
+	// Usage is sent in the last chunk when the request includes the usage, the chunk has no choices
+	Usage *ChatCompletionUsage `json:"usage"`
+	// Error is set when the server fails after the stream started
+	Error *struct {
+		Message string      `json:"message"`
+		Type    string      `json:"type"`
+		Param   string      `json:"param"`
+		Code    interface{} `json:"code"`
+	} `json:"error"`
+}
+
+// streamError returns the API error of the error chunk
+func streamError(chunk *chatCompletionStreamResponse) *APIError {
+	apiErr := &APIError{
+		Type:    chunk.Error.Type,
+		Param:   chunk.Error.Param,
+		Message: chunk.Error.Message,
+	}
+	if chunk.Error.Code != nil {
+		apiErr.Code = fmt.Sprint(chunk.Error.Code)
+	}
+	return apiErr
+}
+
+// StreamHandler is called with every content delta received from the stream
+type StreamHandler func(delta string)
+
+// DoStreamRequest sends the request with streaming enabled, passes every content delta to the handler
+// and returns the response assembled from all the received deltas.
+func (c *Client) DoStreamRequest(ctx context.Context, request *ChatCompletionRequest, handler StreamHandler) (*ChatCompletionResponse, error) {
+	request.Model = c.model
+	request.Stream = true
+	request.StreamOptions = &StreamOptions{IncludeUsage: true}
+	defer func() {
+		request.Stream = false
+		request.StreamOptions = nil
+	}()
+	resp, err := c.send(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+		}
+	}(resp.Body)
+	return readStream(resp.Body, handler)
+}
+
+// readStream reads server-sent events and accumulates the deltas into a single response.
+// An error event or chunk is returned as an APIError, a stream ending before [DONE] as ErrStreamInterrupted.
+func readStream(body io.Reader, handler StreamHandler) (*ChatCompletionResponse, error) {
+	response := &ChatCompletionResponse{}
+	reader := bufio.NewReader(body)
+	var event []byte
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("%w: %v", ErrStreamInterrupted, err)
+		}
+		line = bytes.TrimSpace(line)
+		switch {
+		case len(line) == 0:
+			// an empty line ends the event
+			event = nil
+		case bytes.HasPrefix(line, streamEventPrefix):
+			event = bytes.TrimSpace(bytes.TrimPrefix(line, streamEventPrefix))
+		case bytes.HasPrefix(line, streamDataPrefix):
+			data := bytes.TrimSpace(bytes.TrimPrefix(line, streamDataPrefix))
+			if bytes.Equal(data, streamDone) {
+				return response, nil
+			}
+			var chunk chatCompletionStreamResponse
+			unmarshalErr := json.Unmarshal(data, &chunk)
+			if unmarshalErr == nil && chunk.Error != nil {
+				return nil, streamError(&chunk)
+			}
+			if bytes.Equal(event, streamErrorEvent) {
+				return nil, &APIError{Message: string(data)}
+			}
+			if unmarshalErr != nil {
+				return nil, unmarshalErr
+			}
+			response.accumulate(&chunk, handler)
+		}
+		if err == io.EOF {
+			return nil, ErrStreamInterrupted
+		}
+	}
+}
+
+// accumulate merges the stream chunk into the response
+func (r *ChatCompletionResponse) accumulate(chunk *chatCompletionStreamResponse, handler StreamHandler) {
+	r.ID = chunk.ID
+	r.Object = chunk.Object
+	r.Created = chunk.Created
+	if chunk.Model != "" {
+		r.Model = chunk.Model
+	}
+	if chunk.Usage != nil {
+		r.Usage = *chunk.Usage
+	}
+	for _, choice := range chunk.Choices {
+		for len(r.Choices) <= choice.Index {
+			r.Choices = append(r.Choices, ChatCompletionChoice{Index: len(r.Choices)})
+		}
+		c := &r.Choices[choice.Index]
+		if choice.Delta.Role != "" {
+			c.Message.Role = choice.Delta.Role
+		}
+		if choice.Delta.Content != "" {
+			c.Message.Content += choice.Delta.Content
+			if handler != nil && choice.Index == 0 {
+				handler(choice.Delta.Content)
+			}
+		}
+		for _, delta := range choice.Delta.ToolCalls {
+			index := len(c.Message.ToolCalls)
+			if delta.Index != nil {
+				index = *delta.Index
+			}
+			for len(c.Message.ToolCalls) <= index {
+				c.Message.ToolCalls = append(c.Message.ToolCalls, ToolCall{})
+			}
+			call := &c.Message.ToolCalls[index]
+			if delta.ID != "" {
+				call.ID = delta.ID
+			}
+			if delta.Type != "" {
+				call.Type = delta.Type
+			}
+			call.Function.Name += delta.Function.Name
+			call.Function.Arguments += delta.Function.Arguments
+		}
+		if choice.FinishReason != "" {
+			c.FinishReason = choice.FinishReason
+		}
+	}
+}
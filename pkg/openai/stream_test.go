@@ -0,0 +1,131 @@
+package openai
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestReadStream(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		content   string
+		deltas    []string
+		toolCalls []ToolCall
+		finish    string
+		usage     ChatCompletionUsage
+		model     string
+	}{
+		{
+			name: "content with usage chunk",
+			body: `data: {"id":"1","model":"gpt","choices":[{"index":0,"delta":{"role":"assistant","content":"Hel"}}]}
+
+data: {"id":"1","model":"gpt","choices":[{"index":0,"delta":{"content":"lo"},"finish_reason":"stop"}]}
+
+data: {"id":"1","model":"gpt-2024","choices":[],"usage":{"prompt_tokens":10,"completion_tokens":2,"total_tokens":12}}
+
+data: [DONE]
+`,
+			content: "Hello",
+			deltas:  []string{"Hel", "lo"},
+			finish:  "stop",
+			usage:   ChatCompletionUsage{PromptTokens: 10, CompletionTokens: 2, TotalTokens: 12},
+			model:   "gpt-2024",
+		},
+		{
+			name: "tool calls split over chunks",
+			body: `data: {"id":"2","model":"gpt","choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"current_time","arguments":""}}]}}]}
+data: {"id":"2","model":"gpt","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{}"}},{"index":1,"id":"call_2","type":"function","function":{"name":"list_events","arguments":"{\"a\":1}"}}]}}]}
+data: {"id":"2","model":"gpt","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}
+data: [DONE]
+`,
+			toolCalls: []ToolCall{
+				{ID: "call_1", Type: "function", Function: FunctionCall{Name: "current_time", Arguments: "{}"}},
+				{ID: "call_2", Type: "function", Function: FunctionCall{Name: "list_events", Arguments: `{"a":1}`}},
+			},
+			finish: "tool_calls",
+			model:  "gpt",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var deltas []string
+			response, err := readStream(strings.NewReader(tt.body), func(delta string) {
+				deltas = append(deltas, delta)
+			})
+			if err != nil {
+				t.Fatalf("readStream: %v", err)
+			}
+			if len(response.Choices) != 1 {
+				t.Fatalf("got %d choices, want 1", len(response.Choices))
+			}
+			message := response.Choices[0].Message
+			if message.Content != tt.content {
+				t.Errorf("content = %q, want %q", message.Content, tt.content)
+			}
+			if strings.Join(deltas, "|") != strings.Join(tt.deltas, "|") {
+				t.Errorf("deltas = %q, want %q", deltas, tt.deltas)
+			}
+			if len(message.ToolCalls) != len(tt.toolCalls) {
+				t.Fatalf("got %d tool calls, want %d", len(message.ToolCalls), len(tt.toolCalls))
+			}
+			for i, call := range message.ToolCalls {
+				want := tt.toolCalls[i]
+				if call.ID != want.ID || call.Type != want.Type || call.Function != want.Function {
+					t.Errorf("tool call %d = %+v, want %+v", i, call, want)
+				}
+			}
+			if response.Choices[0].FinishReason != tt.finish {
+				t.Errorf("finish reason = %q, want %q", response.Choices[0].FinishReason, tt.finish)
+			}
+			if response.Usage != tt.usage {
+				t.Errorf("usage = %+v, want %+v", response.Usage, tt.usage)
+			}
+			if response.Model != tt.model {
+				t.Errorf("model = %q, want %q", response.Model, tt.model)
+			}
+		})
+	}
+}
+
+func TestReadStreamInvalidChunk(t *testing.T) {
+	if _, err := readStream(strings.NewReader("data: {not json}\n"), nil); err == nil {
+		t.Error("readStream accepted an invalid chunk")
+	}
+}
+
+func TestReadStreamErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want error
+		// code is the code of the API error
+		code string
+	}{
+		{"stream without done", `data: {"id":"3","model":"gpt","choices":[{"index":0,"delta":{"content":"Hi"}}]}`, ErrStreamInterrupted, ""},
+		{"empty stream", "", ErrStreamInterrupted, ""},
+		{"error chunk", `data: {"id":"4","model":"gpt","choices":[{"index":0,"delta":{"content":"Hi"}}]}
+
+data: {"error":{"message":"The server had an error","type":"server_error","code":null}}
+`, ErrServerError, ""},
+		{"error chunk with code", `data: {"error":{"message":"Too long","type":"invalid_request_error","code":"context_length_exceeded"}}
+`, ErrContextLengthExceeded, "context_length_exceeded"},
+		{"error event", `event: error
+data: overloaded
+
+`, ErrServerError, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := readStream(strings.NewReader(tt.body), nil)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("readStream = %+v, %v, want %v", response, err, tt.want)
+			}
+			var apiErr *APIError
+			if errors.As(err, &apiErr) && apiErr.Code != tt.code {
+				t.Errorf("code = %q, want %q", apiErr.Code, tt.code)
+			}
+		})
+	}
+}
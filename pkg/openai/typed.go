@@ -0,0 +1,160 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// RegisterFunc registers a typed function handler with the parameters schema reflected from the Args struct.
+// The arguments of the model are decoded into Args, the optional ones set to "" are left out,
+// and the returned Result is encoded as JSON,
+// a returned error is passed to the model as {"error": "..."}.
+func RegisterFunc[Args any, Result any](r *Registry, name string, description string, handler func(ctx context.Context, args Args) (Result, error)) {
+	var zero Args
+	desc := FunctionDescription{
+		Name:        name,
+		Description: description,
+		Parameters:  SchemaOf(zero),
+	}
+	r.Register(name, desc, func(ctx context.Context, arguments string) string {
+		var args Args
+		if strings.TrimSpace(arguments) != "" {
+			// the empty optional arguments are absent, as in the validation
+			arguments = omitEmptyOptional(desc.Parameters, arguments)
+			if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+				return errorResult(err)
+			}
+		}
+		result, err := handler(ctx, args)
+		if err != nil {
+			return errorResult(err)
+		}
+		b, err := json.Marshal(result)
+		if err != nil {
+			return errorResult(err)
+		}
+		return string(b)
+	})
+}
+
+// errorResult returns the error as a JSON object for the model,
+// errors implementing json.Marshaler are returned as they encode themselves
+func errorResult(err error) string {
+	if m, ok := err.(json.Marshaler); ok {
+		if b, err := m.MarshalJSON(); err == nil {
+			return string(b)
+		}
+	}
+	b, _ := json.Marshal(struct {
+		Error string `json:"error"`
+	}{
+		Error: err.Error(),
+	})
+	return string(b)
+}
+
+// SchemaOf returns the JSON Schema of the value type built from the struct tags of its fields:
+//   - json sets the property name, fields with omitempty or of pointer type are optional
+//   - description sets the property description
+//   - enum sets the comma separated allowed values
+//   - format sets the string format, time.Time fields are date-time strings
+func SchemaOf(v interface{}) map[string]interface{} {
+	return typeSchema(reflect.TypeOf(v))
+}
+
+func typeSchema(t reflect.Type) map[string]interface{} {
+	if t == nil {
+		return map[string]interface{}{}
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return map[string]interface{}{
+			"type":   "string",
+			"format": SCHEMA_FORMAT_DATE_TIME,
+		}
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		properties, required := structProperties(t)
+		schema := map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string"}
+		}
+		return map[string]interface{}{
+			"type":  "array",
+			"items": typeSchema(t.Elem()),
+		}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object"}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// structProperties returns the properties and the required property names of the struct fields,
+// the fields of embedded structs are promoted like encoding/json does
+func structProperties(t reflect.Type) (map[string]interface{}, []string) {
+	properties := make(map[string]interface{})
+	required := make([]string, 0)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			embedded, embeddedRequired := structProperties(field.Type)
+			for k, v := range embedded {
+				properties[k] = v
+			}
+			required = append(required, embeddedRequired...)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		schema := typeSchema(field.Type)
+		if description := field.Tag.Get("description"); description != "" {
+			schema["description"] = description
+		}
+		if enum := field.Tag.Get("enum"); enum != "" {
+			schema["enum"] = strings.Split(enum, ",")
+		}
+		if format := field.Tag.Get("format"); format != "" {
+			schema["format"] = format
+		}
+		properties[name] = schema
+		if !strings.Contains(opts, "omitempty") && field.Type.Kind() != reflect.Ptr {
+			required = append(required, name)
+		}
+	}
+	return properties, required
+}